@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/cmd"
+)
+
+func main() {
+	os.Exit(cmd.Execute())
+}
@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
@@ -17,34 +21,136 @@ func NewCaptureCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "capture",
 		Short: "Capture cluster state using MeshSync",
-		Long:  `Capture the state of Kubernetes resources in the cluster using MeshSync.`,
+		Long: `Capture the state of Kubernetes resources in the cluster using MeshSync.
+
+With --watch, a full snapshot is written once informers over the selected
+kinds have synced, and every add, update and delete after it is appended to
+a delta file as NDJSON until the command is interrupted or --watch-duration
+passes. Use compact to fold the deltas into a snapshot at any point in time.
+
+With --save, the snapshot is filed in the snapshot store by cluster and
+timestamp instead of written to --output; see list.
+
+With --sign-key, a detached signature over the snapshot is written next to
+it with a .sig extension; see verify.
+
+With --encrypt-to or --encrypt-passphrase, the snapshot is encrypted with
+age as it is written, so it never touches disk in the clear. Commands that
+read snapshots decrypt them with --identity, or ask for the passphrase.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runCapture(opts)
+			return runCapture(cmd.Context(), opts)
 		},
 	}
 
 	// Add flags specific to capture command
 	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", "meshery", "Namespace where MeshSync is deployed")
-	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "meshsync-snapshot.yaml", "Output file for snapshot")
-	cmd.Flags().StringVarP(&opts.Format, "format", "f", "yaml", "Output format (yaml or json)")
+	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "meshsync-snapshot.yaml", "Output file, or s3://, configmap:// or pvc:// URL, for snapshot, or - for stdout; {timestamp} and {cluster} are filled in")
+	cmd.Flags().StringVarP(&opts.Format, "format", "f", "yaml", "Output format (yaml, yaml-stream, json, ndjson or list)")
+	cmd.Flags().BoolVar(&opts.Overwrite, "overwrite", false, "Replace the output file if it already exists")
+	cmd.Flags().BoolVar(&opts.StripStatus, "strip-status", false, "Leave the status of each resource out of the snapshot")
+	cmd.Flags().BoolVar(&opts.StripServerFields, "strip-server-fields", false, "Leave metadata set by the API server, such as uid, resourceVersion and managedFields, out of the snapshot")
+	cmd.Flags().StringVar(&opts.Compress, "compress", "", "Compress the snapshot (gzip, zstd or none); by default picked from the output extension, e.g. .yaml.gz")
 	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "t", 60*time.Second, "Timeout for capture operation")
 	cmd.Flags().BoolVarP(&opts.AllNamespaces, "all-namespaces", "A", false, "Capture resources from all namespaces")
+	cmd.Flags().StringVar(&opts.Name, "name", meshsync.DefaultSnapshotName, "Snapshot name recorded in the snapshot metadata")
+	cmd.Flags().StringToStringVar(&opts.Labels, "label", nil, "Label recorded in the snapshot metadata as key=value (can be repeated)")
+	cmd.Flags().StringSliceVarP(&opts.Kinds, "kinds", "k", meshsync.DefaultKinds, "Resource types to capture (e.g. deployments,services,ingresses.networking.k8s.io)")
+	cmd.Flags().IntVar(&opts.Concurrency, "concurrency", meshsync.DefaultConcurrency, "Number of list calls to run in parallel")
+	cmd.Flags().BoolVar(&opts.ContinueOnError, "continue-on-error", false, "Record failed lists in the snapshot status and write a partial snapshot instead of failing")
+	cmd.Flags().Int64Var(&opts.ChunkSize, "chunk-size", meshsync.DefaultChunkSize, "Return large lists in chunks rather than all at once (0 disables chunking)")
+	cmd.Flags().BoolVarP(&opts.Watch, "watch", "w", false, "Keep watching after the snapshot and record every change to a delta file")
+	cmd.Flags().StringVar(&opts.DeltaFile, "deltas", "", "Delta file for --watch, or - for stdout (defaults to the output path with a .deltas.ndjson extension)")
+	cmd.Flags().DurationVar(&opts.WatchDuration, "watch-duration", 0, "Stop watching after this long (0 watches until interrupted)")
+	cmd.Flags().BoolVar(&opts.Save, "save", false, "File the snapshot in the snapshot store instead of writing --output")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", "", "PEM Ed25519 or ECDSA private key to sign the snapshot with, writing the signature next to it")
+	cmd.Flags().StringVar(&opts.Signer, "signer", "", "Signer identity recorded in the signature, such as an email address (default: user@host)")
+	cmd.Flags().StringArrayVar(&opts.EncryptTo, "encrypt-to", nil, "Encrypt the snapshot to an age recipient (age1...) or a file of recipients (can be repeated)")
+	cmd.Flags().BoolVar(&opts.EncryptPassphrase, "encrypt-passphrase", false, "Encrypt the snapshot with a passphrase, read from "+passphraseEnv+" or prompted for")
 
 	return cmd
 }
 
 // CaptureOptions contains options for capture command
 type CaptureOptions struct {
-	Namespace     string
-	OutputFile    string
-	Format        string
-	Timeout       time.Duration
-	AllNamespaces bool
+	Namespace         string
+	OutputFile        string
+	Format            string
+	Compress          string
+	Overwrite         bool
+	StripStatus       bool
+	StripServerFields bool
+	Timeout           time.Duration
+	AllNamespaces     bool
+	Name              string
+	Labels            map[string]string
+	Kinds             []string
+	ChunkSize         int64
+	Concurrency       int
+	ContinueOnError   bool
+	Watch             bool
+	DeltaFile         string
+	WatchDuration     time.Duration
+	Save              bool
+	SignKey           string
+	Signer            string
+	EncryptTo         []string
+	EncryptPassphrase bool
 }
 
 // runCapture captures cluster state using MeshSync
-func runCapture(opts *CaptureOptions) error {
-	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+func runCapture(ctx context.Context, opts *CaptureOptions) error {
+	if _, err := meshsync.ParseFormat(opts.Format); err != nil {
+		return err
+	}
+	var store *meshsync.Store
+	outputPath := opts.OutputFile
+	if opts.Save {
+		if err := validateSave(opts.Format, opts.Compress); err != nil {
+			return err
+		}
+		var err error
+		if store, err = openStore(); err != nil {
+			return err
+		}
+		outputPath = store.PathTemplate()
+	}
+	compression, err := snapshotCompression(opts.Compress, outputPath)
+	if err != nil {
+		return err
+	}
+	if opts.SignKey != "" {
+		if err := validateSign(outputPath, opts.Format); err != nil {
+			return err
+		}
+	}
+	signer, err := newSnapshotSigner(opts.SignKey, opts.Signer)
+	if err != nil {
+		return err
+	}
+	if len(opts.EncryptTo) > 0 || opts.EncryptPassphrase {
+		if err := validateEncrypt(opts.Save, opts.Watch, opts.SignKey != ""); err != nil {
+			return err
+		}
+	}
+	recipients, err := snapshotRecipients(opts.EncryptTo, opts.EncryptPassphrase, outputPath)
+	if err != nil {
+		return err
+	}
+	if opts.Watch {
+		if opts.ContinueOnError {
+			return fmt.Errorf("--continue-on-error is not supported with --watch")
+		}
+		if outputPath == meshsync.StdioPath && (opts.DeltaFile == "" || opts.DeltaFile == meshsync.StdioPath) {
+			return fmt.Errorf("give --deltas a file when writing the snapshot to stdout")
+		}
+		if meshsync.IsSinkURL(outputPath) && opts.DeltaFile == "" {
+			return fmt.Errorf("give --deltas a local file when writing the snapshot to %s", outputPath)
+		}
+	}
+
+	// Watching continues past the capture timeout
+	watchCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
 
 	// Create Kubernetes client
@@ -57,21 +163,223 @@ func runCapture(opts *CaptureOptions) error {
 	if err := meshsync.Validate(ctx, client, opts.Namespace); err != nil {
 		return fmt.Errorf("MeshSync validation failed: %w", err)
 	}
+	meshsyncVersion, err := meshsync.DeployedVersion(ctx, client, opts.Namespace)
+	if err != nil {
+		return fmt.Errorf("MeshSync validation failed: %w", err)
+	}
+	outputFile, _ := expandOutputPath(ctx, client, outputPath)
+	if store != nil {
+		if err := prepareStoreOutput(outputFile); err != nil {
+			return err
+		}
+	}
 
-	// Capture snapshot
-	snapshot, err := meshsync.CaptureSnapshot(ctx, client, meshsync.CaptureOptions{
-		Namespace:     opts.Namespace,
-		AllNamespaces: opts.AllNamespaces,
+	captureOpts := meshsync.CaptureOptions{
+		Namespace:       opts.Namespace,
+		AllNamespaces:   opts.AllNamespaces,
+		Name:            opts.Name,
+		Labels:          opts.Labels,
+		MeshSyncVersion: meshsyncVersion,
+		Kinds:           opts.Kinds,
+		ChunkSize:       opts.ChunkSize,
+		Concurrency:     opts.Concurrency,
+		ContinueOnError: opts.ContinueOnError,
+	}
+	fileOpts := meshsync.SnapshotFileOptions{
+		Format:      opts.Format,
+		Compression: compression,
+		Overwrite:   opts.Overwrite,
+		Export: meshsync.ExportOptions{
+			StripStatus:       opts.StripStatus,
+			StripServerFields: opts.StripServerFields,
+		},
+		Recipients: recipients,
+	}
+	if opts.Watch {
+		return runWatch(ctx, watchCtx, client, opts, captureOpts, store, signer, outputFile, fileOpts)
+	}
+
+	// Stream the snapshot to the output file as it is captured
+	file, err := meshsync.CreateSnapshotFile(outputFile, fileOpts)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+
+	status, err := meshsync.StreamSnapshot(ctx, client, captureOpts, file)
+	if err != nil {
+		if status == nil || !status.Incomplete {
+			file.Abort()
+			return fmt.Errorf("failed to capture snapshot: %w", err)
+		}
+		// Keep what was captured before the capture was interrupted or timed out
+		if closeErr := file.Close(status); closeErr != nil {
+			return fmt.Errorf("failed to capture snapshot: %w", errors.Join(err, closeErr))
+		}
+		if store != nil {
+			if storeErr := fileInStore(store, outputFile); storeErr != nil {
+				err = errors.Join(err, storeErr)
+			}
+		}
+		if signErr := signer.sign(outputFile, opts.Overwrite); signErr != nil {
+			err = errors.Join(err, signErr)
+		}
+		return incompleteSnapshotError(status.IncompleteReason, outputFile, err)
+	}
+
+	if err := file.Close(status); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+	if store != nil {
+		if err := fileInStore(store, outputFile); err != nil {
+			return err
+		}
+	}
+	if err := signer.sign(outputFile, opts.Overwrite); err != nil {
+		return err
+	}
+
+	if status != nil && len(status.Errors) > 0 {
+		return &exitCodeError{
+			code: ExitCodePartialSnapshot,
+			err:  fmt.Errorf("partial snapshot saved to %s: %d lists failed, see status.errors", snapshotLocation(outputFile), len(status.Errors)),
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Snapshot captured successfully and saved to %s\n", snapshotLocation(outputFile))
+	return nil
+}
+
+// runWatch writes a full snapshot from informer caches within the capture
+// timeout, then appends deltas until watchCtx ends or --watch-duration passes
+func runWatch(ctx, watchCtx context.Context, client *kube.Client, opts *CaptureOptions, captureOpts meshsync.CaptureOptions, store *meshsync.Store, signer *snapshotSigner, outputFile string, fileOpts meshsync.SnapshotFileOptions) error {
+	if opts.WatchDuration > 0 {
+		var cancel context.CancelFunc
+		watchCtx, cancel = context.WithTimeout(watchCtx, opts.WatchDuration)
+		defer cancel()
+	}
+	deltaPath := opts.DeltaFile
+	if deltaPath == "" {
+		deltaPath = meshsync.DeltaPath(outputFile)
+	}
+
+	watcher, err := meshsync.NewWatcher(client, captureOpts)
+	if err != nil {
+		return fmt.Errorf("failed to watch resources: %w", err)
+	}
+	deltas, err := meshsync.CreateDeltaFile(deltaPath, meshsync.DeltaFileOptions{
+		Overwrite: opts.Overwrite,
+		Export:    fileOpts.Export,
 	})
 	if err != nil {
+		return fmt.Errorf("failed to watch resources: %w", overwriteHint(err))
+	}
+	abortDeltas := func() {
+		deltas.Close()
+		if deltaPath != meshsync.StdioPath {
+			os.Remove(deltaPath)
+		}
+	}
+
+	if err := watcher.Start(watchCtx); err != nil {
+		abortDeltas()
+		return fmt.Errorf("failed to watch resources: %w", err)
+	}
+	file, err := meshsync.CreateSnapshotFile(outputFile, fileOpts)
+	if err != nil {
+		abortDeltas()
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+	status, err := watcher.WriteSnapshot(ctx, file)
+	if err != nil {
+		file.Abort()
+		abortDeltas()
 		return fmt.Errorf("failed to capture snapshot: %w", err)
 	}
+	if err := file.Close(status); err != nil {
+		abortDeltas()
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+	if store != nil {
+		if err := fileInStore(store, outputFile); err != nil {
+			abortDeltas()
+			return err
+		}
+	}
+	if err := signer.sign(outputFile, opts.Overwrite); err != nil {
+		abortDeltas()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot captured and saved to %s, recording changes to %s until interrupted\n", snapshotLocation(outputFile), snapshotLocation(deltaPath))
+
+	err = watcher.WatchDeltas(watchCtx, deltas.Write)
+	if closeErr := deltas.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record changes: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d changes to %s\n", deltas.Count, snapshotLocation(deltaPath))
+	return nil
+}
 
-	// Save snapshot to file
-	if err := meshsync.SaveSnapshot(snapshot, opts.OutputFile, opts.Format); err != nil {
-		return fmt.Errorf("failed to save snapshot: %w", err)
+// expandOutputPath fills in the {timestamp} and {cluster} placeholders of
+// an output path and returns it along with the cluster name it used
+func expandOutputPath(ctx context.Context, client *kube.Client, filePath string) (string, string) {
+	cluster := "unknown"
+	if strings.Contains(filePath, meshsync.ClusterPlaceholder) {
+		if id := meshsync.ClusterID(ctx, client); id != "" {
+			cluster = id
+		} else if client.Context != "" {
+			// Contexts such as EKS ARNs contain characters paths cannot
+			cluster = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(client.Context)
+		}
 	}
+	return meshsync.ExpandSnapshotPath(filePath, time.Now(), cluster), cluster
+}
 
-	fmt.Printf("Snapshot captured successfully and saved to %s\n", opts.OutputFile)
+// validateSave checks the format and compression flags suit a snapshot
+// filed in the store, which holds gzipped YAML
+func validateSave(format, compress string) error {
+	if format != meshsync.FormatYAML {
+		return fmt.Errorf("--save files snapshots as yaml, --format %s is not supported with it", format)
+	}
+	if compress != "" && compress != meshsync.CompressionGzip {
+		return fmt.Errorf("--save files snapshots compressed with gzip, --compress %s is not supported with it", compress)
+	}
 	return nil
-} 
\ No newline at end of file
+}
+
+// snapshotCompression returns the compression to write a snapshot with: the
+// --compress flag if given, otherwise the one the file extension implies
+func snapshotCompression(compress string, filePath string) (string, error) {
+	if compress == "" {
+		return meshsync.CompressionFromPath(filePath), nil
+	}
+	return meshsync.ParseCompression(compress)
+}
+
+// overwriteHint points at --overwrite when a snapshot file already exists
+func overwriteHint(err error) error {
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w, use --overwrite to replace it", err)
+	}
+	return err
+}
+
+// snapshotLocation describes where a snapshot path points for messages
+func snapshotLocation(filePath string) string {
+	if filePath == meshsync.StdioPath {
+		return "stdout"
+	}
+	return filePath
+}
+
+// incompleteSnapshotError reports that a capture stopped early for reason
+// after saving what it had captured to outputFile, with the exit code
+// capture and run share for partial snapshots
+func incompleteSnapshotError(reason, outputFile string, err error) error {
+	return &exitCodeError{
+		code: ExitCodePartialSnapshot,
+		err:  fmt.Errorf("capture %s, incomplete snapshot saved to %s: %w", reason, snapshotLocation(outputFile), err),
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
@@ -17,9 +18,9 @@ func NewCleanupCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cleanup",
 		Short: "Cleanup MeshSync resources",
-		Long:  `Remove MeshSync resources deployed for snapshot capture.`,
+		Long:  `Remove MeshSync resources deployed for snapshot capture, including the capture schedule installed by deploy --schedule.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runCleanup(opts)
+			return runCleanup(cmd.Context(), opts)
 		},
 	}
 
@@ -39,8 +40,8 @@ type CleanupOptions struct {
 }
 
 // runCleanup removes MeshSync resources from the cluster
-func runCleanup(opts *CleanupOptions) error {
-	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+func runCleanup(ctx context.Context, opts *CleanupOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
 
 	// Create Kubernetes client
@@ -49,6 +50,12 @@ func runCleanup(opts *CleanupOptions) error {
 		return fmt.Errorf("error creating kubernetes client: %w", err)
 	}
 
+	// Remove the capture schedule first, so no capture starts against a
+	// MeshSync that is going away
+	if err := meshsync.CleanupSchedule(ctx, client, opts.Namespace); err != nil {
+		return fmt.Errorf("failed to cleanup MeshSync resources: %w", err)
+	}
+
 	// Cleanup MeshSync resources
 	err = meshsync.Cleanup(ctx, client, meshsync.CleanupOptions{
 		Namespace: opts.Namespace,
@@ -58,6 +65,6 @@ func runCleanup(opts *CleanupOptions) error {
 		return fmt.Errorf("failed to cleanup MeshSync resources: %w", err)
 	}
 
-	fmt.Printf("MeshSync resources cleaned up successfully from namespace %s\n", opts.Namespace)
+	fmt.Fprintf(os.Stderr, "MeshSync resources cleaned up successfully from namespace %s\n", opts.Namespace)
 	return nil
 } 
\ No newline at end of file
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewCompactCommand creates a new command for folding watch deltas into a snapshot
+func NewCompactCommand() *cobra.Command {
+	opts := &CompactOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Fold watch deltas into a full snapshot",
+		Long: `Fold the deltas recorded by capture --watch into the snapshot written when
+the watch started, giving a full snapshot of the cluster at any point in
+time since. Without --at, every recorded delta is folded in.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCompact(opts)
+		},
+	}
+
+	// Add flags specific to compact command
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Snapshot written when the watch started, store reference such as prod@latest, or - for stdin")
+	cmd.Flags().StringVar(&opts.DeltaFile, "deltas", "", "Delta file recorded by the watch (defaults to the input path with a .deltas.ndjson extension)")
+	cmd.Flags().StringVar(&opts.At, "at", "", "Point in time to compact to, in RFC 3339 format (e.g. 2026-01-01T12:00:00Z); defaults to the last recorded delta")
+	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "meshsync-snapshot-compacted.yaml", "Output file for the compacted snapshot, or - for stdout")
+	cmd.Flags().StringVarP(&opts.Format, "format", "f", "yaml", "Output format (yaml, yaml-stream, json, ndjson or list)")
+	cmd.Flags().StringVar(&opts.Compress, "compress", "", "Compress the snapshot (gzip, zstd or none); by default picked from the output extension, e.g. .yaml.gz")
+	cmd.Flags().BoolVar(&opts.Overwrite, "overwrite", false, "Replace the output file if it already exists")
+	cmd.Flags().StringArrayVar(&opts.EncryptTo, "encrypt-to", nil, "Encrypt the snapshot to an age recipient (age1...) or a file of recipients (can be repeated)")
+	cmd.Flags().BoolVar(&opts.EncryptPassphrase, "encrypt-passphrase", false, "Encrypt the snapshot with a passphrase, read from "+passphraseEnv+" or prompted for")
+
+	return cmd
+}
+
+// CompactOptions contains options for compact command
+type CompactOptions struct {
+	InputFile  string
+	DeltaFile  string
+	At         string
+	OutputFile string
+	Format     string
+	Compress   string
+	Overwrite  bool
+	// EncryptTo and EncryptPassphrase encrypt the compacted snapshot
+	EncryptTo         []string
+	EncryptPassphrase bool
+}
+
+// runCompact folds deltas into a snapshot and saves the result
+func runCompact(opts *CompactOptions) error {
+	if _, err := meshsync.ParseFormat(opts.Format); err != nil {
+		return err
+	}
+	compression, err := snapshotCompression(opts.Compress, opts.OutputFile)
+	if err != nil {
+		return err
+	}
+	recipients, err := snapshotRecipients(opts.EncryptTo, opts.EncryptPassphrase, opts.OutputFile)
+	if err != nil {
+		return err
+	}
+
+	var at time.Time
+	if opts.At != "" {
+		at, err = time.Parse(time.RFC3339, opts.At)
+		if err != nil {
+			return fmt.Errorf("invalid --at %q, want RFC 3339 such as 2026-01-01T12:00:00Z: %w", opts.At, err)
+		}
+	}
+
+	inputFile, err := resolveSnapshotInput(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to compact snapshot: %w", err)
+	}
+	deltaFile := opts.DeltaFile
+	if deltaFile == "" {
+		if inputFile == meshsync.StdioPath {
+			return fmt.Errorf("give --deltas when reading the snapshot from stdin")
+		}
+		if meshsync.IsSinkURL(inputFile) {
+			return fmt.Errorf("give --deltas when reading the snapshot from %s", inputFile)
+		}
+		deltaFile = meshsync.DeltaPath(inputFile)
+	}
+	if deltaFile == meshsync.StdioPath && inputFile == meshsync.StdioPath {
+		return fmt.Errorf("the snapshot and the deltas cannot both be read from stdin")
+	}
+
+	base, err := meshsync.LoadSnapshot(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to compact snapshot: %w", err)
+	}
+	deltas, err := meshsync.LoadDeltas(deltaFile)
+	if err != nil {
+		return fmt.Errorf("failed to compact snapshot: %w", err)
+	}
+
+	compacted, err := meshsync.CompactSnapshot(base, deltas, at)
+	if err != nil {
+		return fmt.Errorf("failed to compact snapshot: %w", err)
+	}
+
+	err = meshsync.SaveSnapshot(compacted, opts.OutputFile, meshsync.SnapshotFileOptions{
+		Format:      opts.Format,
+		Compression: compression,
+		Overwrite:   opts.Overwrite,
+		Recipients:  recipients,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+
+	fmt.Fprintf(os.Stderr, "Snapshot compacted to %s with %d resources and saved to %s\n", compacted.Metadata.Timestamp, len(compacted.Resources), snapshotLocation(opts.OutputFile))
+	return nil
+}
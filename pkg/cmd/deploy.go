@@ -3,8 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
+
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
 	"github.com/spf13/cobra"
@@ -17,9 +20,14 @@ func NewDeployCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "deploy",
 		Short: "Deploy MeshSync temporarily to cluster",
-		Long:  `Deploy MeshSync component to cluster to capture kubernetes resources state.`,
+		Long: `Deploy MeshSync component to cluster to capture kubernetes resources state.
+
+With --schedule, MeshSync is left running and a CronJob is installed next to
+it that runs the plugin image in the cluster to capture a snapshot on the
+given cron schedule, write it to --sink and import it to Meshery when --url
+is given. Deploying again updates the schedule; cleanup removes it.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDeploy(opts)
+			return runDeploy(cmd.Context(), opts)
 		},
 	}
 
@@ -27,20 +35,63 @@ func NewDeployCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", "meshery", "Namespace to deploy MeshSync")
 	cmd.Flags().StringVarP(&opts.Version, "version", "v", "latest", "MeshSync version to deploy")
 	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "t", 120*time.Second, "Timeout for deployment")
+	cmd.Flags().StringVar(&opts.Schedule, "schedule", "", "Cron schedule for in-cluster captures, e.g. \"0 * * * *\"")
+	cmd.Flags().StringVar(&opts.Image, "image", "", "Image with the plugin as its entrypoint that scheduled captures run (required with --schedule)")
+	cmd.Flags().StringVar(&opts.Sink, "sink", meshsync.DefaultScheduleSink, "Path in the capture pod, or s3://, configmap:// or pvc:// URL, scheduled captures write to; {timestamp} and {cluster} are filled in")
+	cmd.Flags().StringVar(&opts.SinkClaim, "sink-pvc", "", "PersistentVolumeClaim mounted at "+meshsync.ScheduleSinkMountPath+" in the capture pod")
+	cmd.Flags().StringVar(&opts.SinkSecret, "sink-secret", "", "Secret in the namespace whose keys are set as environment variables in the capture pod, such as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	cmd.Flags().IntVar(&opts.Retention, "retention", 0, "Number of scheduled snapshots kept in the sink (0 keeps all)")
+	cmd.Flags().StringVar(&opts.ConcurrencyPolicy, "concurrency-policy", string(batchv1.ForbidConcurrent), "What to do when a capture is still running at the next scheduled time (Allow, Forbid or Replace)")
+	cmd.Flags().StringSliceVarP(&opts.Kinds, "kinds", "k", meshsync.DefaultKinds, "Resource types scheduled captures capture")
+	cmd.Flags().BoolVarP(&opts.AllNamespaces, "all-namespaces", "A", false, "Capture resources from all namespaces in scheduled captures")
+	cmd.Flags().StringVarP(&opts.MesheryURL, "url", "u", "", "Meshery server URL scheduled captures import to; the import is skipped when empty")
+	cmd.Flags().StringVar(&opts.TokenSecret, "token-secret", "", "Secret in the namespace whose token key holds the Meshery authentication token")
 
 	return cmd
 }
 
 // DeployOptions contains options for deploy command
 type DeployOptions struct {
-	Namespace string
-	Version   string
-	Timeout   time.Duration
+	Namespace         string
+	Version           string
+	Timeout           time.Duration
+	Schedule          string
+	Image             string
+	Sink              string
+	SinkClaim         string
+	SinkSecret        string
+	Retention         int
+	ConcurrencyPolicy string
+	Kinds             []string
+	AllNamespaces     bool
+	MesheryURL        string
+	TokenSecret       string
 }
 
-// runDeploy deploys MeshSync to the cluster
-func runDeploy(opts *DeployOptions) error {
-	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+// runDeploy deploys MeshSync to the cluster, and the capture schedule when
+// one is given
+func runDeploy(ctx context.Context, opts *DeployOptions) error {
+	schedule := meshsync.ScheduleOptions{
+		Namespace:          opts.Namespace,
+		Schedule:           opts.Schedule,
+		Image:              opts.Image,
+		Sink:               opts.Sink,
+		SinkClaim:          opts.SinkClaim,
+		SinkSecret:         opts.SinkSecret,
+		Kinds:              opts.Kinds,
+		AllNamespaces:      opts.AllNamespaces,
+		MesheryURL:         opts.MesheryURL,
+		MesheryTokenSecret: opts.TokenSecret,
+		Retention:          opts.Retention,
+		ConcurrencyPolicy:  batchv1.ConcurrencyPolicy(opts.ConcurrencyPolicy),
+	}
+	if opts.Schedule != "" {
+		if err := schedule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
 
 	// Create Kubernetes client
@@ -49,15 +100,32 @@ func runDeploy(opts *DeployOptions) error {
 		return fmt.Errorf("error creating kubernetes client: %w", err)
 	}
 
+	// A schedule can be added to, or changed on, a running MeshSync
+	deployed := false
+	if opts.Schedule != "" {
+		deployed, err = meshsync.Deployed(ctx, client, opts.Namespace)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Deploy MeshSync
-	err = meshsync.Deploy(ctx, client, meshsync.DeployOptions{
-		Namespace: opts.Namespace,
-		Version:   opts.Version,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to deploy MeshSync: %w", err)
+	if !deployed {
+		err = meshsync.Deploy(ctx, client, meshsync.DeployOptions{
+			Namespace: opts.Namespace,
+			Version:   opts.Version,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deploy MeshSync: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "MeshSync deployed successfully in namespace %s\n", opts.Namespace)
 	}
 
-	fmt.Printf("MeshSync deployed successfully in namespace %s\n", opts.Namespace)
+	if opts.Schedule != "" {
+		if err := meshsync.DeploySchedule(ctx, client, schedule); err != nil {
+			return fmt.Errorf("failed to deploy capture schedule: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Captures scheduled at %q in namespace %s, writing to %s\n", opts.Schedule, opts.Namespace, opts.Sink)
+	}
 	return nil
 } 
\ No newline at end of file
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"golang.org/x/term"
+)
+
+// passphraseEnv holds the passphrase for --encrypt-passphrase and for
+// reading passphrase encrypted snapshots, instead of prompting for it
+const passphraseEnv = "MESHSYNC_SNAPSHOT_PASSPHRASE"
+
+// identityFiles are the age identity files set by the --identity flag
+// shared by all commands
+var identityFiles []string
+
+// loadIdentities registers the --identity files, and the passphrase for
+// passphrase encrypted snapshots, for decrypting snapshots as they are read
+func loadIdentities() error {
+	for _, path := range identityFiles {
+		ids, err := meshsync.LoadIdentities(path)
+		if err != nil {
+			return err
+		}
+		meshsync.AddIdentities(ids...)
+	}
+	meshsync.AddIdentities(passphraseIdentity{})
+	return nil
+}
+
+// passphraseIdentity decrypts passphrase encrypted snapshots, asking for
+// the passphrase only when one is read
+type passphraseIdentity struct{}
+
+// Unwrap implements age.Identity
+func (passphraseIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	if len(stanzas) != 1 || stanzas[0].Type != "scrypt" {
+		return nil, age.ErrIncorrectIdentity
+	}
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Unwrap(stanzas)
+}
+
+// snapshotRecipients returns the recipients given with --encrypt-to and
+// --encrypt-passphrase, or none to write the snapshot in the clear
+func snapshotRecipients(encryptTo []string, passphrase bool, outputPath string) ([]age.Recipient, error) {
+	if len(encryptTo) > 0 && passphrase {
+		return nil, fmt.Errorf("--encrypt-to and --encrypt-passphrase cannot be combined")
+	}
+	if passphrase {
+		secret, err := readPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(secret)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+	if len(encryptTo) == 0 {
+		if strings.HasSuffix(outputPath, meshsync.EncryptionExtension) {
+			return nil, fmt.Errorf("give --encrypt-to or --encrypt-passphrase to write an encrypted snapshot to %s", outputPath)
+		}
+		return nil, nil
+	}
+	return meshsync.ParseRecipients(encryptTo)
+}
+
+// readPassphrase reads a passphrase from passphraseEnv, or prompts for it
+// on the terminal, twice when it is new
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("set %s to give the snapshot passphrase when stdin is not a terminal", passphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, "Snapshot passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("the snapshot passphrase cannot be empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if string(again) != string(passphrase) {
+			return "", fmt.Errorf("the passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// validateEncrypt checks encryption is not combined with options that
+// would read the snapshot back or write parts of it in the clear
+func validateEncrypt(save, watch, sign bool) error {
+	switch {
+	case save:
+		return fmt.Errorf("--save is not supported with encryption, since the store indexes snapshot metadata in the clear")
+	case watch:
+		return fmt.Errorf("--watch is not supported with encryption, since deltas are recorded in the clear")
+	case sign:
+		return fmt.Errorf("--sign-key is not supported with encryption; sign the snapshot, then encrypt it with convert, and the signature stays valid")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes returned by Execute
+const (
+	ExitCodeOK    = 0
+	ExitCodeError = 1
+	// ExitCodePartialSnapshot means a snapshot was written but some
+	// resources could not be captured
+	ExitCodePartialSnapshot = 3
+	// ExitCodePartialRestore means a snapshot was restored but some
+	// resources failed to apply
+	ExitCodePartialRestore = 4
+	// ExitCodeInterrupted means the command was stopped by SIGINT or SIGTERM
+	ExitCodeInterrupted = 130
+)
+
+// exitCodeError is an error that sets the process exit code
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// Execute runs the root command and returns the process exit code. SIGINT
+// and SIGTERM cancel the context of the running command, which then cleans
+// up; a second signal stops the process right away.
+func Execute() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := NewRootCommand().ExecuteContext(ctx)
+	return exitCode(ctx, err)
+}
+
+// exitCode returns the process exit code for an error returned by a
+// command run with ctx, which ends when the process is signalled
+func exitCode(ctx context.Context, err error) int {
+	if err != nil && ctx.Err() != nil {
+		return ExitCodeInterrupted
+	}
+	return ExitCode(err)
+}
+
+// ExitCode returns the process exit code for an error returned by a command
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+
+	var exitErr *exitCodeError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return ExitCodeError
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	interrupted, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want int
+	}{
+		{name: "success", ctx: context.Background(), err: nil, want: ExitCodeOK},
+		{name: "error", ctx: context.Background(), err: errors.New("failed"), want: ExitCodeError},
+		{name: "partial snapshot", ctx: context.Background(), err: &exitCodeError{code: ExitCodePartialSnapshot, err: errors.New("partial")}, want: ExitCodePartialSnapshot},
+		{name: "partial restore", ctx: context.Background(), err: &exitCodeError{code: ExitCodePartialRestore, err: errors.New("partial")}, want: ExitCodePartialRestore},
+		{name: "wrapped exit code", ctx: context.Background(), err: fmt.Errorf("restore: %w", &exitCodeError{code: ExitCodePartialRestore, err: errors.New("partial")}), want: ExitCodePartialRestore},
+		{name: "interrupted", ctx: interrupted, err: context.Canceled, want: ExitCodeInterrupted},
+		{name: "interrupted partial snapshot", ctx: interrupted, err: &exitCodeError{code: ExitCodePartialSnapshot, err: errors.New("partial")}, want: ExitCodeInterrupted},
+		{name: "interrupted success", ctx: interrupted, err: nil, want: ExitCodeOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
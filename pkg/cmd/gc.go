@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewGCCommand creates a new command for pruning old snapshots from the store
+func NewGCCommand() *cobra.Command {
+	opts := &GCOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove old snapshots from the snapshot store",
+		Long: `Remove old snapshots from the snapshot store. --keep-last keeps the newest
+snapshots of each cluster and --older-than removes snapshots past an age.
+With both, a snapshot is removed only when it is past the age and not among
+the newest of its cluster.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGC(opts)
+		},
+	}
+
+	// Add flags specific to gc command
+	cmd.Flags().IntVar(&opts.KeepLast, "keep-last", 0, "Keep the newest N snapshots of each cluster")
+	cmd.Flags().StringVar(&opts.OlderThan, "older-than", "", "Remove snapshots older than this, in days (e.g. 30d) or as a duration (e.g. 12h)")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print the snapshots that would be removed without removing them")
+
+	return cmd
+}
+
+// GCOptions contains options for gc command
+type GCOptions struct {
+	KeepLast  int
+	OlderThan string
+	DryRun    bool
+}
+
+// runGC prunes the store and prints what was removed
+func runGC(opts *GCOptions) error {
+	if opts.KeepLast < 0 {
+		return fmt.Errorf("--keep-last must not be negative")
+	}
+	var olderThan time.Duration
+	if opts.OlderThan != "" {
+		var err error
+		if olderThan, err = parseAge(opts.OlderThan); err != nil {
+			return err
+		}
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	removed, err := store.GC(meshsync.GCOptions{
+		KeepLast:  opts.KeepLast,
+		OlderThan: olderThan,
+		DryRun:    opts.DryRun,
+	}, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to remove old snapshots: %w", err)
+	}
+
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	for _, entry := range removed {
+		fmt.Fprintf(os.Stderr, "%s snapshot %s\n", verb, entry.ID)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d snapshots\n", verb, len(removed))
+	return nil
+}
+
+// parseAge parses an age given in days, such as 30d, or as a Go duration
+func parseAge(age string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(age); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid age %q, want days such as 30d or a duration such as 12h", age)
+}
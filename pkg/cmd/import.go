@@ -3,9 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshery"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
 	"github.com/spf13/cobra"
 )
 
@@ -16,17 +18,25 @@ func NewImportCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "import",
 		Short: "Import snapshot to Meshery",
-		Long:  `Import captured snapshot to Meshery via API or file output.`,
+		Long: `Import captured snapshot to Meshery via API or file output.
+
+With --require-signature, the snapshot is imported only if its detached
+signature is valid and made by a trusted key, given with --verify-key or as
+a keyring file in MESHSYNC_SNAPSHOT_TRUSTED_KEYS, as checked by verify.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runImport(opts)
+			return runImport(cmd.Context(), opts)
 		},
 	}
 
 	// Add flags specific to import command
 	cmd.Flags().StringVarP(&opts.MesheryURL, "url", "u", "http://localhost:9081", "Meshery server URL")
 	cmd.Flags().StringVarP(&opts.Token, "token", "t", "", "Meshery authentication token")
-	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Input snapshot file path")
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Input snapshot file path, store reference such as prod@latest, or - for stdin")
 	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "", 30*time.Second, "Timeout for import operation")
+	cmd.Flags().StringVar(&opts.Compress, "compress", "none", "Content-Encoding for the upload (gzip, zstd or none), for Meshery servers that decode it; retried uncompressed on 415 Unsupported Media Type")
+	cmd.Flags().BoolVar(&opts.RequireSignature, "require-signature", false, "Refuse snapshots without a valid signature by a trusted key")
+	cmd.Flags().StringVar(&opts.SignatureFile, "signature", "", "Signature file for --require-signature (default: the input path with a .sig extension)")
+	cmd.Flags().StringVar(&opts.VerifyKey, "verify-key", "", "PEM public key, or keyring of several, the snapshot must be signed with (default: the file in $MESHSYNC_SNAPSHOT_TRUSTED_KEYS); implies --require-signature")
 
 	return cmd
 }
@@ -36,26 +46,55 @@ type ImportOptions struct {
 	MesheryURL string
 	Token      string
 	InputFile  string
+	Compress   string
 	Timeout    time.Duration
+	// RequireSignature refuses snapshots whose signature is missing or
+	// invalid, before anything is sent
+	RequireSignature bool
+	SignatureFile    string
+	VerifyKey        string
 }
 
 // runImport imports snapshot to Meshery
-func runImport(opts *ImportOptions) error {
-	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+func runImport(ctx context.Context, opts *ImportOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
 
+	compression, err := meshsync.ParseCompression(opts.Compress)
+	if err != nil {
+		return err
+	}
+
 	// Create Meshery client
 	client, err := meshery.NewClient(opts.MesheryURL, opts.Token)
 	if err != nil {
 		return fmt.Errorf("error creating Meshery client: %w", err)
 	}
+	client.ContentEncoding = compression
+
+	// Load the snapshot, upgrading older schema versions
+	inputFile, err := resolveSnapshotInput(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	snapshot, err := meshsync.LoadSnapshot(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if opts.RequireSignature || opts.VerifyKey != "" {
+		sig, err := verifySignature(snapshot, inputFile, opts.SignatureFile, opts.VerifyKey, "--verify-key")
+		if err != nil {
+			return fmt.Errorf("refusing to import snapshot: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Signature valid: signed by %s with key %s\n", sig.Signer, sig.KeyID)
+	}
 
 	// Import snapshot
-	err = client.ImportSnapshot(ctx, opts.InputFile)
+	err = client.ImportSnapshot(ctx, snapshot)
 	if err != nil {
 		return fmt.Errorf("failed to import snapshot: %w", err)
 	}
 
-	fmt.Printf("Snapshot imported successfully to Meshery server %s\n", opts.MesheryURL)
+	fmt.Fprintf(os.Stderr, "Snapshot imported successfully to Meshery server %s\n", opts.MesheryURL)
 	return nil
 } 
\ No newline at end of file
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewListCommand creates a new command for listing the snapshots in the store
+func NewListCommand() *cobra.Command {
+	opts := &ListOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots in the snapshot store",
+		Long: `List the snapshots filed in the snapshot store by capture --save and
+run --save, newest first. Snapshots are referred to by ID, or by references
+such as prod@latest, prod@-1 or prod@20260101T120000Z, where prod is the
+cluster ID, kubeconfig context or snapshot name.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(opts)
+		},
+	}
+
+	// Add flags specific to list command
+	cmd.Flags().StringVar(&opts.Cluster, "cluster", "", "Only list snapshots of this cluster ID, kubeconfig context or snapshot name")
+	cmd.Flags().StringToStringVarP(&opts.Labels, "label", "l", nil, "Only list snapshots with this label as key=value (can be repeated)")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "table", "Output format (table or json)")
+
+	return cmd
+}
+
+// ListOptions contains options for list command
+type ListOptions struct {
+	Cluster string
+	Labels  map[string]string
+	Output  string
+}
+
+// runList prints the store entries matching the filters
+func runList(opts *ListOptions) error {
+	if opts.Output != "table" && opts.Output != "json" {
+		return fmt.Errorf("unknown output format %q, want table or json", opts.Output)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	entries, err := store.List(meshsync.StoreFilter{Cluster: opts.Cluster, Labels: opts.Labels})
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	if opts.Output == "json" {
+		if entries == nil {
+			entries = []meshsync.StoreEntry{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "No snapshots in the store %s\n", store.Dir)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCONTEXT\tTIMESTAMP\tRESOURCES\tSIZE")
+	for _, entry := range entries {
+		resources := fmt.Sprint(entry.Resources)
+		if entry.Incomplete {
+			resources += " (incomplete)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.Name, entry.Context, entry.Timestamp.Local().Format(time.DateTime), resources, formatSize(entry.Size))
+	}
+	return w.Flush()
+}
+
+// formatSize formats a file size in bytes with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, "KMGT"[exp])
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+// NewPullCommand creates a new command for pulling a snapshot from an OCI registry
+func NewPullCommand() *cobra.Command {
+	opts := &PullOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "pull oci://REGISTRY/REPOSITORY[:TAG|@DIGEST]",
+		Short: "Pull a snapshot from an OCI registry",
+		Long: `Pull a snapshot pushed with push from an OCI registry. The snapshot is
+written exactly as it was pushed, after checking it against its digest.
+Credentials are read from the Docker config written by docker login.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Reference = args[0]
+			return runPull(cmd.Context(), opts)
+		},
+	}
+
+	// Add flags specific to pull command
+	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "", "Output file or sink URL for snapshot, or - for stdout (default: the name of the pushed file)")
+	cmd.Flags().BoolVar(&opts.Overwrite, "overwrite", false, "Replace the output file if it already exists")
+	cmd.Flags().BoolVar(&opts.PlainHTTP, "plain-http", false, "Talk to the registry over http rather than https (always used for localhost)")
+	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "", 5*time.Minute, "Timeout for pull operation")
+
+	return cmd
+}
+
+// PullOptions contains options for pull command
+type PullOptions struct {
+	Reference  string
+	OutputFile string
+	Overwrite  bool
+	PlainHTTP  bool
+	Timeout    time.Duration
+}
+
+// runPull pulls a snapshot from a registry
+func runPull(ctx context.Context, opts *PullOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	ref, err := oci.ParseReference(opts.Reference)
+	if err != nil {
+		return err
+	}
+
+	client := oci.NewClient()
+	client.PlainHTTP = opts.PlainHTTP
+	artifact, err := meshsync.FetchSnapshotArtifact(ctx, client, ref)
+	if err != nil {
+		return err
+	}
+
+	outputFile := opts.OutputFile
+	if outputFile == "" {
+		outputFile = artifact.FileName()
+	}
+	if outputFile == "" {
+		outputFile = "meshsync-snapshot.yaml"
+	}
+	if err := meshsync.PullSnapshot(ctx, client, artifact, outputFile, opts.Overwrite); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Snapshot %s saved to %s\n", artifact.Ref, snapshotLocation(outputFile))
+	return nil
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+// NewPushCommand creates a new command for pushing a snapshot to an OCI registry
+func NewPushCommand() *cobra.Command {
+	opts := &PushOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "push oci://REGISTRY/REPOSITORY[:TAG]",
+		Short: "Push a snapshot to an OCI registry",
+		Long: `Push a snapshot to an OCI registry as an artifact. The snapshot file is
+stored as is, and its name, cluster, capture time and resource counts are
+recorded as manifest annotations. Credentials are read from the Docker
+config written by docker login.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Reference = args[0]
+			return runPush(cmd.Context(), opts)
+		},
+	}
+
+	// Add flags specific to push command
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Input snapshot file path, store reference such as prod@latest, or - for stdin")
+	cmd.Flags().BoolVar(&opts.PlainHTTP, "plain-http", false, "Talk to the registry over http rather than https (always used for localhost)")
+	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "", 5*time.Minute, "Timeout for push operation")
+
+	return cmd
+}
+
+// PushOptions contains options for push command
+type PushOptions struct {
+	Reference string
+	InputFile string
+	PlainHTTP bool
+	Timeout   time.Duration
+}
+
+// runPush pushes a snapshot to a registry
+func runPush(ctx context.Context, opts *PushOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	ref, err := oci.ParseReference(opts.Reference)
+	if err != nil {
+		return err
+	}
+	inputFile, err := resolveSnapshotInput(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to push snapshot: %w", err)
+	}
+
+	client := oci.NewClient()
+	client.PlainHTTP = opts.PlainHTTP
+	pushed, err := meshsync.PushSnapshot(ctx, client, ref, inputFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Snapshot pushed to %s\n", pushed)
+	return nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewRestoreCommand creates a new command for applying a snapshot to a cluster
+func NewRestoreCommand() *cobra.Command {
+	opts := &RestoreOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Apply a snapshot to the cluster",
+		Long: `Apply the resources of a snapshot to the current cluster with server-side
+apply, in dependency order: namespaces, custom resource definitions, RBAC,
+config maps and secrets, then other resources, then workloads. Resources
+managed by a controller, such as the pods of a deployment, are skipped.
+
+Namespaces can be mapped and names prefixed or suffixed to restore next to
+the original resources; references between the restored resources, such as
+RoleBinding subjects, Service selectors and Ingress backends, are rewritten
+to match. Cluster-scoped resources other than namespaces and CRDs are only
+restored with a name prefix or suffix when namespaces are mapped, so they
+do not replace the originals.
+
+Fields of existing resources owned by another field manager, such as kubectl
+or a controller, are not overwritten: those resources fail with a conflict
+unless --force-conflicts is given.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRestore(cmd.Context(), opts)
+		},
+	}
+
+	// Add flags specific to restore command
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Input snapshot file path, store reference such as prod@latest, or - for stdin")
+	cmd.Flags().StringVar(&opts.FieldManager, "field-manager", meshsync.DefaultFieldManager, "Field manager that owns the applied fields")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Send every apply as a server-side dry run without persisting anything")
+	cmd.Flags().BoolVar(&opts.ForceConflicts, "force-conflicts", false, "Take over fields owned by other field managers instead of failing with a conflict")
+	cmd.Flags().BoolVar(&opts.PruneStatus, "prune-status", false, "Leave the captured status out instead of applying it to the status subresource")
+	cmd.Flags().StringToStringVar(&opts.MapNamespaces, "map-namespace", nil, "Restore the resources of a namespace to another as from=to, e.g. prod=prod-replay (can be repeated)")
+	cmd.Flags().StringVar(&opts.NamePrefix, "name-prefix", "", "Prefix added to the name of every restored resource other than namespaces and CRDs")
+	cmd.Flags().StringVar(&opts.NameSuffix, "name-suffix", "", "Suffix added to the name of every restored resource other than namespaces and CRDs")
+	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "t", 5*time.Minute, "Timeout for restore operation")
+
+	return cmd
+}
+
+// RestoreOptions contains options for restore command
+type RestoreOptions struct {
+	InputFile      string
+	FieldManager   string
+	DryRun         bool
+	ForceConflicts bool
+	PruneStatus    bool
+	MapNamespaces  map[string]string
+	NamePrefix     string
+	NameSuffix     string
+	Timeout        time.Duration
+}
+
+// runRestore applies a snapshot to the cluster and reports what failed
+func runRestore(ctx context.Context, opts *RestoreOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	inputFile, err := resolveSnapshotInput(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	snapshot, err := meshsync.LoadSnapshot(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	// Create Kubernetes client
+	client, err := kube.NewClient()
+	if err != nil {
+		return fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+
+	report, err := meshsync.RestoreSnapshot(ctx, client, snapshot, meshsync.RestoreOptions{
+		FieldManager:   opts.FieldManager,
+		DryRun:         opts.DryRun,
+		PruneStatus:    opts.PruneStatus,
+		ForceConflicts: opts.ForceConflicts,
+		Rename: meshsync.RenameOptions{
+			Namespaces: opts.MapNamespaces,
+			NamePrefix: opts.NamePrefix,
+			NameSuffix: opts.NameSuffix,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	dryRun := ""
+	if opts.DryRun {
+		dryRun = " (dry run)"
+	}
+	skipped, failed := report.Skipped(), report.Failed()
+	var clusterScoped []meshsync.RestoreResult
+	for _, result := range skipped {
+		if result.Skipped == meshsync.SkippedClusterScoped {
+			clusterScoped = append(clusterScoped, result)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Applied %d resources%s, skipped %d managed by a controller\n", report.Applied(), dryRun, len(skipped)-len(clusterScoped))
+	if len(clusterScoped) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d cluster-scoped resources that would replace the originals; give --name-prefix or --name-suffix to restore them:\n", len(clusterScoped))
+		for _, result := range clusterScoped {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", result.Kind, result.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Failed to apply %d resources:\n", len(failed))
+		for _, result := range failed {
+			fmt.Fprintf(os.Stderr, "  %s %s: %v\n", result.Kind, restoreName(result), result.Err)
+		}
+		if conflicts := report.Conflicts(); len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "%d resources conflict with fields owned by other field managers; rerun with --force-conflicts to take them over\n", len(conflicts))
+		}
+		return &exitCodeError{
+			code: ExitCodePartialRestore,
+			err:  fmt.Errorf("%d of %d resources failed to apply", len(failed), len(report.Results)-len(skipped)),
+		}
+	}
+	return nil
+}
+
+// restoreName returns the namespace/name of a restored resource, or the
+// name of a cluster-scoped one
+func restoreName(result meshsync.RestoreResult) string {
+	if result.Namespace == "" {
+		return result.Name
+	}
+	return result.Namespace + "/" + result.Name
+}
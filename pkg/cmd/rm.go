@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewRmCommand creates a new command for removing snapshots from the store
+func NewRmCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rm REF...",
+		Short: "Remove snapshots from the snapshot store",
+		Long: `Remove snapshots from the snapshot store, along with any deltas recorded
+with them. Each REF is a snapshot ID or a reference such as prod@-1.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRm(args)
+		},
+	}
+
+	return cmd
+}
+
+// runRm resolves every reference before removing anything, so a typo
+// removes nothing
+func runRm(refs []string) error {
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	var entries []meshsync.StoreEntry
+	for _, ref := range refs {
+		entry, err := store.Resolve(ref)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err := store.Remove(entries...); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(os.Stderr, "Removed snapshot %s\n", entry.ID)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
 	"github.com/spf13/cobra"
 )
 
@@ -12,16 +13,34 @@ func NewRootCommand() *cobra.Command {
 		Long: `meshsync-snapshot is a kubectl plugin that helps manage 
 MeshSync snapshots for Meshery. It allows deploying MeshSync,
 capturing cluster state, importing snapshots and cleaning up resources.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadIdentities()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
 		},
 	}
 
+	cmd.PersistentFlags().StringVar(&storeDir, "store", "", "Snapshot store directory (default ~/"+meshsync.DefaultStoreDir+")")
+	cmd.PersistentFlags().StringArrayVar(&identityFiles, "identity", nil, "age identity file to decrypt encrypted snapshots with (can be repeated)")
+
 	// Add subcommands
 	cmd.AddCommand(NewDeployCommand())
 	cmd.AddCommand(NewCaptureCommand())
 	cmd.AddCommand(NewImportCommand())
+	cmd.AddCommand(NewVerifyCommand())
+	cmd.AddCommand(NewConvertCommand())
+	cmd.AddCommand(NewExportCommand())
+	cmd.AddCommand(NewCompactCommand())
+	cmd.AddCommand(NewRestoreCommand())
 	cmd.AddCommand(NewCleanupCommand())
+	cmd.AddCommand(NewRunCommand())
+	cmd.AddCommand(NewListCommand())
+	cmd.AddCommand(NewShowCommand())
+	cmd.AddCommand(NewRmCommand())
+	cmd.AddCommand(NewGCCommand())
+	cmd.AddCommand(NewPushCommand())
+	cmd.AddCommand(NewPullCommand())
 
 	return cmd
 } 
\ No newline at end of file
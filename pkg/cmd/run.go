@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshery"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewRunCommand creates a new command that deploys MeshSync, captures a
+// snapshot, optionally imports it and cleans up in one go
+func NewRunCommand() *cobra.Command {
+	opts := &RunOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Deploy MeshSync, capture a snapshot, import it and clean up",
+		Long: `Deploy MeshSync, capture a snapshot, save it, import it to Meshery when
+--url is given, and remove MeshSync again, all under one deadline.
+
+Cleanup always runs, also when a phase fails or the command is interrupted,
+unless --keep is given, and removes everything the deploy created, including
+the namespace if it did not exist before. If MeshSync is already deployed in
+the namespace it is used as is and left in place.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRun(cmd.Context(), opts)
+		},
+	}
+
+	// Add flags specific to run command
+	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", "meshery", "Namespace to deploy MeshSync")
+	cmd.Flags().StringVarP(&opts.Version, "version", "v", "latest", "MeshSync version to deploy")
+	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "meshsync-snapshot.yaml", "Output file, or s3://, configmap:// or pvc:// URL, for snapshot, or - for stdout; {timestamp} and {cluster} are filled in")
+	cmd.Flags().StringVarP(&opts.Format, "format", "f", "yaml", "Output format (yaml, yaml-stream, json, ndjson or list)")
+	cmd.Flags().StringVar(&opts.Compress, "compress", "", "Compress the snapshot (gzip, zstd or none); by default picked from the output extension, e.g. .yaml.gz")
+	cmd.Flags().BoolVar(&opts.Overwrite, "overwrite", false, "Replace the output file if it already exists")
+	cmd.Flags().StringArrayVar(&opts.EncryptTo, "encrypt-to", nil, "Encrypt the snapshot to an age recipient (age1...) or a file of recipients (can be repeated)")
+	cmd.Flags().BoolVar(&opts.EncryptPassphrase, "encrypt-passphrase", false, "Encrypt the snapshot with a passphrase, read from "+passphraseEnv+" or prompted for")
+	cmd.Flags().IntVar(&opts.KeepLast, "keep-last", 0, "Remove all but the newest N snapshots matching an output path with {timestamp} (0 keeps all)")
+	cmd.Flags().BoolVar(&opts.Save, "save", false, "File the snapshot in the snapshot store instead of writing --output")
+	cmd.Flags().BoolVarP(&opts.AllNamespaces, "all-namespaces", "A", false, "Capture resources from all namespaces")
+	cmd.Flags().StringSliceVarP(&opts.Kinds, "kinds", "k", meshsync.DefaultKinds, "Resource types to capture (e.g. deployments,services,ingresses.networking.k8s.io)")
+	cmd.Flags().StringVar(&opts.Name, "name", meshsync.DefaultSnapshotName, "Snapshot name recorded in the snapshot metadata")
+	cmd.Flags().StringToStringVar(&opts.Labels, "label", nil, "Label recorded in the snapshot metadata as key=value (can be repeated)")
+	cmd.Flags().BoolVar(&opts.ContinueOnError, "continue-on-error", false, "Record failed lists in the snapshot status and write a partial snapshot instead of failing")
+	cmd.Flags().StringVarP(&opts.MesheryURL, "url", "u", "", "Meshery server URL to import the snapshot to; the import is skipped when empty")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "Meshery authentication token")
+	cmd.Flags().BoolVar(&opts.Keep, "keep", false, "Leave MeshSync deployed instead of cleaning up")
+	cmd.Flags().DurationVarP(&opts.Timeout, "timeout", "t", 5*time.Minute, "Timeout for everything up to and including the import")
+	cmd.Flags().DurationVar(&opts.CleanupTimeout, "cleanup-timeout", 60*time.Second, "Timeout for cleanup, which runs even when --timeout has passed")
+
+	return cmd
+}
+
+// RunOptions contains options for run command
+type RunOptions struct {
+	Namespace       string
+	Version         string
+	OutputFile      string
+	Format          string
+	Compress        string
+	Overwrite       bool
+	KeepLast        int
+	Save            bool
+	AllNamespaces   bool
+	Kinds           []string
+	Name            string
+	Labels          map[string]string
+	ContinueOnError bool
+	MesheryURL      string
+	Token           string
+	Keep            bool
+	Timeout         time.Duration
+	CleanupTimeout  time.Duration
+	// EncryptTo and EncryptPassphrase encrypt the saved snapshot
+	EncryptTo         []string
+	EncryptPassphrase bool
+}
+
+// runRun runs every phase from deploy to cleanup
+func runRun(ctx context.Context, opts *RunOptions) (err error) {
+	if _, err := meshsync.ParseFormat(opts.Format); err != nil {
+		return err
+	}
+	if opts.KeepLast > 0 && meshsync.IsSinkURL(opts.OutputFile) && !meshsync.SinkPrunes(opts.OutputFile) {
+		return fmt.Errorf("--keep-last is not supported for %s, expire old snapshots in the storage itself", opts.OutputFile)
+	}
+	var store *meshsync.Store
+	outputPath := opts.OutputFile
+	if opts.Save {
+		if opts.KeepLast > 0 {
+			return fmt.Errorf("--keep-last is not supported with --save, use gc to prune the store")
+		}
+		if err := validateSave(opts.Format, opts.Compress); err != nil {
+			return err
+		}
+		if store, err = openStore(); err != nil {
+			return err
+		}
+		outputPath = store.PathTemplate()
+	}
+	compression, err := snapshotCompression(opts.Compress, outputPath)
+	if err != nil {
+		return err
+	}
+	if len(opts.EncryptTo) > 0 || opts.EncryptPassphrase {
+		if err := validateEncrypt(opts.Save, false, false); err != nil {
+			return err
+		}
+	}
+	recipients, err := snapshotRecipients(opts.EncryptTo, opts.EncryptPassphrase, outputPath)
+	if err != nil {
+		return err
+	}
+
+	// Create Kubernetes client
+	client, err := kube.NewClient()
+	if err != nil {
+		return fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+
+	// An interrupt cancels the running phase; cleanup still runs
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	outputFile, cluster := expandOutputPath(ctx, client, outputPath)
+	if store != nil {
+		if err := prepareStoreOutput(outputFile); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		fmt.Fprintf(os.Stderr, "Total time %s\n", time.Since(start).Round(time.Millisecond))
+	}()
+
+	deployed, err := meshsync.Deployed(ctx, client, opts.Namespace)
+	if err != nil {
+		return err
+	}
+	if deployed {
+		fmt.Fprintf(os.Stderr, "MeshSync is already deployed in namespace %s, using it and leaving it in place\n", opts.Namespace)
+	} else {
+		// Cleanup removes the namespace only if the deploy creates it
+		var namespaceExists bool
+		namespaceExists, err = meshsync.NamespaceExists(ctx, client, opts.Namespace)
+		if err != nil {
+			return err
+		}
+		if !opts.Keep {
+			defer func() {
+				if ctx.Err() == context.Canceled {
+					fmt.Fprintln(os.Stderr, "Interrupted, cleaning up")
+				}
+				if cleanupErr := runCleanupPhase(ctx, client, opts, !namespaceExists); cleanupErr != nil {
+					err = errors.Join(err, cleanupErr)
+				}
+			}()
+		}
+
+		err = runPhase("deploy", func() error {
+			return meshsync.Deploy(ctx, client, meshsync.DeployOptions{
+				Namespace: opts.Namespace,
+				Version:   opts.Version,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deploy MeshSync: %w", err)
+		}
+	}
+
+	var meshsyncVersion string
+	err = runPhase("validate", func() error {
+		err := meshsync.Validate(ctx, client, opts.Namespace)
+		if err != nil {
+			return err
+		}
+		meshsyncVersion, err = meshsync.DeployedVersion(ctx, client, opts.Namespace)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("MeshSync validation failed: %w", err)
+	}
+
+	var snapshot *meshsync.Snapshot
+	err = runPhase("capture", func() error {
+		var err error
+		snapshot, err = meshsync.CaptureSnapshot(ctx, client, meshsync.CaptureOptions{
+			Namespace:       opts.Namespace,
+			AllNamespaces:   opts.AllNamespaces,
+			Name:            opts.Name,
+			Labels:          opts.Labels,
+			MeshSyncVersion: meshsyncVersion,
+			Kinds:           opts.Kinds,
+			ChunkSize:       meshsync.DefaultChunkSize,
+			Concurrency:     meshsync.DefaultConcurrency,
+			ContinueOnError: opts.ContinueOnError,
+		})
+		return err
+	})
+	if err != nil && snapshot == nil {
+		return fmt.Errorf("failed to capture snapshot: %w", err)
+	}
+	captureErr := err
+
+	// An interrupted capture still saves what was captured, marked incomplete
+	err = runPhase("save", func() error {
+		return meshsync.SaveSnapshot(snapshot, outputFile, meshsync.SnapshotFileOptions{
+			Format:      opts.Format,
+			Compression: compression,
+			Overwrite:   opts.Overwrite,
+			Recipients:  recipients,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", overwriteHint(err))
+	}
+	if store != nil {
+		if err := fileInStore(store, outputFile); err != nil {
+			return err
+		}
+	}
+	if captureErr != nil {
+		return incompleteSnapshotError(snapshot.Status.IncompleteReason, outputFile, captureErr)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot saved to %s\n", snapshotLocation(outputFile))
+
+	if opts.KeepLast > 0 {
+		removed, err := meshsync.PruneSnapshots(opts.OutputFile, cluster, opts.KeepLast)
+		if err != nil {
+			return fmt.Errorf("failed to remove old snapshots: %w", err)
+		}
+		for _, path := range removed {
+			fmt.Fprintf(os.Stderr, "Removed old snapshot %s\n", path)
+		}
+	}
+
+	if opts.MesheryURL != "" {
+		err = runPhase("import", func() error {
+			mesheryClient, err := meshery.NewClient(opts.MesheryURL, opts.Token)
+			if err != nil {
+				return fmt.Errorf("error creating Meshery client: %w", err)
+			}
+			return mesheryClient.ImportSnapshot(ctx, snapshot)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to import snapshot: %w", err)
+		}
+	}
+
+	if snapshot.Status != nil && len(snapshot.Status.Errors) > 0 {
+		return &exitCodeError{
+			code: ExitCodePartialSnapshot,
+			err:  fmt.Errorf("partial snapshot saved to %s: %d lists failed, see status.errors", snapshotLocation(outputFile), len(snapshot.Status.Errors)),
+		}
+	}
+	return nil
+}
+
+// runCleanupPhase removes what the deploy phase created with its own
+// timeout, so it runs even after the overall deadline has passed or the run
+// was interrupted. The namespace is removed too when the deploy created it,
+// unless other deployments have been created in it since.
+func runCleanupPhase(ctx context.Context, client *kube.Client, opts *RunOptions, createdNamespace bool) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.CleanupTimeout)
+	defer cancel()
+
+	err := runPhase("cleanup", func() error {
+		return meshsync.Cleanup(ctx, client, meshsync.CleanupOptions{
+			Namespace:      opts.Namespace,
+			Force:          createdNamespace,
+			IgnoreNotFound: true,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cleanup MeshSync resources, run cleanup -n %s to retry: %w", opts.Namespace, err)
+	}
+	return nil
+}
+
+// runPhase runs one phase of the run command and prints how long it took
+func runPhase(name string, phase func() error) error {
+	start := time.Now()
+	err := phase()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%-8s failed after %s\n", name, elapsed)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%-8s done in %s\n", name, elapsed)
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+func TestRunIncompleteSnapshotExitCode(t *testing.T) {
+	// run and capture report a capture that hit the deadline after saving
+	// what it had the same way
+	err := incompleteSnapshotError("timed out", "snapshot.yaml", context.DeadlineExceeded)
+	if got := exitCode(context.Background(), err); got != ExitCodePartialSnapshot {
+		t.Errorf("exitCode() = %d, want %d", got, ExitCodePartialSnapshot)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("incompleteSnapshotError() = %v, want it to wrap the capture error", err)
+	}
+	if !strings.Contains(err.Error(), "incomplete snapshot saved to snapshot.yaml") {
+		t.Errorf("incompleteSnapshotError() = %v, want where the snapshot was saved", err)
+	}
+}
+
+func TestRunCleanupPhase(t *testing.T) {
+	deployed := func() []runtime.Object {
+		meta := metav1.ObjectMeta{Name: "meshsync", Namespace: "meshery"}
+		return []runtime.Object{
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}},
+			&appsv1.Deployment{ObjectMeta: meta},
+			&corev1.Service{ObjectMeta: meta},
+			&corev1.ServiceAccount{ObjectMeta: meta},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		objects          []runtime.Object
+		createdNamespace bool
+		wantNamespace    bool
+	}{
+		{name: "namespace created by the deploy", objects: deployed(), createdNamespace: true, wantNamespace: false},
+		{name: "existing namespace", objects: deployed(), createdNamespace: false, wantNamespace: true},
+		{
+			name:             "deploy failed before creating anything but the namespace",
+			objects:          []runtime.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}}},
+			createdNamespace: true,
+			wantNamespace:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			client := kube.NewClientFromInterfaces(clientset, nil, nil)
+			ctx := context.Background()
+
+			opts := &RunOptions{Namespace: "meshery", CleanupTimeout: time.Minute}
+			if err := runCleanupPhase(ctx, client, opts, tt.createdNamespace); err != nil {
+				t.Fatalf("runCleanupPhase() error = %v", err)
+			}
+			if _, err := clientset.AppsV1().Deployments("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync deployment still exists after cleanup, error = %v", err)
+			}
+			_, err := clientset.CoreV1().Namespaces().Get(ctx, "meshery", metav1.GetOptions{})
+			if exists := err == nil; exists != tt.wantNamespace {
+				t.Errorf("Namespace exists after cleanup = %v, want %v (error %v)", exists, tt.wantNamespace, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// NewShowCommand creates a new command for showing a snapshot in the store
+func NewShowCommand() *cobra.Command {
+	opts := &ShowOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "show REF",
+		Short: "Show the indexed metadata of a snapshot in the store",
+		Long: `Show the indexed metadata of a snapshot in the snapshot store, including
+its file path and resource counts. REF is a snapshot ID or a reference such
+as prod@latest.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Ref = args[0]
+			return runShow(opts)
+		},
+	}
+
+	// Add flags specific to show command
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "yaml", "Output format (yaml or json)")
+
+	return cmd
+}
+
+// ShowOptions contains options for show command
+type ShowOptions struct {
+	Ref    string
+	Output string
+}
+
+// runShow prints the store entry a reference resolves to
+func runShow(opts *ShowOptions) error {
+	if opts.Output != "yaml" && opts.Output != "json" {
+		return fmt.Errorf("unknown output format %q, want yaml or json", opts.Output)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	entry, err := store.Resolve(opts.Ref)
+	if err != nil {
+		return err
+	}
+
+	// Show the full path rather than the one relative to the store
+	shown := *entry
+	shown.Path = store.FilePath(entry)
+
+	if opts.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(shown)
+	}
+	data, err := yaml.Marshal(shown)
+	if err != nil {
+		return fmt.Errorf("failed to show snapshot: %w", err)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
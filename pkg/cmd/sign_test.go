@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+)
+
+// writePublicKey writes the PEM public key of key to dir
+func writePublicKey(t *testing.T, dir, name string, key ed25519.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyRequiresTrustedKey(t *testing.T) {
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	trusted := writePublicKey(t, dir, "trusted.pem", key)
+	untrusted := writePublicKey(t, dir, "untrusted.pem", otherKey)
+
+	input := filepath.Join(dir, "snapshot.yaml")
+	snapshot := &meshsync.Snapshot{
+		APIVersion: meshsync.SnapshotAPIVersion,
+		Kind:       meshsync.SnapshotKind,
+		Metadata:   meshsync.SnapshotMetadata{Name: "test-snapshot"},
+		Resources:  []meshsync.Resource{},
+	}
+	if err := meshsync.SaveSnapshot(snapshot, input, meshsync.SnapshotFileOptions{Format: meshsync.FormatYAML}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	if err := (&snapshotSigner{key: key, signer: "ci"}).sign(input, false); err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		keyFile string
+		env     string
+		wantErr error
+	}{
+		{name: "no trusted key", wantErr: meshsync.ErrNoTrustedKey},
+		{name: "trusted key", keyFile: trusted},
+		{name: "trusted keyring from the environment", env: trusted},
+		{name: "other key", keyFile: untrusted, wantErr: meshsync.ErrSignatureInvalid},
+		{name: "flag over the environment", keyFile: untrusted, env: trusted, wantErr: meshsync.ErrSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(trustedKeysEnv, tt.env)
+			err := runVerify(&VerifyOptions{InputFile: input, KeyFile: tt.keyFile})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("runVerify() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("runVerify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// import refuses the snapshot before contacting Meshery
+	t.Setenv(trustedKeysEnv, "")
+	err := runImport(context.Background(), &ImportOptions{MesheryURL: "http://localhost:9081", InputFile: input, Compress: "none", Timeout: time.Second, RequireSignature: true})
+	if !errors.Is(err, meshsync.ErrNoTrustedKey) {
+		t.Errorf("runImport() with --require-signature and no key error = %v, want ErrNoTrustedKey", err)
+	}
+}
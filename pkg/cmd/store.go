@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+)
+
+// storeDir is the snapshot store directory set by the --store flag shared
+// by every command; empty means the default store in the home directory
+var storeDir string
+
+// openStore opens the snapshot store selected by --store, warning about
+// snapshot files left out when the index is rebuilt
+func openStore() (*meshsync.Store, error) {
+	store, err := meshsync.OpenStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	store.OnSkip = func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, remove it or move it out of the store: %v\n", path, err)
+	}
+	return store, nil
+}
+
+// resolveSnapshotInput returns the file an input flag refers to. Store
+// references such as prod@latest resolve to the snapshot in the store,
+// unless a file of that name exists.
+func resolveSnapshotInput(input string) (string, error) {
+	if input == meshsync.StdioPath || !meshsync.IsStoreRef(input) {
+		return input, nil
+	}
+	if _, err := os.Stat(input); err == nil {
+		return input, nil
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return "", err
+	}
+	entry, err := store.Resolve(input)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "Using snapshot %s from the store\n", entry.ID)
+	return store.FilePath(entry), nil
+}
+
+// prepareStoreOutput creates the cluster directory a capture filed in the
+// store is written to
+func prepareStoreOutput(outputFile string) error {
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0700); err != nil {
+		return fmt.Errorf("failed to create snapshot store: %w", err)
+	}
+	return nil
+}
+
+// fileInStore indexes a snapshot written to the store
+func fileInStore(store *meshsync.Store, outputFile string) error {
+	entry, err := store.Add(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to file snapshot in the store: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot filed in the store as %s\n", entry.ID)
+	return nil
+}
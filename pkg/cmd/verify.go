@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+	"github.com/spf13/cobra"
+)
+
+// NewVerifyCommand creates a new command for verifying a snapshot signature
+func NewVerifyCommand() *cobra.Command {
+	opts := &VerifyOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the signature of a snapshot",
+		Long: `Verify the detached signature written by capture --sign-key, proving the
+snapshot was not changed after it was signed. The signature covers the
+snapshot content, so it stays valid when the snapshot is converted or
+compressed.
+
+The signature must have been made by a trusted public key, given with --key
+or as a keyring file of PEM public keys in MESHSYNC_SNAPSHOT_TRUSTED_KEYS.
+The public key a signature carries is never trusted on its own, since anyone
+who changes a snapshot can sign it again with their own key.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerify(opts)
+		},
+	}
+
+	// Add flags specific to verify command
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "meshsync-snapshot.yaml", "Input snapshot file path, store reference such as prod@latest, or - for stdin")
+	cmd.Flags().StringVar(&opts.SignatureFile, "signature", "", "Signature file (default: the input path with a .sig extension)")
+	cmd.Flags().StringVar(&opts.KeyFile, "key", "", "PEM public key, or keyring of several, the snapshot must be signed with (default: the file in $MESHSYNC_SNAPSHOT_TRUSTED_KEYS)")
+
+	return cmd
+}
+
+// VerifyOptions contains options for verify command
+type VerifyOptions struct {
+	InputFile     string
+	SignatureFile string
+	KeyFile       string
+}
+
+// runVerify checks a snapshot against its signature
+func runVerify(opts *VerifyOptions) error {
+	inputFile, err := resolveSnapshotInput(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to verify snapshot: %w", err)
+	}
+	snapshot, err := meshsync.LoadSnapshot(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to verify snapshot: %w", err)
+	}
+
+	sig, err := verifySignature(snapshot, inputFile, opts.SignatureFile, opts.KeyFile, "--key")
+	if err != nil {
+		return fmt.Errorf("failed to verify snapshot: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Signature valid: signed by %s with %s key %s at %s\n", sig.Signer, sig.Algorithm, sig.KeyID, sig.Timestamp)
+	return nil
+}
@@ -5,21 +5,28 @@ import (
 	"os"
 	"path/filepath"
 
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
-// Client is a wrapper around the Kubernetes clientset
+// Client is a wrapper around the Kubernetes clients used by the plugin
 type Client struct {
-	Clientset *kubernetes.Clientset
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Discovery discovery.DiscoveryInterface
 	Config    *rest.Config
+	// Context is the kubeconfig context in use, empty when running in-cluster
+	Context string
 }
 
 // NewClient creates a new Kubernetes client
 func NewClient() (*Client, error) {
 	var config *rest.Config
+	var contextName string
 	var err error
 
 	// Try in-cluster config first
@@ -36,10 +43,19 @@ func NewClient() (*Client, error) {
 		}
 
 		// Use the current context in kubeconfig
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+			&clientcmd.ConfigOverrides{},
+		)
+		config, err = clientConfig.ClientConfig()
 		if err != nil {
 			return nil, fmt.Errorf("failed to build config from kubeconfig: %w", err)
 		}
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+		contextName = rawConfig.CurrentContext
 	}
 
 	// Create the clientset
@@ -48,8 +64,29 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
 	}
 
+	// Create the dynamic client
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	client := NewClientFromInterfaces(clientset, dynamicClient, clientset.Discovery())
+	client.Config = config
+	client.Context = contextName
+	return client, nil
+}
+
+// NewClientFromInterfaces creates a client from existing clients, such as
+// the fakes in k8s.io/client-go/kubernetes/fake. When discoveryClient is nil
+// the clientset's discovery client is used.
+func NewClientFromInterfaces(clientset kubernetes.Interface, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) *Client {
+	if discoveryClient == nil && clientset != nil {
+		discoveryClient = clientset.Discovery()
+	}
+
 	return &Client{
 		Clientset: clientset,
-		Config:    config,
-	}, nil
-} 
\ No newline at end of file
+		Dynamic:   dynamicClient,
+		Discovery: discoveryClient,
+	}
+}
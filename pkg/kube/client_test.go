@@ -3,6 +3,10 @@ package kube
 import (
 	"os"
 	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestNewClient(t *testing.T) {
@@ -66,10 +70,29 @@ users:
 			// Set the KUBECONFIG environment variable for this test
 			os.Setenv("KUBECONFIG", tt.kubeconfig)
 
-			_, err := NewClient()
+			client, err := NewClient()
 			if (err != nil) != tt.expectError {
 				t.Errorf("NewClient() error = %v, expectError %v", err, tt.expectError)
 			}
+			if err == nil && client.Context != "test-context" {
+				t.Errorf("NewClient() context = %s, want test-context", client.Context)
+			}
 		})
 	}
-} 
\ No newline at end of file
+}
+
+func TestNewClientFromInterfaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	client := NewClientFromInterfaces(clientset, dynamicClient, nil)
+	if client.Clientset != clientset {
+		t.Errorf("Clientset was not set from the given interface")
+	}
+	if client.Dynamic != dynamicClient {
+		t.Errorf("Dynamic was not set from the given interface")
+	}
+	if client.Discovery == nil {
+		t.Errorf("Discovery should default to the clientset discovery client")
+	}
+}
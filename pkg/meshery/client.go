@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
 )
 
 // Client represents a client for Meshery API
@@ -16,6 +18,16 @@ type Client struct {
 	BaseURL    string
 	Token      string
 	HTTPClient *http.Client
+	// APIVersion is the snapshot schema version uploads are sent in.
+	// NewClient sets it to meshsync.APIVersionV1Alpha1, the version
+	// Meshery's import endpoint reads.
+	APIVersion string
+	// ContentEncoding compresses snapshot uploads with meshsync.CompressionGzip
+	// or meshsync.CompressionZstd, for servers that decode it. Uploads are
+	// retried uncompressed if the server replies 415 Unsupported Media Type;
+	// servers that fail on the body some other way are not detected, so it
+	// is off by default.
+	ContentEncoding string
 }
 
 // SnapshotImportResponse represents the response from Meshery after importing a snapshot
@@ -33,51 +45,49 @@ func NewClient(baseURL, token string) (*Client, error) {
 	}
 
 	return &Client{
-		BaseURL: baseURL,
-		Token:   token,
+		BaseURL:    baseURL,
+		Token:      token,
+		APIVersion: meshsync.APIVersionV1Alpha1,
 		HTTPClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}, nil
 }
 
-// ImportSnapshot imports a snapshot file to Meshery
-func (c *Client) ImportSnapshot(ctx context.Context, filePath string) error {
-	// Read the snapshot file
-	snapshotData, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read snapshot file: %w", err)
+// ImportSnapshot imports a snapshot to Meshery. The snapshot is sent as JSON
+// in c.APIVersion, whatever format and version it was loaded from.
+func (c *Client) ImportSnapshot(ctx context.Context, snapshot *meshsync.Snapshot) error {
+	// Writers lay the snapshot out for the apiVersion it is tagged with
+	upload := *snapshot
+	if c.APIVersion != "" {
+		upload.APIVersion = c.APIVersion
 	}
 
-	// Create the request
-	endpoint := fmt.Sprintf("%s/api/meshsync/snapshot/import", c.BaseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(snapshotData))
+	var snapshotData bytes.Buffer
+	writer, err := meshsync.NewSnapshotWriter(&snapshotData, meshsync.FormatJSON)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	if c.Token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	if err := meshsync.WriteSnapshot(writer, &upload); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
 	}
 
-	// Send the request
-	resp, err := c.HTTPClient.Do(req)
+	statusCode, body, err := c.postSnapshot(ctx, snapshotData.Bytes(), c.ContentEncoding)
 	if err != nil {
-		return fmt.Errorf("failed to send request to Meshery: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+	// Servers that do not accept the content encoding reply 415, so retry uncompressed
+	if statusCode == http.StatusUnsupportedMediaType && c.ContentEncoding != meshsync.CompressionNone {
+		statusCode, body, err = c.postSnapshot(ctx, snapshotData.Bytes(), meshsync.CompressionNone)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Handle non-success responses
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Meshery API returned non-success status code %d: %s", resp.StatusCode, string(body))
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("Meshery API returned non-success status code %d: %s", statusCode, string(body))
 	}
 
 	// Parse response
@@ -93,4 +103,51 @@ func (c *Client) ImportSnapshot(ctx context.Context, filePath string) error {
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+// postSnapshot sends a JSON snapshot to the import endpoint, compressed with
+// the given content encoding, and returns the response status and body
+func (c *Client) postSnapshot(ctx context.Context, snapshotData []byte, encoding string) (int, []byte, error) {
+	var requestBody bytes.Buffer
+	compressor, err := meshsync.NewCompressor(&requestBody, encoding)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := compressor.Write(snapshotData); err != nil {
+		return 0, nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return 0, nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+
+	// Create the request
+	endpoint := fmt.Sprintf("%s/api/meshsync/snapshot/import", c.BaseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, &requestBody)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != meshsync.CompressionNone {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	}
+
+	// Send the request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request to Meshery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return resp.StatusCode, body, nil
+}
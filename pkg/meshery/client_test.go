@@ -0,0 +1,199 @@
+package meshery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/meshsync"
+)
+
+// importRequest is a request received by fakeMeshery
+type importRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// fakeMeshery serves the snapshot import endpoint, replying with status
+// and body, and records the requests it gets
+func fakeMeshery(t *testing.T, reply func(r *http.Request, body []byte) (int, string)) (*httptest.Server, *[]importRequest) {
+	t.Helper()
+	var requests []importRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/meshsync/snapshot/import" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, importRequest{header: r.Header.Clone(), body: body})
+		status, response := reply(r, body)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// newImportSnapshot returns a v1alpha2 snapshot of one deployment
+func newImportSnapshot() *meshsync.Snapshot {
+	return &meshsync.Snapshot{
+		APIVersion: meshsync.APIVersionV1Alpha2,
+		Kind:       meshsync.SnapshotKind,
+		Metadata: meshsync.SnapshotMetadata{
+			Name:      "prod",
+			Timestamp: "2026-01-01T12:00:00Z",
+			Cluster:   &meshsync.ClusterInfo{ID: "5c1e0d", Context: "prod"},
+		},
+		Resources: []meshsync.Resource{meshsync.NewResource(map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+			"spec":       map[string]interface{}{"replicas": float64(2)},
+		})},
+	}
+}
+
+func TestImportSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "success", status: http.StatusOK, body: `{"status":"success"}`},
+		{name: "server error", status: http.StatusInternalServerError, body: "boom", wantErr: "non-success status code 500: boom"},
+		{name: "import failed", status: http.StatusOK, body: `{"status":"error","message":"bad snapshot"}`, wantErr: "import failed: bad snapshot"},
+		{name: "invalid response", status: http.StatusOK, body: "<html>", wantErr: "failed to parse response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := fakeMeshery(t, func(*http.Request, []byte) (int, string) {
+				return tt.status, tt.body
+			})
+			client, err := NewClient(server.URL, "secret-token")
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			err = client.ImportSnapshot(context.Background(), newImportSnapshot())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ImportSnapshot() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ImportSnapshot() error = %v", err)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("ImportSnapshot() sent %d requests, want 1", len(*requests))
+			}
+			header := (*requests)[0].header
+			if header.Get("Authorization") != "Bearer secret-token" || header.Get("Content-Type") != "application/json" {
+				t.Errorf("ImportSnapshot() sent headers %v", header)
+			}
+		})
+	}
+}
+
+func TestImportSnapshotPayload(t *testing.T) {
+	server, requests := fakeMeshery(t, func(*http.Request, []byte) (int, string) {
+		return http.StatusOK, `{"status":"success"}`
+	})
+	client, err := NewClient(server.URL, "")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	snapshot := newImportSnapshot()
+	if err := client.ImportSnapshot(context.Background(), snapshot); err != nil {
+		t.Fatalf("ImportSnapshot() error = %v", err)
+	}
+	if snapshot.APIVersion != meshsync.APIVersionV1Alpha2 {
+		t.Errorf("ImportSnapshot() changed the snapshot apiVersion to %s", snapshot.APIVersion)
+	}
+
+	// Meshery reads v1alpha1 JSON, whatever version the snapshot is in
+	var payload struct {
+		APIVersion string                   `json:"apiVersion"`
+		Kind       string                   `json:"kind"`
+		Metadata   map[string]interface{}   `json:"metadata"`
+		Resources  []map[string]interface{} `json:"resources"`
+	}
+	if err := json.Unmarshal((*requests)[0].body, &payload); err != nil {
+		t.Fatalf("ImportSnapshot() sent invalid JSON: %v", err)
+	}
+	if payload.APIVersion != meshsync.APIVersionV1Alpha1 || payload.Kind != meshsync.SnapshotKind {
+		t.Errorf("ImportSnapshot() sent %s %s, want %s %s", payload.APIVersion, payload.Kind, meshsync.APIVersionV1Alpha1, meshsync.SnapshotKind)
+	}
+	if payload.Metadata["name"] != "prod" || payload.Metadata["timestamp"] != "2026-01-01T12:00:00Z" {
+		t.Errorf("ImportSnapshot() sent metadata %v", payload.Metadata)
+	}
+	if len(payload.Resources) != 1 || payload.Resources[0]["kind"] != "Deployment" {
+		t.Errorf("ImportSnapshot() sent resources %v", payload.Resources)
+	}
+
+	// The payload loads back as the snapshot that was imported
+	loaded, err := meshsync.DecodeSnapshot((*requests)[0].body)
+	if err != nil {
+		t.Fatalf("DecodeSnapshot() error = %v", err)
+	}
+	if loaded.Metadata.Cluster == nil || loaded.Metadata.Cluster.ID != "5c1e0d" || len(loaded.Resources) != 1 {
+		t.Errorf("Payload loaded as %+v", loaded.Metadata)
+	}
+}
+
+func TestImportSnapshotCompression(t *testing.T) {
+	tests := []struct {
+		name          string
+		encoding      string
+		rejectEncoded bool
+		wantEncodings []string
+	}{
+		{name: "none", encoding: meshsync.CompressionNone, wantEncodings: []string{""}},
+		{name: "gzip", encoding: meshsync.CompressionGzip, wantEncodings: []string{"gzip"}},
+		{name: "zstd", encoding: meshsync.CompressionZstd, wantEncodings: []string{"zstd"}},
+		{name: "unsupported encoding", encoding: meshsync.CompressionGzip, rejectEncoded: true, wantEncodings: []string{"gzip", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := fakeMeshery(t, func(r *http.Request, body []byte) (int, string) {
+				if tt.rejectEncoded && r.Header.Get("Content-Encoding") != "" {
+					return http.StatusUnsupportedMediaType, "unsupported content encoding"
+				}
+				return http.StatusOK, `{"status":"success"}`
+			})
+			client, err := NewClient(server.URL, "")
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			client.ContentEncoding = tt.encoding
+			if err := client.ImportSnapshot(context.Background(), newImportSnapshot()); err != nil {
+				t.Fatalf("ImportSnapshot() error = %v", err)
+			}
+
+			if len(*requests) != len(tt.wantEncodings) {
+				t.Fatalf("ImportSnapshot() sent %d requests, want %d", len(*requests), len(tt.wantEncodings))
+			}
+			for i, request := range *requests {
+				if got := request.header.Get("Content-Encoding"); got != tt.wantEncodings[i] {
+					t.Errorf("Request %d Content-Encoding = %q, want %q", i, got, tt.wantEncodings[i])
+				}
+				// Every request carries the same snapshot, however it is encoded
+				snapshot, err := meshsync.ReadSnapshot(bytes.NewReader(request.body))
+				if err != nil {
+					t.Fatalf("Request %d body error = %v", i, err)
+				}
+				if snapshot.Metadata.Name != "prod" || len(snapshot.Resources) != 1 {
+					t.Errorf("Request %d sent %+v", i, snapshot.Metadata)
+				}
+			}
+		})
+	}
+}
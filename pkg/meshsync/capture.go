@@ -0,0 +1,444 @@
+package meshsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// DefaultKinds are the resource types captured when no kinds are given
+var DefaultKinds = []string{"deployments"}
+
+// DefaultConcurrency is the default number of list calls run in parallel
+const DefaultConcurrency = 8
+
+// CaptureOptions contains options for capturing snapshot
+type CaptureOptions struct {
+	Namespace     string
+	AllNamespaces bool
+	// Name and Labels are user-defined snapshot metadata
+	Name   string
+	Labels map[string]string
+	// MeshSyncVersion is the version of the validated MeshSync deployment,
+	// recorded in the snapshot metadata
+	MeshSyncVersion string
+	// Kinds are the resource types to capture, e.g. "deployments" or
+	// "ingresses.networking.k8s.io"; DefaultKinds is used when empty
+	Kinds []string
+	// ChunkSize is the number of items requested per list call; 0 disables paging
+	ChunkSize int64
+	// Concurrency is the number of list calls run in parallel
+	Concurrency int
+	// ContinueOnError records failed lists in the snapshot status instead
+	// of aborting the capture
+	ContinueOnError bool
+}
+
+// resourceKind is a resource type resolved against the server's discovery API
+type resourceKind struct {
+	GVR        schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+}
+
+// captureTask lists one resource type in one namespace. Cluster-scoped
+// types are listed once with an empty namespace.
+type captureTask struct {
+	kind      resourceKind
+	namespace string
+}
+
+// captureResult holds the outcome of a captureTask once all its pages
+// are passed on
+type captureResult struct {
+	restarts int
+	err      error
+}
+
+// captureStream carries the pages of a captureTask, in the order they
+// were listed, and then its result
+type captureStream struct {
+	pages  chan []Resource
+	result chan captureResult
+}
+
+// CaptureSnapshot captures cluster state using MeshSync. If ctx ends during
+// the capture, the resources captured so far are returned along with the
+// error, in a snapshot whose status is marked incomplete.
+func CaptureSnapshot(ctx context.Context, client *kube.Client, opts CaptureOptions) (*Snapshot, error) {
+	collector := &snapshotCollector{}
+	status, err := StreamSnapshot(ctx, client, opts, collector)
+	if status == nil {
+		return nil, err
+	}
+	collector.Close(status)
+	return collector.snapshot, err
+}
+
+// StreamSnapshot captures cluster state, passing each resource to w as soon
+// as it can be written in order, so the whole snapshot is never held in
+// memory. It writes the header and resources and returns the capture status;
+// closing w is left to the caller. If ctx ends once the header is written,
+// the status is returned along with the error, marked incomplete, so that
+// the resources written so far can still be saved as a snapshot.
+func StreamSnapshot(ctx context.Context, client *kube.Client, opts CaptureOptions, w SnapshotWriter) (*SnapshotStatus, error) {
+	// This is a simplified implementation
+	// In a real implementation, this would:
+	// 1. Connect to the MeshSync API or query its data store
+	// 2. Gather all resources based on filters
+	// 3. Format them into a structured snapshot
+	start := time.Now()
+	status := &SnapshotStatus{Counts: map[string]int{}}
+
+	kinds, err := resolveKinds(client, opts.Kinds)
+	if err != nil {
+		return nil, err
+	}
+	for _, kind := range kinds {
+		status.Counts[countKey(kind)] = 0
+	}
+
+	header := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   newSnapshotMetadata(ctx, client, opts, kinds, start),
+	}
+
+	// Get namespaces
+	namespaces := []string{opts.Namespace}
+	if opts.AllNamespaces {
+		namespaces = []string{}
+		restarts, err := listAllPages(ctx, opts.ChunkSize, func(ctx context.Context, listOpts metav1.ListOptions) (metav1.ListInterface, error) {
+			nsList, err := client.Clientset.CoreV1().Namespaces().List(ctx, listOpts)
+			if err != nil {
+				return nil, err
+			}
+			for _, ns := range nsList.Items {
+				namespaces = append(namespaces, ns.Name)
+			}
+			return nsList, nil
+		}, func() {
+			namespaces = []string{}
+		})
+		if err != nil {
+			if !opts.ContinueOnError || ctx.Err() != nil {
+				return nil, fmt.Errorf("failed to list namespaces: %w", err)
+			}
+			// Cluster-scoped kinds can still be captured
+			status.recordError(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "", err)
+			namespaces = []string{}
+		}
+		status.recordRelist("namespaces", "", restarts)
+	}
+
+	if err := w.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	// Pages are written in task order, so sorting the tasks by group, kind
+	// and namespace and each page by name keeps the snapshot deterministic
+	// whatever order the lists complete in. A list that fails part way, with
+	// --continue-on-error, keeps the pages already written.
+	tasks := newCaptureTasks(kinds, namespaces)
+	err = runCaptureTasks(ctx, client, tasks, opts, func(task captureTask, page []Resource) error {
+		status.Counts[countKey(task.kind)] += len(page)
+		for i := range page {
+			if err := w.WriteResource(&page[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(task captureTask, result captureResult) error {
+		if result.err != nil {
+			if !opts.ContinueOnError || ctx.Err() != nil {
+				return result.err
+			}
+			status.recordError(task.kind.GVR, task.namespace, result.err)
+			return nil
+		}
+		status.recordRelist(task.kind.GVR.Resource, task.namespace, result.restarts)
+		return nil
+	})
+	status.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		if ctx.Err() == nil {
+			return nil, err
+		}
+		status.Incomplete = true
+		status.IncompleteReason = incompleteReason(ctx.Err())
+		return status, err
+	}
+	return status, nil
+}
+
+// incompleteReason describes why a context ended for the snapshot status
+func incompleteReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timed out"
+	}
+	return "interrupted"
+}
+
+// resolveKinds maps resource names such as "deploy" or "ingresses.networking.k8s.io"
+// to the resource types served by the cluster
+func resolveKinds(client *kube.Client, names []string) ([]resourceKind, error) {
+	if len(names) == 0 {
+		names = DefaultKinds
+	}
+
+	groupResources, err := restmapper.GetAPIGroupResources(client.Discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover server resources: %w", err)
+	}
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDiscoveryRESTMapper(groupResources), client.Discovery, nil)
+
+	var kinds []resourceKind
+	seen := map[schema.GroupVersionResource]bool{}
+	for _, name := range names {
+		gvr, err := mapper.ResourceFor(schema.ParseGroupResource(name).WithVersion(""))
+		if err != nil {
+			return nil, fmt.Errorf("unknown resource type %q: %w", name, err)
+		}
+		if seen[gvr] {
+			continue
+		}
+		seen[gvr] = true
+
+		gvk, err := mapper.KindFor(gvr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find kind for resource type %q: %w", name, err)
+		}
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map resource type %q: %w", name, err)
+		}
+
+		kinds = append(kinds, resourceKind{
+			GVR:        gvr,
+			Kind:       gvk.Kind,
+			Namespaced: mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+		})
+	}
+
+	return kinds, nil
+}
+
+// newCaptureTasks pairs every kind with every namespace, sorted by group,
+// kind and namespace
+func newCaptureTasks(kinds []resourceKind, namespaces []string) []captureTask {
+	var tasks []captureTask
+	for _, kind := range kinds {
+		if !kind.Namespaced {
+			tasks = append(tasks, captureTask{kind: kind})
+			continue
+		}
+		for _, ns := range namespaces {
+			tasks = append(tasks, captureTask{kind: kind, namespace: ns})
+		}
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.kind.GVR.Group != b.kind.GVR.Group {
+			return a.kind.GVR.Group < b.kind.GVR.Group
+		}
+		if a.kind.Kind != b.kind.Kind {
+			return a.kind.Kind < b.kind.Kind
+		}
+		return a.namespace < b.namespace
+	})
+	return tasks
+}
+
+// runCaptureTasks runs the tasks on a bounded pool of workers and passes
+// each page of resources to emit, and then each result, failed or not, to
+// finish, in task order. Workers only run ahead of the oldest unwritten task
+// by a window of 2*concurrency tasks, and a task blocks once it has a page
+// waiting, so at most 4*concurrency pages are held in memory however large
+// the cluster is. The first error returned by emit or finish cancels the
+// remaining tasks.
+func runCaptureTasks(ctx context.Context, client *kube.Client, tasks []captureTask, opts CaptureOptions, emit func(captureTask, []Resource) error, finish func(captureTask, captureResult) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Each task reports to its own channels, so a worker only blocks once
+	// its task has a page waiting to be written
+	streams := make([]captureStream, len(tasks))
+	for i := range streams {
+		streams[i] = captureStream{pages: make(chan []Resource, 1), result: make(chan captureResult, 1)}
+	}
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	indexes := make(chan int)
+	for w := 0; w < concurrency && w < len(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				stream := streams[i]
+				result := runCaptureTask(ctx, client, tasks[i], opts.ChunkSize, func(page []Resource) error {
+					select {
+					case stream.pages <- page:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+				close(stream.pages)
+				stream.result <- result
+			}
+		}()
+	}
+
+	window := make(chan struct{}, 2*concurrency)
+	go func() {
+		defer close(indexes)
+		for i := range tasks {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i, task := range tasks {
+		stream := streams[i]
+		for {
+			var page []Resource
+			var ok bool
+			select {
+			case page, ok = <-stream.pages:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !ok {
+				break
+			}
+			if err := emit(task, page); err != nil {
+				return err
+			}
+		}
+		result := <-stream.result
+		<-window
+
+		if err := finish(task, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runCaptureTask lists a single resource type in a single namespace,
+// passing each page to send sorted by name as soon as it is listed. Pages
+// already sent cannot be taken back, so a list restarted after its continue
+// token expired picks up after the last name sent, relying on the API
+// server listing in name order as it does for etcd-backed resources.
+func runCaptureTask(ctx context.Context, client *kube.Client, task captureTask, chunkSize int64, send func([]Resource) error) captureResult {
+	var last string
+	restarted := false
+	restarts, err := listAllPages(ctx, chunkSize, func(ctx context.Context, listOpts metav1.ListOptions) (metav1.ListInterface, error) {
+		list, err := client.Dynamic.Resource(task.kind.GVR).Namespace(task.namespace).List(ctx, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		items := list.Items
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].GetName() < items[j].GetName()
+		})
+
+		page := make([]Resource, 0, len(items))
+		for _, item := range items {
+			if restarted && item.GetName() <= last {
+				continue
+			}
+			if item.GetName() > last {
+				last = item.GetName()
+			}
+			page = append(page, newResource(task.kind, item.Object))
+		}
+		if len(page) > 0 {
+			if err := send(page); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}, func() {
+		restarted = true
+	})
+	if err != nil {
+		if task.namespace == "" {
+			return captureResult{err: fmt.Errorf("failed to list %s: %w", task.kind.GVR.Resource, err)}
+		}
+		return captureResult{err: fmt.Errorf("failed to list %s in namespace %s: %w", task.kind.GVR.Resource, task.namespace, err)}
+	}
+	return captureResult{restarts: restarts}
+}
+
+// newResource converts an object returned by the API server to a snapshot
+// resource. List items may leave out apiVersion and kind, so they are set
+// from the kind that was listed.
+func newResource(kind resourceKind, object map[string]interface{}) Resource {
+	object["apiVersion"] = kind.GVR.GroupVersion().String()
+	object["kind"] = kind.Kind
+	return NewResource(object)
+}
+
+// recordRelist notes in the status that a list had to be restarted
+func (s *SnapshotStatus) recordRelist(resource, namespace string, restarts int) {
+	if restarts == 0 {
+		return
+	}
+	s.Relists = append(s.Relists, Relist{
+		Resource:  resource,
+		Namespace: namespace,
+		Restarts:  restarts,
+	})
+}
+
+// recordError notes in the status that a list failed
+func (s *SnapshotStatus) recordError(gvr schema.GroupVersionResource, namespace string, err error) {
+	captureErr := CaptureError{
+		APIVersion: gvr.GroupVersion().String(),
+		Resource:   gvr.Resource,
+		Namespace:  namespace,
+		Message:    err.Error(),
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		captureErr.Code = apiStatus.Status().Code
+		captureErr.Reason = string(apiStatus.Status().Reason)
+		if apiStatus.Status().Message != "" {
+			captureErr.Message = apiStatus.Status().Message
+		}
+	}
+
+	s.Errors = append(s.Errors, captureErr)
+}
@@ -0,0 +1,542 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// fakeAPIResources is the discovery information served by newFakeCaptureClient
+var fakeAPIResources = []*metav1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "namespaces", Kind: "Namespace", Namespaced: false},
+			{Name: "services", Kind: "Service", Namespaced: true, ShortNames: []string{"svc"}},
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, ShortNames: []string{"cm"}},
+			{Name: "secrets", Kind: "Secret", Namespaced: true},
+			{Name: "serviceaccounts", Kind: "ServiceAccount", Namespaced: true, ShortNames: []string{"sa"}},
+			{Name: "events", Kind: "Event", Namespaced: true, ShortNames: []string{"ev"}},
+		},
+	},
+	{
+		GroupVersion: "apps/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment", Namespaced: true, ShortNames: []string{"deploy"}},
+		},
+	},
+	{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "jobs", Kind: "Job", Namespaced: true},
+		},
+	},
+	{
+		GroupVersion: "rbac.authorization.k8s.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "roles", Kind: "Role", Namespaced: true},
+			{Name: "rolebindings", Kind: "RoleBinding", Namespaced: true},
+			{Name: "clusterrolebindings", Kind: "ClusterRoleBinding", Namespaced: false},
+		},
+	},
+	{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "gizmos", Kind: "Gizmo", Namespaced: true},
+		},
+	},
+}
+
+// newFakeCaptureClient returns a kube.Client whose typed, dynamic and
+// discovery clients all serve the given objects. Unstructured objects, such
+// as custom resources, are only served by the dynamic client.
+func newFakeCaptureClient(objects ...runtime.Object) (*kube.Client, *dynamicfake.FakeDynamicClient) {
+	var typed []runtime.Object
+	for _, object := range objects {
+		if _, ok := object.(*unstructured.Unstructured); !ok {
+			typed = append(typed, object)
+		}
+	}
+	clientset := fake.NewSimpleClientset(typed...)
+	clientset.Resources = fakeAPIResources
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, map[schema.GroupVersionResource]string{
+		{Group: "example.com", Version: "v1", Resource: "gizmos"}: "GizmoList",
+	}, objects...)
+	return kube.NewClientFromInterfaces(clientset, dynamicClient, nil), dynamicClient
+}
+
+// resourceNames returns the namespace/name of every resource in the snapshot
+func resourceNames(snapshot *Snapshot) []string {
+	var names []string
+	for _, resource := range snapshot.Resources {
+		names = append(names, fmt.Sprintf("%s/%s/%v/%v", resource.APIVersion(), resource.Kind(), resource.Metadata()["namespace"], resource.Metadata()["name"]))
+	}
+	return names
+}
+
+func TestCaptureSnapshot(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}},
+		newDeployment("web", "default", 2),
+		newDeployment("api", "default", 1),
+		newDeployment("meshsync", "meshery", 1),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+	}
+
+	tests := []struct {
+		name          string
+		opts          CaptureOptions
+		expectedNames []string
+	}{
+		{
+			name: "Single namespace",
+			opts: CaptureOptions{Namespace: "default"},
+			expectedNames: []string{
+				"apps/v1/Deployment/default/api",
+				"apps/v1/Deployment/default/web",
+			},
+		},
+		{
+			name: "All namespaces",
+			opts: CaptureOptions{AllNamespaces: true},
+			expectedNames: []string{
+				"apps/v1/Deployment/default/api",
+				"apps/v1/Deployment/default/web",
+				"apps/v1/Deployment/meshery/meshsync",
+			},
+		},
+		{
+			name:          "Empty namespace",
+			opts:          CaptureOptions{Namespace: "empty"},
+			expectedNames: nil,
+		},
+		{
+			name: "Multiple kinds sorted by group, kind, namespace and name",
+			opts: CaptureOptions{AllNamespaces: true, Kinds: []string{"deploy", "svc", "namespaces"}, Concurrency: 4},
+			expectedNames: []string{
+				"v1/Namespace/<nil>/default",
+				"v1/Namespace/<nil>/meshery",
+				"v1/Service/default/web",
+				"apps/v1/Deployment/default/api",
+				"apps/v1/Deployment/default/web",
+				"apps/v1/Deployment/meshery/meshsync",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newFakeCaptureClient(objects...)
+
+			snapshot, err := CaptureSnapshot(context.Background(), client, tt.opts)
+			if err != nil {
+				t.Fatalf("CaptureSnapshot() error = %v", err)
+			}
+
+			names := resourceNames(snapshot)
+			if len(names) != len(tt.expectedNames) {
+				t.Fatalf("CaptureSnapshot() captured %v, want %v", names, tt.expectedNames)
+			}
+			for i := range names {
+				if names[i] != tt.expectedNames[i] {
+					t.Errorf("Resource %d = %s, want %s", i, names[i], tt.expectedNames[i])
+				}
+			}
+			for i, resource := range snapshot.Resources {
+				if resource.Kind() == "Deployment" && resource.Spec() == nil {
+					t.Errorf("Resource %d is missing spec", i)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureSnapshotUnknownKind(t *testing.T) {
+	client, _ := newFakeCaptureClient()
+
+	_, err := CaptureSnapshot(context.Background(), client, CaptureOptions{Namespace: "default", Kinds: []string{"widgets"}})
+	if err == nil {
+		t.Fatal("CaptureSnapshot() expected error for unknown resource type")
+	}
+}
+
+func TestCaptureSnapshotListError(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	dynamicClient.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(appsv1.Resource("deployments"), "", nil)
+	})
+
+	_, err := CaptureSnapshot(context.Background(), client, CaptureOptions{Namespace: "default"})
+	if err == nil {
+		t.Fatal("CaptureSnapshot() expected error when listing is forbidden")
+	}
+}
+
+func TestCaptureSnapshotInterrupted(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b"}},
+		newDeployment("web", "a", 1),
+		newDeployment("api", "b", 1),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "b" {
+			cancel()
+			return true, nil, ctx.Err()
+		}
+		return false, nil, nil
+	})
+
+	snapshot, err := CaptureSnapshot(ctx, client, CaptureOptions{AllNamespaces: true, Concurrency: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CaptureSnapshot() error = %v, want context.Canceled", err)
+	}
+	if snapshot == nil || snapshot.Status == nil {
+		t.Fatalf("CaptureSnapshot() snapshot = %+v, want a partial snapshot", snapshot)
+	}
+	if !snapshot.Status.Incomplete || snapshot.Status.IncompleteReason != "interrupted" {
+		t.Errorf("CaptureSnapshot() status = %+v, want it marked incomplete because it was interrupted", snapshot.Status)
+	}
+	if snapshot.Metadata.Name == "" {
+		t.Errorf("CaptureSnapshot() metadata = %+v, want the header kept", snapshot.Metadata)
+	}
+	if count := snapshot.Status.Counts["Deployment.apps"]; count != len(snapshot.Resources) {
+		t.Errorf("CaptureSnapshot() counted %d deployments, want the %d written", count, len(snapshot.Resources))
+	}
+	for _, name := range resourceNames(snapshot) {
+		if name != "apps/v1/Deployment/a/web" {
+			t.Errorf("CaptureSnapshot() captured %s after the interrupt", name)
+		}
+	}
+}
+
+// pagingDynamicClient serves list calls from a function, since the fake
+// dynamic client does not pass Limit and Continue through to reactors
+type pagingDynamicClient struct {
+	dynamic.Interface
+	list func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error)
+}
+
+func (c *pagingDynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &pagingResource{NamespaceableResourceInterface: c.Interface.Resource(gvr), list: c.list}
+}
+
+type pagingResource struct {
+	dynamic.NamespaceableResourceInterface
+	namespace string
+	list      func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error)
+}
+
+func (r *pagingResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &pagingResource{NamespaceableResourceInterface: r.NamespaceableResourceInterface, namespace: namespace, list: r.list}
+}
+
+func (r *pagingResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	return r.list(r.namespace, opts)
+}
+
+func TestCaptureSnapshotContinueOnError(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "restricted"}},
+		newDeployment("web", "default", 1),
+		newDeployment("secret-app", "restricted", 1),
+	)
+	dynamicClient.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "restricted" {
+			return true, nil, apierrors.NewForbidden(appsv1.Resource("deployments"), "", fmt.Errorf("access denied"))
+		}
+		return false, nil, nil
+	})
+
+	snapshot, err := CaptureSnapshot(context.Background(), client, CaptureOptions{AllNamespaces: true, ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+
+	names := resourceNames(snapshot)
+	if len(names) != 1 || names[0] != "apps/v1/Deployment/default/web" {
+		t.Errorf("CaptureSnapshot() captured %v, want only the deployment in default", names)
+	}
+
+	if snapshot.Status == nil || len(snapshot.Status.Errors) != 1 {
+		t.Fatalf("CaptureSnapshot() status = %+v, want one error", snapshot.Status)
+	}
+	captureErr := snapshot.Status.Errors[0]
+	if captureErr.APIVersion != "apps/v1" || captureErr.Resource != "deployments" || captureErr.Namespace != "restricted" {
+		t.Errorf("CaptureSnapshot() error for %s %s in %s, want apps/v1 deployments in restricted", captureErr.APIVersion, captureErr.Resource, captureErr.Namespace)
+	}
+	if captureErr.Code != 403 || captureErr.Reason != string(metav1.StatusReasonForbidden) || captureErr.Message == "" {
+		t.Errorf("CaptureSnapshot() error = %+v, want a 403 Forbidden with a message", captureErr)
+	}
+}
+
+func TestCaptureSnapshotContinueOnErrorNamespaces(t *testing.T) {
+	client, _ := newFakeCaptureClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	client.Clientset.(*fake.Clientset).PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "", fmt.Errorf("access denied"))
+	})
+
+	opts := CaptureOptions{AllNamespaces: true, Kinds: []string{"deployments", "namespaces"}, ContinueOnError: true}
+	snapshot, err := CaptureSnapshot(context.Background(), client, opts)
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+	if snapshot.Status == nil || len(snapshot.Status.Errors) != 1 || snapshot.Status.Errors[0].Resource != "namespaces" {
+		t.Fatalf("CaptureSnapshot() status = %+v, want a namespaces error", snapshot.Status)
+	}
+
+	// Without the option the same failure aborts the capture
+	opts.ContinueOnError = false
+	if _, err := CaptureSnapshot(context.Background(), client, opts); err == nil {
+		t.Fatal("CaptureSnapshot() expected error when namespaces cannot be listed")
+	}
+}
+
+func TestCaptureSnapshotConcurrent(t *testing.T) {
+	var objects []runtime.Object
+	var expected []string
+	for i := 0; i < 10; i++ {
+		ns := fmt.Sprintf("ns-%02d", i)
+		objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+		expected = append(expected, fmt.Sprintf("apps/v1/Deployment/%s/app", ns))
+	}
+	client, _ := newFakeCaptureClient(objects...)
+
+	// Complete the lists in reverse namespace order and track how many run at once
+	var running, maxRunning int32
+	client.Dynamic = &pagingDynamicClient{
+		Interface: client.Dynamic,
+		list: func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			index, _ := strconv.Atoi(namespace[len("ns-"):])
+			time.Sleep(time.Duration(10-index) * 5 * time.Millisecond)
+
+			object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newDeployment("app", namespace, 1))
+			if err != nil {
+				return nil, err
+			}
+			return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: object}}}, nil
+		},
+	}
+
+	snapshot, err := CaptureSnapshot(context.Background(), client, CaptureOptions{AllNamespaces: true, Concurrency: 3})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+
+	names := resourceNames(snapshot)
+	if len(names) != len(expected) {
+		t.Fatalf("CaptureSnapshot() captured %v, want %v", names, expected)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Errorf("Resource %d = %s, want %s", i, names[i], expected[i])
+		}
+	}
+	if maxRunning < 2 || maxRunning > 3 {
+		t.Errorf("CaptureSnapshot() ran %d lists at once, want 2 to 3", maxRunning)
+	}
+}
+
+func TestCaptureSnapshotPaginated(t *testing.T) {
+	client, _ := newFakeCaptureClient()
+
+	var deployments []unstructured.Unstructured
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newDeployment(name, "default", 1))
+		if err != nil {
+			t.Fatalf("Failed to convert deployment: %v", err)
+		}
+		deployments = append(deployments, unstructured.Unstructured{Object: object})
+	}
+
+	// Serve deployments two at a time and expire the first continue token once
+	expired := false
+	var limits []int64
+	client.Dynamic = &pagingDynamicClient{
+		Interface: client.Dynamic,
+		list: func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+			limits = append(limits, opts.Limit)
+			start := 0
+			if opts.Continue != "" {
+				if !expired {
+					expired = true
+					return nil, apierrors.NewResourceExpired("continue token expired")
+				}
+				start, _ = strconv.Atoi(opts.Continue)
+			}
+			end := start + int(opts.Limit)
+			list := &unstructured.UnstructuredList{}
+			if end < len(deployments) {
+				list.SetContinue(strconv.Itoa(end))
+			} else {
+				end = len(deployments)
+			}
+			list.Items = deployments[start:end]
+			return list, nil
+		},
+	}
+
+	snapshot, err := CaptureSnapshot(context.Background(), client, CaptureOptions{Namespace: "default", ChunkSize: 2})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != len(deployments) {
+		t.Errorf("CaptureSnapshot() captured %d resources, want %d", len(snapshot.Resources), len(deployments))
+	}
+	if len(limits) != 5 {
+		t.Errorf("CaptureSnapshot() made %d list calls, want 5", len(limits))
+	}
+	for _, limit := range limits {
+		if limit != 2 {
+			t.Errorf("CaptureSnapshot() listed with limit %d, want 2", limit)
+		}
+	}
+
+	if snapshot.Status == nil || len(snapshot.Status.Relists) != 1 {
+		t.Fatalf("CaptureSnapshot() status = %+v, want one relist", snapshot.Status)
+	}
+	relist := snapshot.Status.Relists[0]
+	if relist.Resource != "deployments" || relist.Namespace != "default" || relist.Restarts != 1 {
+		t.Errorf("CaptureSnapshot() relist = %+v, want deployments in default restarted once", relist)
+	}
+}
+
+func TestStreamSnapshotBoundsBufferedResults(t *testing.T) {
+	var objects []runtime.Object
+	for i := 0; i < 20; i++ {
+		objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%02d", i)}})
+	}
+	client, _ := newFakeCaptureClient(objects...)
+
+	// Hold up the first namespace and count how many lists start meanwhile
+	var started, startedBeforeFirst int32
+	client.Dynamic = &pagingDynamicClient{
+		Interface: client.Dynamic,
+		list: func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+			atomic.AddInt32(&started, 1)
+			if namespace == "ns-00" {
+				time.Sleep(50 * time.Millisecond)
+				atomic.StoreInt32(&startedBeforeFirst, atomic.LoadInt32(&started))
+			}
+			object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newDeployment("app", namespace, 1))
+			if err != nil {
+				return nil, err
+			}
+			return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: object}}}, nil
+		},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewSnapshotWriter(&buf, FormatNDJSON)
+	if err != nil {
+		t.Fatalf("NewSnapshotWriter() error = %v", err)
+	}
+	status, err := StreamSnapshot(context.Background(), client, CaptureOptions{AllNamespaces: true, Concurrency: 2}, w)
+	if err != nil {
+		t.Fatalf("StreamSnapshot() error = %v", err)
+	}
+	if err := w.Close(status); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 22 {
+		t.Errorf("StreamSnapshot() wrote %d lines, want a header, 20 resources and a status", lines)
+	}
+	if window := int32(2 * 2); startedBeforeFirst > window {
+		t.Errorf("StreamSnapshot() started %d lists while the first was pending, want at most %d", startedBeforeFirst, window)
+	}
+}
+
+// countingWriter counts the resources written through it
+type countingWriter struct {
+	SnapshotWriter
+	written int32
+}
+
+func (w *countingWriter) WriteResource(resource *Resource) error {
+	atomic.AddInt32(&w.written, 1)
+	return w.SnapshotWriter.WriteResource(resource)
+}
+
+func TestStreamSnapshotWritesPagesAsListed(t *testing.T) {
+	client, _ := newFakeCaptureClient()
+
+	var deployments []unstructured.Unstructured
+	for i := 0; i < 10; i++ {
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newDeployment(fmt.Sprintf("app-%02d", i), "default", 1))
+		if err != nil {
+			t.Fatalf("Failed to convert deployment: %v", err)
+		}
+		deployments = append(deployments, unstructured.Unstructured{Object: object})
+	}
+
+	inner, err := NewSnapshotWriter(&bytes.Buffer{}, FormatNDJSON)
+	if err != nil {
+		t.Fatalf("NewSnapshotWriter() error = %v", err)
+	}
+	w := &countingWriter{SnapshotWriter: inner}
+
+	// Serve two deployments a page and note what was written before the last
+	writtenBeforeLast := int32(-1)
+	client.Dynamic = &pagingDynamicClient{
+		Interface: client.Dynamic,
+		list: func(namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+			start, _ := strconv.Atoi(opts.Continue)
+			end := start + int(opts.Limit)
+			list := &unstructured.UnstructuredList{}
+			if end < len(deployments) {
+				list.SetContinue(strconv.Itoa(end))
+			} else {
+				end = len(deployments)
+				atomic.StoreInt32(&writtenBeforeLast, atomic.LoadInt32(&w.written))
+			}
+			list.Items = append([]unstructured.Unstructured(nil), deployments[start:end]...)
+			return list, nil
+		},
+	}
+
+	status, err := StreamSnapshot(context.Background(), client, CaptureOptions{Namespace: "default", ChunkSize: 2}, w)
+	if err != nil {
+		t.Fatalf("StreamSnapshot() error = %v", err)
+	}
+	if status.Counts["Deployment.apps"] != 10 || w.written != 10 {
+		t.Errorf("StreamSnapshot() counted %d and wrote %d deployments, want 10", status.Counts["Deployment.apps"], w.written)
+	}
+	// The third page was taken from the task before the last was listed,
+	// so the first two were written by then
+	if writtenBeforeLast < 4 {
+		t.Errorf("StreamSnapshot() wrote %d deployments before the last page was listed, want pages written as they are listed", writtenBeforeLast)
+	}
+}
@@ -0,0 +1,96 @@
+package meshsync
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Snapshot compression algorithms
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Magic numbers that start compressed streams
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ParseCompression validates a compression name, accepting "none" for no compression
+func ParseCompression(compression string) (string, error) {
+	switch compression {
+	case CompressionNone, "none":
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return compression, nil
+	default:
+		return "", fmt.Errorf("unsupported compression %q (supported: gzip, zstd, none)", compression)
+	}
+}
+
+// CompressionFromPath picks the compression for a file from its extension,
+// such as .yaml.gz or .json.zst, ignoring EncryptionExtension
+func CompressionFromPath(filePath string) string {
+	filePath = strings.TrimSuffix(trimQuery(filePath), EncryptionExtension)
+	switch {
+	case strings.HasSuffix(filePath, ".gz"), strings.HasSuffix(filePath, ".gzip"):
+		return CompressionGzip
+	case strings.HasSuffix(filePath, ".zst"), strings.HasSuffix(filePath, ".zstd"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// NewCompressor returns a writer that compresses to w. Closing it completes
+// the compressed stream but does not close w.
+func NewCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// NewDecompressor returns a reader that decompresses r if it starts with a
+// gzip or zstd header, and reads it as is otherwise
+func NewDecompressor(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	header, _ := buf.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		reader, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip snapshot: %w", err)
+		}
+		return reader, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		decoder, err := zstd.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd snapshot: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buf), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,75 @@
+package meshsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressionFromPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "snapshot.yaml", expected: CompressionNone},
+		{path: "snapshot.yaml.gz", expected: CompressionGzip},
+		{path: "snapshot.json.zst", expected: CompressionZstd},
+		{path: "snapshot.ndjson.zstd", expected: CompressionZstd},
+		{path: "gz/snapshot.json", expected: CompressionNone},
+		{path: "snapshot.yaml.gz.age", expected: CompressionGzip},
+		{path: "snapshot.yaml.age", expected: CompressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if compression := CompressionFromPath(tt.path); compression != tt.expected {
+				t.Errorf("CompressionFromPath(%s) = %q, want %q", tt.path, compression, tt.expected)
+			}
+		})
+	}
+
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Errorf("ParseCompression(lz4) error = nil, want unsupported compression")
+	}
+}
+
+func TestCompressedSnapshotFile(t *testing.T) {
+	tests := []struct {
+		file        string
+		format      string
+		compression string
+		magic       []byte
+	}{
+		{file: "snapshot.yaml", format: FormatYAML, compression: CompressionNone, magic: []byte("apiVersion")},
+		{file: "snapshot.yaml.gz", format: FormatYAML, compression: CompressionGzip, magic: gzipMagic},
+		{file: "snapshot.json.zst", format: FormatJSON, compression: CompressionZstd, magic: zstdMagic},
+		{file: "snapshot.ndjson.gz", format: FormatNDJSON, compression: CompressionGzip, magic: gzipMagic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := SaveSnapshot(newTestSnapshot(50, &SnapshotStatus{Duration: "1s"}), path, SnapshotFileOptions{Format: tt.format, Compression: tt.compression}); err != nil {
+				t.Fatalf("SaveSnapshot() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read snapshot: %v", err)
+			}
+			if !bytes.HasPrefix(data, tt.magic) {
+				t.Errorf("Snapshot starts with %x, want %x", data[:4], tt.magic)
+			}
+
+			// Loading detects the compression from the content
+			snapshot, err := LoadSnapshot(path)
+			if err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if len(snapshot.Resources) != 50 || snapshot.Status == nil || snapshot.Status.Duration != "1s" {
+				t.Errorf("Loaded %d resources and status %+v, want 50 and duration 1s", len(snapshot.Resources), snapshot.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package meshsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DeltaType is the kind of change a delta records
+type DeltaType string
+
+// Delta types
+const (
+	DeltaAdd    DeltaType = "add"
+	DeltaUpdate DeltaType = "update"
+	DeltaDelete DeltaType = "delete"
+)
+
+// DeltaFileExtension is the extension of files holding deltas
+const DeltaFileExtension = ".deltas.ndjson"
+
+// Delta is a change to one resource observed while watching, written as one
+// NDJSON line. Object is the resource after the change, or its last known
+// state for a delete.
+type Delta struct {
+	Type   DeltaType `json:"type"`
+	Time   time.Time `json:"time"`
+	Object Resource  `json:"object"`
+}
+
+// DeltaPath returns the default path of the deltas recorded next to a
+// snapshot, such as snapshot.deltas.ndjson for snapshot.yaml.gz
+func DeltaPath(snapshotPath string) string {
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		snapshotPath = strings.TrimSuffix(snapshotPath, ext)
+	}
+	return strings.TrimSuffix(snapshotPath, filepath.Ext(snapshotPath)) + DeltaFileExtension
+}
+
+// DeltaFileOptions contains options for writing deltas
+type DeltaFileOptions struct {
+	// Overwrite truncates an existing file. Without it, creating a file at
+	// a path that exists fails with an error wrapping fs.ErrExist.
+	Overwrite bool
+	// Export removes fields from each delta's object, as for snapshots
+	Export ExportOptions
+}
+
+// DeltaFile appends deltas to a file as NDJSON. Each delta is written with
+// a single write, so a reader never sees a partial line unless the process
+// dies mid-write.
+type DeltaFile struct {
+	file *os.File
+	opts DeltaFileOptions
+	// Count is the number of deltas written
+	Count int
+}
+
+// CreateDeltaFile creates a file for deltas with mode 0600. A filePath of
+// StdioPath writes to stdout.
+func CreateDeltaFile(filePath string, opts DeltaFileOptions) (*DeltaFile, error) {
+	if filePath == StdioPath {
+		return &DeltaFile{file: os.Stdout, opts: opts}, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND | os.O_EXCL
+	if opts.Overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND | os.O_TRUNC
+	}
+	file, err := os.OpenFile(filePath, flags, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("delta file %s: %w", filePath, fs.ErrExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create delta file: %w", err)
+	}
+	return &DeltaFile{file: file, opts: opts}, nil
+}
+
+// Write appends a delta
+func (f *DeltaFile) Write(delta Delta) error {
+	if f.opts.Export.StripStatus || f.opts.Export.StripServerFields {
+		delta.Object = *StripResource(&delta.Object, f.opts.Export)
+	}
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delta to JSON: %w", err)
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write delta: %w", err)
+	}
+	f.Count++
+	return nil
+}
+
+// Close syncs the deltas to disk and closes the file
+func (f *DeltaFile) Close() error {
+	if f.file == os.Stdout {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("failed to write delta file: %w", err)
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to write delta file: %w", err)
+	}
+	return nil
+}
+
+// LoadDeltas reads an NDJSON delta file. A filePath of StdioPath reads from
+// stdin. A truncated last line, left by a watch that was killed, is ignored.
+func LoadDeltas(filePath string) ([]Delta, error) {
+	if filePath == StdioPath {
+		deltas, err := ReadDeltas(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load deltas from stdin: %w", err)
+		}
+		return deltas, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delta file: %w", err)
+	}
+	defer file.Close()
+
+	deltas, err := ReadDeltas(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deltas %s: %w", filePath, err)
+	}
+	return deltas, nil
+}
+
+// ReadDeltas reads NDJSON deltas from r
+func ReadDeltas(r io.Reader) ([]Delta, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deltas: %w", err)
+	}
+
+	var deltas []Delta
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var delta Delta
+		if err := json.Unmarshal(line, &delta); err != nil {
+			if i == len(lines)-1 {
+				// Only the last line can be cut short, since it has no newline
+				break
+			}
+			return nil, fmt.Errorf("invalid delta on line %d: %w", i+1, err)
+		}
+		switch delta.Type {
+		case DeltaAdd, DeltaUpdate, DeltaDelete:
+		default:
+			return nil, fmt.Errorf("invalid delta on line %d: unknown type %q", i+1, delta.Type)
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, nil
+}
+
+// resourceKey identifies a resource across versions of its API group
+type resourceKey struct {
+	groupKind schema.GroupKind
+	namespace string
+	name      string
+}
+
+func keyOf(resource Resource) resourceKey {
+	return resourceKey{
+		groupKind: resource.GroupVersionKind().GroupKind(),
+		namespace: resource.Namespace(),
+		name:      resource.Name(),
+	}
+}
+
+// CompactSnapshot folds the deltas recorded up to and including at into the
+// base snapshot and returns the state of the cluster at that time as a new
+// snapshot. A zero at folds in every delta. Deltas are applied in the order
+// given, which is the order they were recorded in. The base is not modified.
+func CompactSnapshot(base *Snapshot, deltas []Delta, at time.Time) (*Snapshot, error) {
+	if !at.IsZero() && base.Metadata.Timestamp != "" {
+		if captured, err := time.Parse(time.RFC3339, base.Metadata.Timestamp); err == nil && at.Before(captured) {
+			return nil, fmt.Errorf("cannot compact to %s, before the base snapshot was captured at %s", at.Format(time.RFC3339), base.Metadata.Timestamp)
+		}
+	}
+
+	resources := make(map[resourceKey]Resource, len(base.Resources))
+	for _, resource := range base.Resources {
+		resources[keyOf(resource)] = resource
+	}
+
+	timestamp := base.Metadata.Timestamp
+	for _, delta := range deltas {
+		if !at.IsZero() && delta.Time.After(at) {
+			continue
+		}
+		switch delta.Type {
+		case DeltaAdd, DeltaUpdate:
+			resources[keyOf(delta.Object)] = delta.Object
+		case DeltaDelete:
+			delete(resources, keyOf(delta.Object))
+		}
+		timestamp = delta.Time.UTC().Format(time.RFC3339)
+	}
+	if !at.IsZero() {
+		timestamp = at.UTC().Format(time.RFC3339)
+	}
+
+	compacted := &Snapshot{
+		APIVersion: base.APIVersion,
+		Kind:       base.Kind,
+		Metadata:   base.Metadata,
+		Resources:  make([]Resource, 0, len(resources)),
+	}
+	compacted.Metadata.Timestamp = timestamp
+	for _, resource := range resources {
+		compacted.Resources = append(compacted.Resources, resource)
+	}
+	sortResources(compacted.Resources)
+
+	// Counts are recomputed; errors and relists describe the base capture
+	// and are carried over
+	status := &SnapshotStatus{Counts: map[string]int{}}
+	if base.Status != nil {
+		*status = *base.Status
+		status.Counts = map[string]int{}
+		for key := range base.Status.Counts {
+			status.Counts[key] = 0
+		}
+		status.Duration = ""
+	}
+	for _, resource := range compacted.Resources {
+		status.Counts[resource.GroupVersionKind().GroupKind().String()]++
+	}
+	compacted.Status = status
+	return compacted, nil
+}
+
+// sortResources sorts resources the way captures write them: by group,
+// kind, namespace and name
+func sortResources(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := keyOf(resources[i]), keyOf(resources[j])
+		if a.groupKind.Group != b.groupKind.Group {
+			return a.groupKind.Group < b.groupKind.Group
+		}
+		if a.groupKind.Kind != b.groupKind.Kind {
+			return a.groupKind.Kind < b.groupKind.Kind
+		}
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		return a.name < b.name
+	})
+}
@@ -0,0 +1,182 @@
+package meshsync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDeltaResource returns a ConfigMap with the given name and data value
+func newDeltaResource(name, value string) Resource {
+	return NewResource(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"data":       map[string]interface{}{"value": value},
+	})
+}
+
+func TestDeltaPath(t *testing.T) {
+	tests := map[string]string{
+		"meshsync-snapshot.yaml":        "meshsync-snapshot.deltas.ndjson",
+		"out/prod.json.zst":             "out/prod.deltas.ndjson",
+		"prod-20260101T000000Z.yaml.gz": "prod-20260101T000000Z.deltas.ndjson",
+		"snapshot":                      "snapshot.deltas.ndjson",
+	}
+	for path, want := range tests {
+		if got := DeltaPath(path); got != want {
+			t.Errorf("DeltaPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestDeltaFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.deltas.ndjson")
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	file, err := CreateDeltaFile(path, DeltaFileOptions{Export: ExportOptions{StripStatus: true}})
+	if err != nil {
+		t.Fatalf("CreateDeltaFile() error = %v", err)
+	}
+	object := newDeltaResource("web", "1")
+	object.Object["status"] = map[string]interface{}{"phase": "Active"}
+	want := []Delta{
+		{Type: DeltaAdd, Time: start, Object: newDeltaResource("web", "1")},
+		{Type: DeltaDelete, Time: start.Add(time.Second), Object: newDeltaResource("web", "1")},
+	}
+	for _, delta := range []Delta{{Type: DeltaAdd, Time: start, Object: object}, want[1]} {
+		if err := file.Write(delta); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if _, err := CreateDeltaFile(path, DeltaFileOptions{}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("CreateDeltaFile() on an existing file error = %v, want fs.ErrExist", err)
+	}
+
+	// A watch killed mid-write leaves a partial last line
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"add","ti`)
+	f.Close()
+
+	deltas, err := LoadDeltas(path)
+	if err != nil {
+		t.Fatalf("LoadDeltas() error = %v", err)
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Errorf("LoadDeltas() = %+v, want %+v", deltas, want)
+	}
+
+	if _, err := ReadDeltas(strings.NewReader("{\"type\":\"rename\"}\n")); err == nil {
+		t.Error("ReadDeltas() expected error for an unknown delta type")
+	}
+}
+
+func TestCompactSnapshot(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	base := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   SnapshotMetadata{Name: "prod", Timestamp: start.Format(time.RFC3339)},
+		Resources:  []Resource{newDeltaResource("api", "1"), newDeltaResource("web", "1")},
+		Status:     &SnapshotStatus{Counts: map[string]int{"ConfigMap": 2, "Deployment.apps": 0}, Duration: "2s"},
+	}
+	deltas := []Delta{
+		{Type: DeltaUpdate, Time: start.Add(1 * time.Minute), Object: newDeltaResource("web", "2")},
+		{Type: DeltaAdd, Time: start.Add(2 * time.Minute), Object: newDeltaResource("batch", "1")},
+		{Type: DeltaDelete, Time: start.Add(3 * time.Minute), Object: newDeltaResource("batch", "1")},
+		{Type: DeltaDelete, Time: start.Add(4 * time.Minute), Object: newDeltaResource("api", "1")},
+	}
+
+	tests := []struct {
+		name          string
+		at            time.Time
+		want          map[string]string
+		wantTimestamp string
+		wantErr       bool
+	}{
+		{
+			name:          "All deltas",
+			want:          map[string]string{"web": "2"},
+			wantTimestamp: "2026-01-01T12:04:00Z",
+		},
+		{
+			name:          "Before the first delta",
+			at:            start.Add(30 * time.Second),
+			want:          map[string]string{"api": "1", "web": "1"},
+			wantTimestamp: "2026-01-01T12:00:30Z",
+		},
+		{
+			name:          "Short-lived resource",
+			at:            start.Add(2 * time.Minute),
+			want:          map[string]string{"api": "1", "batch": "1", "web": "2"},
+			wantTimestamp: "2026-01-01T12:02:00Z",
+		},
+		{
+			name:    "Before the base",
+			at:      start.Add(-time.Minute),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compacted, err := CompactSnapshot(base, deltas, tt.at)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("CompactSnapshot() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompactSnapshot() error = %v", err)
+			}
+
+			got := map[string]string{}
+			var names []string
+			for _, resource := range compacted.Resources {
+				value, _ := resource.Field("data", "value")
+				got[resource.Name()] = value.(string)
+				names = append(names, resource.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompactSnapshot() resources = %v, want %v", got, tt.want)
+			}
+			if !sortedStrings(names) {
+				t.Errorf("CompactSnapshot() resources not sorted: %v", names)
+			}
+			if compacted.Metadata.Timestamp != tt.wantTimestamp {
+				t.Errorf("Timestamp = %s, want %s", compacted.Metadata.Timestamp, tt.wantTimestamp)
+			}
+			wantCounts := map[string]int{"ConfigMap": len(tt.want), "Deployment.apps": 0}
+			if !reflect.DeepEqual(compacted.Status.Counts, wantCounts) {
+				t.Errorf("Counts = %v, want %v", compacted.Status.Counts, wantCounts)
+			}
+		})
+	}
+
+	if len(base.Resources) != 2 || base.Status.Counts["ConfigMap"] != 2 {
+		t.Error("CompactSnapshot() modified the base snapshot")
+	}
+}
+
+// sortedStrings reports whether s is in ascending order
+func sortedStrings(s []string) bool {
+	for i := 1; i < len(s); i++ {
+		if s[i-1] > s[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package meshsync
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// EncryptionExtension ends the names of encrypted snapshot files, as in
+// snapshot.yaml.gz.age
+const EncryptionExtension = ".age"
+
+// ageMagic starts binary age files; armored ones start with armor.Header
+var ageMagic = []byte("age-encryption.org/")
+
+// ErrNoIdentity is wrapped by errors reading an encrypted snapshot without
+// an identity that can decrypt it
+var ErrNoIdentity = errors.New("no identity to decrypt the snapshot")
+
+// identities decrypt encrypted snapshots as they are read
+var (
+	identitiesMu sync.RWMutex
+	identities   []age.Identity
+)
+
+// AddIdentities registers identities that LoadSnapshot and ReadSnapshot
+// decrypt encrypted snapshots with. Identities are tried in the order they
+// were added.
+func AddIdentities(ids ...age.Identity) {
+	identitiesMu.Lock()
+	defer identitiesMu.Unlock()
+	identities = append(identities, ids...)
+}
+
+// ParseRecipients parses age X25519 recipients, each given as an age1...
+// public key or as a file listing them one per line
+func ParseRecipients(values []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, value := range values {
+		if strings.HasPrefix(value, "age1") {
+			recipient, err := age.ParseX25519Recipient(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %s: %w", value, err)
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		file, err := os.Open(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients: %w", err)
+		}
+		parsed, err := age.ParseRecipients(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipients file %s: %w", value, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+	return recipients, nil
+}
+
+// LoadIdentities reads an age identity file, such as one written by
+// age-keygen
+func LoadIdentities(path string) ([]age.Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	defer file.Close()
+
+	ids, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity file %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// IsEncrypted reports whether data starts like an age encrypted file,
+// binary or armored
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, ageMagic) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// NewEncryptor returns a writer that encrypts to w for the recipients.
+// Closing it completes the encrypted stream but does not close w.
+func NewEncryptor(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	encryptor, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt snapshot: %w", err)
+	}
+	return encryptor, nil
+}
+
+// NewDecrypter returns a reader that decrypts r with the identities given
+// to AddIdentities if it is age encrypted, and reads it as is otherwise.
+// Decryption streams, so the cleartext is never written anywhere.
+func NewDecrypter(r io.Reader) (io.Reader, error) {
+	buf := bufio.NewReader(r)
+	header, _ := buf.Peek(len(armor.Header))
+	if !IsEncrypted(header) {
+		return buf, nil
+	}
+	var src io.Reader = buf
+	if bytes.HasPrefix(header, []byte(armor.Header)) {
+		src = armor.NewReader(buf)
+	}
+
+	identitiesMu.RLock()
+	ids := append([]age.Identity(nil), identities...)
+	identitiesMu.RUnlock()
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("snapshot is encrypted: %w", ErrNoIdentity)
+	}
+
+	reader, err := age.Decrypt(src, ids...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("snapshot is encrypted to other recipients, or with another passphrase: %w", ErrNoIdentity)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+	return reader, nil
+}
@@ -0,0 +1,203 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/oci"
+)
+
+// useIdentities replaces the identities snapshots are decrypted with for
+// the duration of a test
+func useIdentities(t *testing.T, ids ...age.Identity) {
+	t.Helper()
+	identitiesMu.Lock()
+	saved := identities
+	identities = nil
+	identitiesMu.Unlock()
+	AddIdentities(ids...)
+	t.Cleanup(func() {
+		identitiesMu.Lock()
+		identities = saved
+		identitiesMu.Unlock()
+	})
+}
+
+// testScryptRecipient returns a passphrase recipient with a low work
+// factor, so tests run fast
+func testScryptRecipient(t *testing.T, passphrase string) *age.ScryptRecipient {
+	t.Helper()
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(10)
+	return recipient
+}
+
+func TestEncryptedSnapshotFile(t *testing.T) {
+	alice, _ := age.GenerateX25519Identity()
+	bob, _ := age.GenerateX25519Identity()
+	mallory, _ := age.GenerateX25519Identity()
+	passphrase, _ := age.NewScryptIdentity("correct horse battery staple")
+
+	tests := []struct {
+		name       string
+		file       string
+		format     string
+		recipients []age.Recipient
+		identities []age.Identity
+		wantErr    error
+	}{
+		{name: "recipient", file: "snapshot.yaml.age", format: FormatYAML, recipients: []age.Recipient{alice.Recipient()}, identities: []age.Identity{alice}},
+		{name: "second recipient", file: "snapshot.yaml.gz.age", format: FormatYAML, recipients: []age.Recipient{alice.Recipient(), bob.Recipient()}, identities: []age.Identity{bob}},
+		{name: "passphrase", file: "snapshot.ndjson.zst.age", format: FormatNDJSON, recipients: []age.Recipient{testScryptRecipient(t, "correct horse battery staple")}, identities: []age.Identity{passphrase}},
+		{name: "no identity", file: "snapshot.yaml.age", format: FormatYAML, recipients: []age.Recipient{alice.Recipient()}, wantErr: ErrNoIdentity},
+		{name: "other identity", file: "snapshot.yaml.age", format: FormatYAML, recipients: []age.Recipient{alice.Recipient()}, identities: []age.Identity{mallory}, wantErr: ErrNoIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useIdentities(t, tt.identities...)
+			path := filepath.Join(t.TempDir(), tt.file)
+			snapshot := newTestSnapshot(3, &SnapshotStatus{Counts: map[string]int{"Deployment.apps": 3}})
+			opts := SnapshotFileOptions{Format: tt.format, Compression: CompressionFromPath(path), Recipients: tt.recipients}
+			if err := SaveSnapshot(snapshot, path, opts); err != nil {
+				t.Fatalf("SaveSnapshot() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(data) || bytes.Contains(data, []byte(snapshot.Metadata.Name)) {
+				t.Errorf("Snapshot file is not encrypted: %q", data[:32])
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != SnapshotFileMode {
+				t.Errorf("Snapshot file mode = %v, want %v", info.Mode().Perm(), SnapshotFileMode)
+			}
+
+			loaded, err := LoadSnapshot(path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("LoadSnapshot() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if len(loaded.Resources) != 3 || loaded.Status.Counts["Deployment.apps"] != 3 {
+				t.Errorf("LoadSnapshot() = %d resources, status %+v", len(loaded.Resources), loaded.Status)
+			}
+		})
+	}
+}
+
+func TestReadArmoredSnapshot(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	useIdentities(t, identity)
+
+	var cleartext bytes.Buffer
+	if err := WriteSnapshot(mustSnapshotWriter(t, &cleartext), newTestSnapshot(2, nil)); err != nil {
+		t.Fatal(err)
+	}
+	var armored bytes.Buffer
+	armorWriter := armor.NewWriter(&armored)
+	encryptor, err := NewEncryptor(armorWriter, []age.Recipient{identity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor.Write(cleartext.Bytes())
+	encryptor.Close()
+	armorWriter.Close()
+
+	snapshot, err := ReadSnapshot(&armored)
+	if err != nil {
+		t.Fatalf("ReadSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != 2 {
+		t.Errorf("ReadSnapshot() = %d resources, want 2", len(snapshot.Resources))
+	}
+}
+
+// mustSnapshotWriter returns a YAML SnapshotWriter to w
+func mustSnapshotWriter(t *testing.T, w *bytes.Buffer) SnapshotWriter {
+	t.Helper()
+	writer, err := NewSnapshotWriter(w, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writer
+}
+
+func TestParseRecipients(t *testing.T) {
+	alice, _ := age.GenerateX25519Identity()
+	bob, _ := age.GenerateX25519Identity()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "recipients.txt")
+	os.WriteFile(file, []byte("# the team\n"+bob.Recipient().String()+"\n"), 0600)
+
+	recipients, err := ParseRecipients([]string{alice.Recipient().String(), file})
+	if err != nil {
+		t.Fatalf("ParseRecipients() error = %v", err)
+	}
+	if len(recipients) != 2 {
+		t.Errorf("ParseRecipients() = %d recipients, want 2", len(recipients))
+	}
+
+	for _, value := range []string{"age1invalid", filepath.Join(dir, "missing.txt")} {
+		if _, err := ParseRecipients([]string{value}); err == nil {
+			t.Errorf("ParseRecipients(%s) error = nil, want an error", value)
+		}
+	}
+
+	identityFile := filepath.Join(dir, "key.txt")
+	os.WriteFile(identityFile, []byte(alice.String()+"\n"), 0600)
+	ids, err := LoadIdentities(identityFile)
+	if err != nil || len(ids) != 1 {
+		t.Errorf("LoadIdentities() = %d identities, %v, want 1", len(ids), err)
+	}
+	if _, err := LoadIdentities(file); err == nil {
+		t.Error("LoadIdentities() of a recipients file error = nil, want an error")
+	}
+}
+
+func TestPushEncryptedSnapshot(t *testing.T) {
+	_, host := newFakeRegistry(t)
+	ctx := context.Background()
+	identity, _ := age.GenerateX25519Identity()
+	useIdentities(t)
+
+	input := filepath.Join(t.TempDir(), "prod.yaml.gz.age")
+	opts := SnapshotFileOptions{Format: FormatYAML, Compression: CompressionGzip, Recipients: []age.Recipient{identity.Recipient()}}
+	if err := SaveSnapshot(newTestSnapshot(2, nil), input, opts); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	// Pushing needs no identity, and gives nothing away in annotations
+	ref, _ := oci.ParseReference("oci://" + host + "/snapshots/prod:v1")
+	client := newTestOCIClient()
+	if _, err := PushSnapshot(ctx, client, ref, input); err != nil {
+		t.Fatalf("PushSnapshot() error = %v", err)
+	}
+	artifact, err := FetchSnapshotArtifact(ctx, client, ref)
+	if err != nil {
+		t.Fatalf("FetchSnapshotArtifact() error = %v", err)
+	}
+	if artifact.Layer.MediaType != OCILayerMediaType+"+age" {
+		t.Errorf("Layer media type = %s, want +age", artifact.Layer.MediaType)
+	}
+	for key := range artifact.Manifest.Annotations {
+		if strings.HasPrefix(key, ociAnnotationPrefix) {
+			t.Errorf("Encrypted snapshot pushed with annotation %s", key)
+		}
+	}
+}
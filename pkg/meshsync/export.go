@@ -0,0 +1,120 @@
+package meshsync
+
+import (
+	"bufio"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// serverAnnotations are annotations set by the API server or kubectl that
+// do not belong in manifests
+var serverAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+// serverMetadataFields are metadata fields set by the API server. Owner
+// references are included since they refer to objects by UID, which is
+// only meaningful in the cluster the snapshot was captured from.
+var serverMetadataFields = []string{
+	"uid",
+	"resourceVersion",
+	"generation",
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"selfLink",
+	"managedFields",
+	"ownerReferences",
+}
+
+// ExportOptions controls which fields are removed from resources on export
+type ExportOptions struct {
+	// StripStatus removes the status of each resource
+	StripStatus bool
+	// StripServerFields removes metadata set by the API server, such as
+	// uid, resourceVersion and managedFields
+	StripServerFields bool
+}
+
+// StripResource returns a copy of the resource without the fields opts
+// removes. The resource itself is not modified.
+func StripResource(resource *Resource, opts ExportOptions) *Resource {
+	object := make(map[string]interface{}, len(resource.Object))
+	for key, value := range resource.Object {
+		object[key] = value
+	}
+	if opts.StripStatus {
+		delete(object, "status")
+	}
+	if metadata := resource.Metadata(); opts.StripServerFields && metadata != nil {
+		stripped := make(map[string]interface{}, len(metadata))
+		for key, value := range metadata {
+			stripped[key] = value
+		}
+		for _, field := range serverMetadataFields {
+			delete(stripped, field)
+		}
+
+		if annotations, ok := stripped["annotations"].(map[string]interface{}); ok {
+			kept := map[string]interface{}{}
+			for key, value := range annotations {
+				kept[key] = value
+			}
+			for _, annotation := range serverAnnotations {
+				delete(kept, annotation)
+			}
+			if len(kept) > 0 {
+				stripped["annotations"] = kept
+			} else {
+				delete(stripped, "annotations")
+			}
+		}
+		object["metadata"] = stripped
+	}
+	return &Resource{Object: object}
+}
+
+// stripWriter is a SnapshotWriter that strips resources before writing them
+type stripWriter struct {
+	SnapshotWriter
+	opts ExportOptions
+}
+
+func (s *stripWriter) WriteResource(resource *Resource) error {
+	return s.SnapshotWriter.WriteResource(StripResource(resource, s.opts))
+}
+
+// listWriter writes the resources of a snapshot as a YAML v1/List of
+// Kubernetes objects, which kubectl apply and other Kubernetes tools read.
+// The snapshot metadata and status are not part of the list.
+type listWriter struct {
+	w         *bufio.Writer
+	resources int
+}
+
+func (l *listWriter) WriteHeader(header *Snapshot) error {
+	_, err := l.w.WriteString("apiVersion: v1\nkind: List\nmetadata: {}\n")
+	return err
+}
+
+func (l *listWriter) WriteResource(resource *Resource) error {
+	data, err := yaml.Marshal([]*Resource{resource})
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource to YAML: %w", err)
+	}
+	if l.resources == 0 {
+		l.w.WriteString("items:\n")
+	}
+	l.resources++
+	_, err = l.w.Write(data)
+	return err
+}
+
+func (l *listWriter) Close(status *SnapshotStatus) error {
+	if l.resources == 0 {
+		l.w.WriteString("items: []\n")
+	}
+	return l.w.Flush()
+}
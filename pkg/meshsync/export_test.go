@@ -0,0 +1,107 @@
+package meshsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// newExportSnapshot returns a snapshot with a Deployment carrying server
+// fields and status, a ConfigMap and a Role
+func newExportSnapshot() *Snapshot {
+	return &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   SnapshotMetadata{Name: "export"},
+		Resources: []Resource{
+			NewResource(map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":            "web",
+					"namespace":       "default",
+					"uid":             "1234",
+					"resourceVersion": "42",
+					"managedFields":   []interface{}{map[string]interface{}{"manager": "kubectl"}},
+					"annotations": map[string]interface{}{
+						"kubectl.kubernetes.io/last-applied-configuration": "{}",
+						"team": "web",
+					},
+				},
+				"spec":   map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"readyReplicas": float64(2)},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "settings", "namespace": "default"},
+				"data":       map[string]interface{}{"mode": "fast"},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "Role",
+				"metadata":   map[string]interface{}{"name": "reader", "namespace": "default", "uid": "5678"},
+				"rules": []interface{}{
+					map[string]interface{}{"apiGroups": []interface{}{""}, "resources": []interface{}{"pods"}, "verbs": []interface{}{"get"}},
+				},
+			}),
+		},
+	}
+}
+
+func TestExportList(t *testing.T) {
+	snapshot := newExportSnapshot()
+	path := filepath.Join(t.TempDir(), "list.yaml")
+	err := SaveSnapshot(snapshot, path, SnapshotFileOptions{
+		Format: FormatList,
+		Export: ExportOptions{StripStatus: true, StripServerFields: true},
+	})
+	if err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read list: %v", err)
+	}
+	var list struct {
+		APIVersion string                   `json:"apiVersion"`
+		Kind       string                   `json:"kind"`
+		Items      []map[string]interface{} `json:"items"`
+	}
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		t.Fatalf("List is invalid YAML: %v\n%s", err, data)
+	}
+	if list.APIVersion != "v1" || list.Kind != "List" || len(list.Items) != 3 {
+		t.Fatalf("List = %s/%s with %d items, want a v1/List with 3 items\n%s", list.APIVersion, list.Kind, len(list.Items), data)
+	}
+
+	deployment := list.Items[0]
+	if _, ok := deployment["status"]; ok {
+		t.Errorf("Deployment status = %v, want it stripped", deployment["status"])
+	}
+	expectedMetadata := map[string]interface{}{
+		"name":        "web",
+		"namespace":   "default",
+		"annotations": map[string]interface{}{"team": "web"},
+	}
+	if !reflect.DeepEqual(deployment["metadata"], expectedMetadata) {
+		t.Errorf("Deployment metadata = %v, want %v", deployment["metadata"], expectedMetadata)
+	}
+
+	// Top-level fields other than spec and status are kept
+	if data, _ := list.Items[1]["data"].(map[string]interface{}); data["mode"] != "fast" {
+		t.Errorf("ConfigMap data = %v, want mode=fast", list.Items[1]["data"])
+	}
+	if rules, _ := list.Items[2]["rules"].([]interface{}); len(rules) != 1 {
+		t.Errorf("Role rules = %v, want one rule", list.Items[2]["rules"])
+	}
+
+	// The snapshot itself is not modified
+	if original := newExportSnapshot(); !reflect.DeepEqual(snapshot.Resources, original.Resources) {
+		t.Errorf("Export modified the snapshot resources")
+	}
+}
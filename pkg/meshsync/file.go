@@ -0,0 +1,338 @@
+package meshsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+// StdioPath is the snapshot path that stands for stdout when writing and
+// stdin when reading
+const StdioPath = "-"
+
+// SnapshotFileMode is the mode snapshot files are created with. Snapshots
+// can contain secrets, so they are readable by the owner only.
+const SnapshotFileMode fs.FileMode = 0600
+
+// Placeholders filled in by ExpandSnapshotPath
+const (
+	TimestampPlaceholder = "{timestamp}"
+	ClusterPlaceholder   = "{cluster}"
+)
+
+// SnapshotTimestampFormat formats {timestamp} in UTC, so that paths sort in
+// the order the snapshots were taken and contain no colons
+const SnapshotTimestampFormat = "20060102T150405Z"
+
+// ExpandSnapshotPath fills in the {timestamp} and {cluster} placeholders of
+// a snapshot path, such as snapshots/{cluster}-{timestamp}.yaml.gz
+func ExpandSnapshotPath(path string, timestamp time.Time, cluster string) string {
+	return strings.NewReplacer(
+		TimestampPlaceholder, timestamp.UTC().Format(SnapshotTimestampFormat),
+		ClusterPlaceholder, cluster,
+	).Replace(path)
+}
+
+// PruneSnapshots removes all but the newest keep snapshots written to a path
+// containing {timestamp}, for the given cluster, and returns the removed
+// paths. Paths without {timestamp} only ever hold one snapshot and are left
+// alone, as are sinks other than SinkPruners, whose retention is managed by
+// the storage itself.
+func PruneSnapshots(path string, cluster string, keep int) ([]string, error) {
+	if path == StdioPath || !strings.Contains(path, TimestampPlaceholder) {
+		return nil, nil
+	}
+
+	pattern := strings.NewReplacer(
+		TimestampPlaceholder, "*",
+		ClusterPlaceholder, cluster,
+	).Replace(path)
+	if IsSinkURL(path) {
+		return pruneSink(pattern, keep)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if len(matches) <= keep {
+		return nil, nil
+	}
+
+	// Timestamps sort lexically, so the oldest snapshots come first
+	sort.Strings(matches)
+	var removed []string
+	for _, match := range matches[:len(matches)-keep] {
+		if err := os.Remove(match); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+		removed = append(removed, match)
+	}
+	return removed, nil
+}
+
+// pruneSink removes old snapshots from a sink that supports it
+func pruneSink(pattern string, keep int) ([]string, error) {
+	if !SinkPrunes(pattern) {
+		return nil, nil
+	}
+	sink, err := OpenSink(pattern)
+	if err != nil {
+		return nil, err
+	}
+	pruner, ok := sink.(SinkPruner)
+	if !ok {
+		return nil, nil
+	}
+	return pruner.Prune(context.Background(), keep)
+}
+
+// SnapshotFileOptions contains options for writing a snapshot file
+type SnapshotFileOptions struct {
+	Format      string
+	Compression string
+	// Overwrite replaces an existing file. Without it, writing to a path
+	// that exists fails with an error wrapping fs.ErrExist.
+	Overwrite bool
+	// Export removes fields from the resources as they are written
+	Export ExportOptions
+	// Recipients encrypt the snapshot with age as it is written, so only
+	// ciphertext reaches the temporary file, the target or the sink
+	Recipients []age.Recipient
+}
+
+// SnapshotFile is a SnapshotWriter that writes to a file, a sink or stdout.
+// The snapshot is written to a temporary file next to the target and
+// renamed into place once complete, so the target never holds a partial
+// snapshot. For a sink, the temporary file is uploaded once complete.
+type SnapshotFile struct {
+	SnapshotWriter
+	compressor io.WriteCloser
+	encryptor  io.WriteCloser
+	file       *os.File
+	path       string
+	overwrite  bool
+	sink       Sink
+}
+
+// CreateSnapshotFile returns a SnapshotWriter that encodes to the file at
+// filePath in the given format and compression. A filePath of StdioPath
+// writes to stdout, and a sink URL such as s3://bucket/key to the sink.
+func CreateSnapshotFile(filePath string, opts SnapshotFileOptions) (*SnapshotFile, error) {
+	f := &SnapshotFile{file: os.Stdout, path: filePath, overwrite: opts.Overwrite}
+
+	if IsSinkURL(filePath) {
+		sink, err := OpenSink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		// Fail before capturing anything if the target is already taken
+		if !opts.Overwrite {
+			exists, err := sink.Exists(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				return nil, fmt.Errorf("snapshot %s: %w", filePath, fs.ErrExist)
+			}
+		}
+		if f.file, err = createTempFile("", ".meshsync-snapshot.tmp-*"); err != nil {
+			return nil, err
+		}
+		f.sink = sink
+	} else if filePath != StdioPath {
+		// Fail before capturing anything if the target is already taken
+		if !opts.Overwrite {
+			if err := checkNotExist(filePath); err != nil {
+				return nil, err
+			}
+		}
+
+		file, err := createTempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+		if err != nil {
+			return nil, err
+		}
+		f.file = file
+	}
+
+	// Compress before encrypting, since ciphertext does not compress
+	var w io.Writer = f.file
+	if len(opts.Recipients) > 0 {
+		encryptor, err := NewEncryptor(f.file, opts.Recipients)
+		if err != nil {
+			f.Abort()
+			return nil, err
+		}
+		f.encryptor, w = encryptor, encryptor
+	}
+	compressor, err := NewCompressor(w, opts.Compression)
+	if err != nil {
+		f.Abort()
+		return nil, err
+	}
+	f.compressor = compressor
+	f.SnapshotWriter, err = NewSnapshotWriter(compressor, opts.Format)
+	if err != nil {
+		f.Abort()
+		return nil, err
+	}
+	if opts.Export.StripStatus || opts.Export.StripServerFields {
+		f.SnapshotWriter = &stripWriter{SnapshotWriter: f.SnapshotWriter, opts: opts.Export}
+	}
+	return f, nil
+}
+
+// Close completes the snapshot, syncs it to disk and moves it into place
+func (f *SnapshotFile) Close(status *SnapshotStatus) error {
+	if err := f.SnapshotWriter.Close(status); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	if err := f.compressor.Close(); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	if f.encryptor != nil {
+		if err := f.encryptor.Close(); err != nil {
+			f.Abort()
+			return fmt.Errorf("failed to write snapshot to file: %w", err)
+		}
+	}
+	return f.finish()
+}
+
+// finish moves the complete snapshot into place, or uploads it to the sink
+func (f *SnapshotFile) finish() error {
+	if f.file == os.Stdout {
+		return nil
+	}
+	if f.sink != nil {
+		return f.upload()
+	}
+
+	if err := f.file.Sync(); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.file.Name())
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	if err := f.commit(); err != nil {
+		os.Remove(f.file.Name())
+		return err
+	}
+
+	// Sync the directory so the rename survives a crash. Not every
+	// platform can sync a directory, so this is best effort.
+	if dir, err := os.Open(filepath.Dir(f.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// SaveSnapshotData writes a snapshot already encoded, as read from r, to
+// filePath as is. Like CreateSnapshotFile, it writes to stdout for
+// StdioPath and to a sink for a sink URL, and never leaves a partial file.
+func SaveSnapshotData(r io.Reader, filePath string, overwrite bool) error {
+	f, err := CreateSnapshotFile(filePath, SnapshotFileOptions{Format: FormatYAML, Overwrite: overwrite})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f.file, r); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	return f.finish()
+}
+
+// upload stores the temporary file in the sink and removes it. The upload
+// is not cancelled with the capture, so an interrupted capture still
+// stores what was captured.
+func (f *SnapshotFile) upload() error {
+	defer os.Remove(f.file.Name())
+	defer f.file.Close()
+
+	size, err := f.file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+	return f.sink.Put(context.Background(), f.file, size, f.overwrite)
+}
+
+// commit moves the temporary file to the target path
+func (f *SnapshotFile) commit() error {
+	if f.overwrite {
+		if err := os.Rename(f.file.Name(), f.path); err != nil {
+			return fmt.Errorf("failed to save snapshot file: %w", err)
+		}
+		return nil
+	}
+
+	// A hard link fails if the target was created while capturing, where
+	// a rename would replace it
+	err := os.Link(f.file.Name(), f.path)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("snapshot file %s: %w", f.path, fs.ErrExist)
+	}
+	if err != nil {
+		// Some filesystems do not support hard links
+		if err := checkNotExist(f.path); err != nil {
+			return err
+		}
+		if err := os.Rename(f.file.Name(), f.path); err != nil {
+			return fmt.Errorf("failed to save snapshot file: %w", err)
+		}
+		return nil
+	}
+	os.Remove(f.file.Name())
+	return nil
+}
+
+// Abort discards the snapshot, leaving any existing file at the target
+// untouched. Anything already written to stdout cannot be taken back.
+func (f *SnapshotFile) Abort() {
+	if f.compressor != nil {
+		f.compressor.Close()
+	}
+	if f.file != os.Stdout {
+		f.file.Close()
+		os.Remove(f.file.Name())
+	}
+}
+
+// createTempFile creates a temporary file readable by the owner only
+func createTempFile(dir, pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	if err := file.Chmod(SnapshotFileMode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	return file, nil
+}
+
+// checkNotExist returns an error wrapping fs.ErrExist if filePath exists
+func checkNotExist(filePath string) error {
+	if _, err := os.Lstat(filePath); err == nil {
+		return fmt.Errorf("snapshot file %s: %w", filePath, fs.ErrExist)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	return nil
+}
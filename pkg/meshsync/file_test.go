@@ -0,0 +1,143 @@
+package meshsync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snapshot.yaml")
+
+	if err := SaveSnapshot(newTestSnapshot(1, nil), path, SnapshotFileOptions{Format: FormatYAML}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat snapshot: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != SnapshotFileMode {
+		t.Errorf("Snapshot mode = %v, want %v", mode, SnapshotFileMode)
+	}
+
+	// An existing snapshot is only replaced with Overwrite
+	err = SaveSnapshot(newTestSnapshot(2, nil), path, SnapshotFileOptions{Format: FormatYAML})
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveSnapshot() error = %v, want %v", err, fs.ErrExist)
+	}
+	if err := SaveSnapshot(newTestSnapshot(3, nil), path, SnapshotFileOptions{Format: FormatYAML, Overwrite: true}); err != nil {
+		t.Fatalf("SaveSnapshot() with Overwrite error = %v", err)
+	}
+	if snapshot, err := LoadSnapshot(path); err != nil || len(snapshot.Resources) != 3 {
+		t.Errorf("LoadSnapshot() = %v, %v, want the overwritten snapshot", snapshot, err)
+	}
+
+	// No temporary files are left behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Directory has %d entries, want only the snapshot", len(entries))
+	}
+}
+
+func TestSnapshotFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snapshot.json")
+	if err := os.WriteFile(path, []byte("previous"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	file, err := CreateSnapshotFile(path, SnapshotFileOptions{Format: FormatJSON, Overwrite: true})
+	if err != nil {
+		t.Fatalf("CreateSnapshotFile() error = %v", err)
+	}
+	snapshot := newTestSnapshot(1, nil)
+	file.WriteHeader(snapshot)
+	file.WriteResource(&snapshot.Resources[0])
+
+	// Until the snapshot is complete the previous file is untouched
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("Snapshot file = %q while writing, want the previous content", data)
+	}
+	file.Abort()
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("Snapshot file = %q after Abort, want the previous content", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Directory has %d entries after Abort, want only the previous file", len(entries))
+	}
+
+	// A file created at the target while writing is not replaced
+	file, err = CreateSnapshotFile(filepath.Join(dir, "new.json"), SnapshotFileOptions{Format: FormatJSON})
+	if err != nil {
+		t.Fatalf("CreateSnapshotFile() error = %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "new.json"), []byte("raced"), 0600)
+	if err := WriteSnapshot(file, snapshot); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("WriteSnapshot() error = %v, want %v", err, fs.ErrExist)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "new.json")); string(data) != "raced" {
+		t.Errorf("Snapshot file = %q, want the file created while writing", data)
+	}
+}
+
+func TestExpandSnapshotPath(t *testing.T) {
+	timestamp := time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "snapshot.yaml", expected: "snapshot.yaml"},
+		{path: "/snapshots/{cluster}-{timestamp}.yaml.gz", expected: "/snapshots/abc-20260304T040607Z.yaml.gz"},
+		{path: "{cluster}/{timestamp}/{timestamp}.json", expected: "abc/20260304T040607Z/20260304T040607Z.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := ExpandSnapshotPath(tt.path, timestamp, "abc"); got != tt.expected {
+				t.Errorf("ExpandSnapshotPath(%q) = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	template := filepath.Join(dir, "{cluster}-{timestamp}.yaml")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := ExpandSnapshotPath(template, start.Add(time.Duration(i)*time.Hour), "prod")
+		paths = append(paths, path)
+		if err := os.WriteFile(path, nil, SnapshotFileMode); err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+	}
+	other := ExpandSnapshotPath(template, start, "staging")
+	if err := os.WriteFile(other, nil, SnapshotFileMode); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	removed, err := PruneSnapshots(template, "prod", 2)
+	if err != nil {
+		t.Fatalf("PruneSnapshots() error = %v", err)
+	}
+	if !reflect.DeepEqual(removed, paths[:2]) {
+		t.Errorf("PruneSnapshots() removed %v, want the oldest two %v", removed, paths[:2])
+	}
+	for i, path := range append(paths, other) {
+		_, err := os.Stat(path)
+		if exists := err == nil; exists != (i >= 2) {
+			t.Errorf("%s exists = %v, want %v", path, exists, i >= 2)
+		}
+	}
+
+	if removed, err := PruneSnapshots(filepath.Join(dir, "fixed.yaml"), "prod", 0); err != nil || removed != nil {
+		t.Errorf("PruneSnapshots() without {timestamp} = %v, %v, want nothing removed", removed, err)
+	}
+}
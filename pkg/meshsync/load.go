@@ -0,0 +1,280 @@
+package meshsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// rawSnapshot is a snapshot whose metadata has not been decoded yet, since
+// its layout depends on the apiVersion
+type rawSnapshot struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   json.RawMessage `json:"metadata"`
+	Resources  []Resource      `json:"resources"`
+	Status     *SnapshotStatus `json:"status,omitempty"`
+}
+
+// LoadSnapshot reads a snapshot file written in any supported format,
+// compression and apiVersion, upgrading it to SnapshotAPIVersion. Encrypted
+// snapshots are decrypted with the identities given to AddIdentities. A filePath
+// of StdioPath reads from stdin, and a sink URL such as s3://bucket/key
+// from the sink.
+func LoadSnapshot(filePath string) (*Snapshot, error) {
+	if filePath == StdioPath {
+		snapshot, err := ReadSnapshot(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot from stdin: %w", err)
+		}
+		return snapshot, nil
+	}
+	if IsSinkURL(filePath) {
+		return loadSinkSnapshot(filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	defer file.Close()
+
+	snapshot, err := ReadSnapshot(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %s: %w", filePath, err)
+	}
+	return snapshot, nil
+}
+
+// OpenSnapshotData opens a snapshot file, stdin for StdioPath or a sink
+// URL, for reading it as stored, without decoding or decompressing it. The
+// caller closes the reader.
+func OpenSnapshotData(filePath string) (io.ReadCloser, error) {
+	if filePath == StdioPath {
+		return io.NopCloser(os.Stdin), nil
+	}
+	if IsSinkURL(filePath) {
+		sink, err := OpenSink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return sink.Open(context.Background())
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	return file, nil
+}
+
+// loadSinkSnapshot reads a snapshot from a sink
+func loadSinkSnapshot(location string) (*Snapshot, error) {
+	sink, err := OpenSink(location)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := sink.Open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	snapshot, err := ReadSnapshot(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %s: %w", location, err)
+	}
+	return snapshot, nil
+}
+
+// ReadSnapshot reads a snapshot from r, decrypting and decompressing it if
+// needed
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	decrypted, err := NewDecrypter(r)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewDecompressor(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return DecodeSnapshot(data)
+}
+
+// DecodeSnapshot decodes a YAML, JSON or NDJSON snapshot, upgrading it to
+// SnapshotAPIVersion
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var raw *rawSnapshot
+	var err error
+
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte(yamlStreamHeaderPrefix)) {
+		raw, err = decodeYAMLStreamSnapshot(trimmed)
+	} else if len(trimmed) > 0 && trimmed[0] == '{' {
+		// A JSON document, or an NDJSON stream if there is more than one value
+		raw, err = decodeJSONSnapshot(trimmed)
+	} else {
+		// YAML is converted to JSON so that nested objects decode to
+		// map[string]interface{} like they do from JSON
+		var jsonData []byte
+		jsonData, err = yaml.YAMLToJSON(data)
+		if err == nil {
+			raw = &rawSnapshot{}
+			err = json.Unmarshal(jsonData, raw)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	return upgradeSnapshot(raw)
+}
+
+// decodeJSONSnapshot decodes a single JSON document or an NDJSON stream of a
+// header line, one line per resource and an optional status line
+func decodeJSONSnapshot(data []byte) (*rawSnapshot, error) {
+	raw := &rawSnapshot{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(raw); err != nil {
+		return nil, err
+	}
+	if !decoder.More() {
+		return raw, nil
+	}
+
+	raw.Resources = []Resource{}
+	scanner := bufio.NewScanner(bytes.NewReader(data[decoder.InputOffset():]))
+	scanner.Buffer(make([]byte, 64*1024), len(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, err
+		}
+		if _, ok := fields["status"]; ok && len(fields) == 1 {
+			if err := json.Unmarshal(fields["status"], &raw.Status); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var resource Resource
+		if err := json.Unmarshal(line, &resource); err != nil {
+			return nil, err
+		}
+		raw.Resources = append(raw.Resources, resource)
+	}
+	return raw, scanner.Err()
+}
+
+// decodeYAMLStreamSnapshot decodes a yaml-stream snapshot: a header comment,
+// one YAML document per resource and an optional status comment
+func decodeYAMLStreamSnapshot(data []byte) (*rawSnapshot, error) {
+	raw := &rawSnapshot{Resources: []Resource{}}
+
+	// The header and status are comments at the start of a line, where no
+	// value inside a resource can begin
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if header, ok := bytes.CutPrefix(line, []byte(yamlStreamHeaderPrefix)); ok {
+			if err := json.Unmarshal(header, raw); err != nil {
+				return nil, err
+			}
+		} else if status, ok := bytes.CutPrefix(line, []byte(yamlStreamStatusPrefix)); ok {
+			if err := json.Unmarshal(status, &raw.Status); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		jsonData, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(jsonData, []byte("null")) {
+			// A document holding only comments
+			continue
+		}
+
+		var resource Resource
+		if err := json.Unmarshal(jsonData, &resource); err != nil {
+			return nil, err
+		}
+		raw.Resources = append(raw.Resources, resource)
+	}
+	return raw, nil
+}
+
+// upgradeSnapshot decodes the metadata for the snapshot's apiVersion and
+// converts the snapshot to SnapshotAPIVersion
+func upgradeSnapshot(raw *rawSnapshot) (*Snapshot, error) {
+	if raw.Kind != SnapshotKind {
+		return nil, fmt.Errorf("unexpected kind %q, want %s", raw.Kind, SnapshotKind)
+	}
+	if raw.Resources == nil {
+		raw.Resources = []Resource{}
+	}
+
+	switch raw.APIVersion {
+	case APIVersionV1Alpha1:
+		snapshot := &SnapshotV1Alpha1{
+			APIVersion: raw.APIVersion,
+			Kind:       raw.Kind,
+			Resources:  raw.Resources,
+			Status:     raw.Status,
+		}
+		if err := decodeMetadata(raw.Metadata, &snapshot.Metadata); err != nil {
+			return nil, err
+		}
+		return ConvertV1Alpha1ToV1Alpha2(snapshot), nil
+	case APIVersionV1Alpha2:
+		snapshot := &Snapshot{
+			APIVersion: raw.APIVersion,
+			Kind:       raw.Kind,
+			Resources:  raw.Resources,
+			Status:     raw.Status,
+		}
+		if err := decodeMetadata(raw.Metadata, &snapshot.Metadata); err != nil {
+			return nil, err
+		}
+		return snapshot, nil
+	default:
+		_, err := ParseAPIVersion(raw.APIVersion)
+		return nil, err
+	}
+}
+
+func decodeMetadata(data json.RawMessage, metadata interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return fmt.Errorf("invalid snapshot metadata: %w", err)
+	}
+	return nil
+}
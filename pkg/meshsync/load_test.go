@@ -0,0 +1,131 @@
+package meshsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSnapshot(t *testing.T) {
+	status := &SnapshotStatus{Relists: []Relist{{Resource: "pods", Restarts: 1}}}
+
+	for _, format := range []string{FormatYAML, FormatYAMLStream, FormatJSON, FormatNDJSON} {
+		for _, apiVersion := range SupportedAPIVersions {
+			t.Run(format+"/"+apiVersion, func(t *testing.T) {
+				snapshot := newTestSnapshot(3, status)
+				snapshot.APIVersion = apiVersion
+				snapshot.Metadata.Labels = map[string]string{"env": "prod"}
+
+				data := encodeSnapshot(t, snapshot, format)
+				decoded, err := DecodeSnapshot(data)
+				if err != nil {
+					t.Fatalf("DecodeSnapshot() error = %v\n%s", err, data)
+				}
+				if decoded.APIVersion != SnapshotAPIVersion {
+					t.Errorf("Decoded apiVersion = %s, want it upgraded to %s", decoded.APIVersion, SnapshotAPIVersion)
+				}
+				if decoded.Metadata.Name != "test-snapshot" || decoded.Metadata.Labels["env"] != "prod" {
+					t.Errorf("Decoded metadata = %+v, want name test-snapshot and env=prod", decoded.Metadata)
+				}
+				if len(decoded.Resources) != 3 || decoded.Resources[2].Metadata()["name"] != "pod-2" {
+					t.Errorf("Decoded resources = %v, want pod-0 to pod-2", resourceNames(decoded))
+				}
+				if decoded.Status == nil || len(decoded.Status.Relists) != 1 {
+					t.Errorf("Decoded status = %+v, want %+v", decoded.Status, status)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeSnapshotErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "Wrong kind", data: "apiVersion: v1\nkind: List\n", wantErr: "unexpected kind"},
+		{name: "Unknown version", data: "apiVersion: meshery.layer5.io/v2\nkind: MeshSync\n", wantErr: "unsupported snapshot apiVersion"},
+		{name: "Invalid metadata", data: `{"apiVersion": "meshery.layer5.io/v1alpha2", "kind": "MeshSync", "metadata": {"labels": "x"}}`, wantErr: "invalid snapshot metadata"},
+		{name: "Invalid YAML", data: "apiVersion: [", wantErr: "failed to decode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeSnapshot([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecodeSnapshot() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotV1Alpha1(t *testing.T) {
+	// A snapshot written before metadata was versioned
+	data := `apiVersion: meshery.layer5.io/v1alpha1
+kind: MeshSync
+metadata:
+  name: kubernetes-snapshot
+  owner: platform-team
+resources:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: web
+    namespace: default
+  spec:
+    replicas: 2
+`
+	path := filepath.Join(t.TempDir(), "snapshot.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	snapshot, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if snapshot.APIVersion != SnapshotAPIVersion || snapshot.Metadata.Name != "kubernetes-snapshot" {
+		t.Errorf("Loaded snapshot = %s %+v, want an upgraded kubernetes-snapshot", snapshot.APIVersion, snapshot.Metadata)
+	}
+	if snapshot.Metadata.Annotations["owner"] != "platform-team" {
+		t.Errorf("Loaded annotations = %v, want owner kept", snapshot.Metadata.Annotations)
+	}
+	if len(snapshot.Resources) != 1 || snapshot.Resources[0].Spec()["replicas"] != int64(2) {
+		t.Errorf("Loaded resources = %+v, want web with 2 replicas", snapshot.Resources)
+	}
+}
+
+func TestSnapshotStdio(t *testing.T) {
+	// Redirect stdout and stdin to a file to write and read a snapshot with StdioPath
+	path := filepath.Join(t.TempDir(), "stdio")
+	stdio, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer stdio.Close()
+	stdout, stdin := os.Stdout, os.Stdin
+	defer func() { os.Stdout, os.Stdin = stdout, stdin }()
+
+	os.Stdout = stdio
+	if err := SaveSnapshot(newTestSnapshot(2, nil), StdioPath, SnapshotFileOptions{Format: FormatJSON, Compression: CompressionGzip}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	if _, err := stdio.Write(nil); err != nil {
+		t.Errorf("Writing the snapshot closed stdout: %v", err)
+	}
+
+	os.Stdin, err = os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer os.Stdin.Close()
+	snapshot, err := LoadSnapshot(StdioPath)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != 2 {
+		t.Errorf("Loaded %d resources, want 2", len(snapshot.Resources))
+	}
+}
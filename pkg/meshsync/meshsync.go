@@ -2,58 +2,107 @@ package meshsync
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"time"
 
-	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
 )
 
+// DefaultRollbackGracePeriod is how long an interrupted deploy has to
+// remove what it created
+const DefaultRollbackGracePeriod = 30 * time.Second
+
 // DeployOptions contains options for deploying MeshSync
 type DeployOptions struct {
 	Namespace string
 	Version   string
-}
-
-// CaptureOptions contains options for capturing snapshot
-type CaptureOptions struct {
-	Namespace     string
-	AllNamespaces bool
+	// RollbackGracePeriod bounds the rollback of a deploy whose context
+	// ends before MeshSync is ready; DefaultRollbackGracePeriod is used when 0
+	RollbackGracePeriod time.Duration
 }
 
 // CleanupOptions contains options for cleaning up MeshSync
 type CleanupOptions struct {
 	Namespace string
 	Force     bool
+	// IgnoreNotFound skips resources that do not exist instead of failing,
+	// for cleaning up after a deploy that did not complete
+	IgnoreNotFound bool
 }
 
-// Snapshot represents a MeshSync snapshot
+// Snapshot represents a MeshSync snapshot in the current schema version,
+// SnapshotAPIVersion. Older versions are upgraded when loaded, see LoadSnapshot.
 type Snapshot struct {
-	APIVersion string                 `json:"apiVersion" yaml:"apiVersion"`
-	Kind       string                 `json:"kind" yaml:"kind"`
-	Metadata   map[string]interface{} `json:"metadata" yaml:"metadata"`
-	Resources  []Resource             `json:"resources" yaml:"resources"`
+	APIVersion string           `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string           `json:"kind" yaml:"kind"`
+	Metadata   SnapshotMetadata `json:"metadata" yaml:"metadata"`
+	Resources  []Resource       `json:"resources" yaml:"resources"`
+	Status     *SnapshotStatus  `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// SnapshotStatus records how the capture went. Unlike the metadata, it is
+// only known once every resource has been written.
+type SnapshotStatus struct {
+	// Incomplete is set when the capture stopped early, for example because
+	// it was interrupted. The snapshot holds the resources captured until then.
+	Incomplete bool `json:"incomplete,omitempty" yaml:"incomplete,omitempty"`
+	// IncompleteReason is why the capture stopped early
+	IncompleteReason string `json:"incompleteReason,omitempty" yaml:"incompleteReason,omitempty"`
+	// Duration is how long the capture took
+	Duration string `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Counts is the number of resources captured per kind, keyed by kind
+	// and group, e.g. "Deployment.apps"
+	Counts map[string]int `json:"counts,omitempty" yaml:"counts,omitempty"`
+	// Relists records lists that were restarted because a continue token expired
+	Relists []Relist `json:"relists,omitempty" yaml:"relists,omitempty"`
+	// Errors records lists that failed when capturing with ContinueOnError.
+	// A snapshot with errors is missing the resources those lists would
+	// have returned.
+	Errors []CaptureError `json:"errors,omitempty" yaml:"errors,omitempty"`
 }
 
-// Resource represents a kubernetes resource in the snapshot
-type Resource struct {
-	APIVersion string                 `json:"apiVersion" yaml:"apiVersion"`
-	Kind       string                 `json:"kind" yaml:"kind"`
-	Metadata   map[string]interface{} `json:"metadata" yaml:"metadata"`
-	Spec       map[string]interface{} `json:"spec,omitempty" yaml:"spec,omitempty"`
-	Status     map[string]interface{} `json:"status,omitempty" yaml:"status,omitempty"`
+// Relist records a list that was restarted from a fresh resourceVersion.
+// Resources from such a list may be newer than the rest of the snapshot.
+type Relist struct {
+	Resource  string `json:"resource" yaml:"resource"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Restarts  int    `json:"restarts" yaml:"restarts"`
+}
+
+// CaptureError records a list that failed during capture
+type CaptureError struct {
+	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
+	Resource   string `json:"resource" yaml:"resource"`
+	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Code is the HTTP status code returned by the API server, or 0 if the
+	// request failed before a response was received
+	Code    int32  `json:"code,omitempty" yaml:"code,omitempty"`
+	Reason  string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Message string `json:"message" yaml:"message"`
 }
 
 // Deploy deploys MeshSync to the cluster
-func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error {
+func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) (err error) {
+	// If ctx ends before MeshSync is ready, for example because the deploy
+	// was interrupted, remove everything created so far in reverse order
+	var rollback []func(context.Context) error
+	defer func() {
+		if err == nil || ctx.Err() == nil {
+			return
+		}
+		if rollbackErr := rollbackDeploy(ctx, opts, rollback); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	}()
+
 	// Create namespace if it doesn't exist
-	_, err := client.Clientset.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
+	_, err = client.Clientset.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
 	if err != nil {
 		ns := &corev1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
@@ -64,6 +113,9 @@ func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error
 		if err != nil {
 			return fmt.Errorf("failed to create namespace %s: %w", opts.Namespace, err)
 		}
+		rollback = append(rollback, func(ctx context.Context) error {
+			return client.Clientset.CoreV1().Namespaces().Delete(ctx, opts.Namespace, metav1.DeleteOptions{})
+		})
 	}
 
 	// Create MeshSync deployment
@@ -109,6 +161,9 @@ func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error
 	if err != nil {
 		return fmt.Errorf("failed to create MeshSync deployment: %w", err)
 	}
+	rollback = append(rollback, func(ctx context.Context) error {
+		return client.Clientset.AppsV1().Deployments(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
+	})
 
 	// Create service account with necessary permissions
 	sa := &corev1.ServiceAccount{
@@ -121,6 +176,9 @@ func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error
 	if err != nil {
 		return fmt.Errorf("failed to create service account: %w", err)
 	}
+	rollback = append(rollback, func(ctx context.Context) error {
+		return client.Clientset.CoreV1().ServiceAccounts(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
+	})
 
 	// Create service for meshsync
 	svc := &corev1.Service{
@@ -145,6 +203,9 @@ func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
+	rollback = append(rollback, func(ctx context.Context) error {
+		return client.Clientset.CoreV1().Services(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
+	})
 
 	// Wait for deployment to be ready
 	for start := time.Now(); time.Since(start) < 2*time.Minute; {
@@ -165,6 +226,68 @@ func Deploy(ctx context.Context, client *kube.Client, opts DeployOptions) error
 	return fmt.Errorf("timeout waiting for MeshSync deployment to be ready")
 }
 
+// rollbackDeploy runs the rollback steps of an interrupted deploy in
+// reverse order, within the grace period and whatever ctx's state
+func rollbackDeploy(ctx context.Context, opts DeployOptions, steps []func(context.Context) error) error {
+	grace := opts.RollbackGracePeriod
+	if grace == 0 {
+		grace = DefaultRollbackGracePeriod
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), grace)
+	defer cancel()
+
+	var errs []error
+	for i := len(steps) - 1; i >= 0; i-- {
+		if err := steps[i](ctx); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to roll back MeshSync deploy: %w", err)
+	}
+	return nil
+}
+
+// DeployedVersion returns the version of the MeshSync image deployed in namespace
+func DeployedVersion(ctx context.Context, client *kube.Client, namespace string) (string, error) {
+	deploy, err := client.Clientset.AppsV1().Deployments(namespace).Get(ctx, "meshsync", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("MeshSync deployment not found: %w", err)
+	}
+
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == "meshsync" {
+			return imageTag(container.Image), nil
+		}
+	}
+	return "", fmt.Errorf("MeshSync container not found in deployment")
+}
+
+// Deployed reports whether a MeshSync deployment exists in namespace
+func Deployed(ctx context.Context, client *kube.Client, namespace string) (bool, error) {
+	_, err := client.Clientset.AppsV1().Deployments(namespace).Get(ctx, "meshsync", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get MeshSync deployment: %w", err)
+	}
+	return true, nil
+}
+
+// NamespaceExists reports whether namespace exists, so a caller can tell
+// whether Deploy will create it
+func NamespaceExists(ctx context.Context, client *kube.Client, namespace string) (bool, error) {
+	_, err := client.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	return true, nil
+}
+
 // Validate checks if MeshSync is running in the cluster
 func Validate(ctx context.Context, client *kube.Client, namespace string) error {
 	// Check if MeshSync deployment exists and is ready
@@ -186,94 +309,16 @@ func Validate(ctx context.Context, client *kube.Client, namespace string) error
 	return nil
 }
 
-// CaptureSnapshot captures cluster state using MeshSync
-func CaptureSnapshot(ctx context.Context, client *kube.Client, opts CaptureOptions) (*Snapshot, error) {
-	// This is a simplified implementation
-	// In a real implementation, this would:
-	// 1. Connect to the MeshSync API or query its data store
-	// 2. Gather all resources based on filters
-	// 3. Format them into a structured snapshot
-
-	// For demo purposes, we'll construct a simple snapshot
-	snapshot := &Snapshot{
-		APIVersion: "meshery.layer5.io/v1alpha1",
-		Kind:       "MeshSync",
-		Metadata: map[string]interface{}{
-			"name":      "kubernetes-snapshot",
-			"timestamp": time.Now().Format(time.RFC3339),
-		},
-		Resources: []Resource{},
-	}
-
-	// Get namespaces
-	namespaces := []string{opts.Namespace}
-	if opts.AllNamespaces {
-		nsList, err := client.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to list namespaces: %w", err)
-		}
-		namespaces = []string{}
-		for _, ns := range nsList.Items {
-			namespaces = append(namespaces, ns.Name)
-		}
-	}
-
-	// For each namespace, get deployments
-	for _, ns := range namespaces {
-		deployments, err := client.Clientset.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to list deployments in namespace %s: %w", ns, err)
-		}
-
-		for _, deploy := range deployments.Items {
-			// Convert deployment to map for storage in snapshot
-			deployBytes, err := json.Marshal(deploy)
-			if err != nil {
-				return nil, fmt.Errorf("error marshaling deployment: %w", err)
-			}
-
-			var deployMap map[string]interface{}
-			err = json.Unmarshal(deployBytes, &deployMap)
-			if err != nil {
-				return nil, fmt.Errorf("error unmarshaling deployment: %w", err)
-			}
-
-			resource := Resource{
-				APIVersion: "apps/v1",
-				Kind:       "Deployment",
-				Metadata:   deployMap["metadata"].(map[string]interface{}),
-				Spec:       deployMap["spec"].(map[string]interface{}),
-				Status:     deployMap["status"].(map[string]interface{}),
-			}
-
-			snapshot.Resources = append(snapshot.Resources, resource)
-		}
-	}
-
-	return snapshot, nil
-}
-
 // SaveSnapshot saves the snapshot to a file
-func SaveSnapshot(snapshot *Snapshot, filePath string, format string) error {
-	var data []byte
-	var err error
-
-	if format == "json" {
-		data, err = json.MarshalIndent(snapshot, "", "  ")
-		if err != nil {
-			return fmt.Errorf("failed to marshal snapshot to JSON: %w", err)
-		}
-	} else {
-		// Default to YAML
-		data, err = yaml.Marshal(snapshot)
-		if err != nil {
-			return fmt.Errorf("failed to marshal snapshot to YAML: %w", err)
-		}
+func SaveSnapshot(snapshot *Snapshot, filePath string, opts SnapshotFileOptions) error {
+	file, err := CreateSnapshotFile(filePath, opts)
+	if err != nil {
+		return err
 	}
 
-	err = ioutil.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	if err := WriteSnapshot(file, snapshot); err != nil {
+		file.Abort()
+		return err
 	}
 
 	return nil
@@ -283,19 +328,19 @@ func SaveSnapshot(snapshot *Snapshot, filePath string, format string) error {
 func Cleanup(ctx context.Context, client *kube.Client, opts CleanupOptions) error {
 	// Delete deployment
 	err := client.Clientset.AppsV1().Deployments(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
-	if err != nil {
+	if err != nil && !(opts.IgnoreNotFound && apierrors.IsNotFound(err)) {
 		return fmt.Errorf("failed to delete MeshSync deployment: %w", err)
 	}
 
 	// Delete service
 	err = client.Clientset.CoreV1().Services(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
-	if err != nil {
+	if err != nil && !(opts.IgnoreNotFound && apierrors.IsNotFound(err)) {
 		return fmt.Errorf("failed to delete MeshSync service: %w", err)
 	}
 
 	// Delete service account
 	err = client.Clientset.CoreV1().ServiceAccounts(opts.Namespace).Delete(ctx, "meshsync", metav1.DeleteOptions{})
-	if err != nil {
+	if err != nil && !(opts.IgnoreNotFound && apierrors.IsNotFound(err)) {
 		return fmt.Errorf("failed to delete MeshSync service account: %w", err)
 	}
 
@@ -309,11 +354,11 @@ func Cleanup(ctx context.Context, client *kube.Client, opts CleanupOptions) erro
 
 		if len(deployments.Items) == 0 {
 			err = client.Clientset.CoreV1().Namespaces().Delete(ctx, opts.Namespace, metav1.DeleteOptions{})
-			if err != nil {
+			if err != nil && !(opts.IgnoreNotFound && apierrors.IsNotFound(err)) {
 				return fmt.Errorf("failed to delete namespace %s: %w", opts.Namespace, err)
 			}
 		}
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
@@ -1,27 +1,37 @@
 package meshsync
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
 )
 
 func TestSaveSnapshotYAML(t *testing.T) {
 	// Create a test snapshot
 	snapshot := &Snapshot{
-		APIVersion: "meshery.layer5.io/v1alpha1",
-		Kind:       "MeshSync",
-		Metadata: map[string]interface{}{
-			"name": "test-snapshot",
-		},
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   SnapshotMetadata{Name: "test-snapshot"},
 		Resources: []Resource{
-			{
-				APIVersion: "v1",
-				Kind:       "Pod",
-				Metadata: map[string]interface{}{
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata": map[string]interface{}{
 					"name":      "test-pod",
 					"namespace": "default",
 				},
-				Spec: map[string]interface{}{
+				"spec": map[string]interface{}{
 					"containers": []interface{}{
 						map[string]interface{}{
 							"name":  "nginx",
@@ -29,7 +39,7 @@ func TestSaveSnapshotYAML(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 	}
 
@@ -42,7 +52,7 @@ func TestSaveSnapshotYAML(t *testing.T) {
 	tmpfile.Close()
 
 	// Save snapshot to file
-	err = SaveSnapshot(snapshot, tmpfile.Name(), "yaml")
+	err = SaveSnapshot(snapshot, tmpfile.Name(), SnapshotFileOptions{Format: FormatYAML, Overwrite: true})
 	if err != nil {
 		t.Fatalf("SaveSnapshot() error = %v", err)
 	}
@@ -60,20 +70,18 @@ func TestSaveSnapshotYAML(t *testing.T) {
 func TestSaveSnapshotJSON(t *testing.T) {
 	// Create a test snapshot
 	snapshot := &Snapshot{
-		APIVersion: "meshery.layer5.io/v1alpha1",
-		Kind:       "MeshSync",
-		Metadata: map[string]interface{}{
-			"name": "test-snapshot",
-		},
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   SnapshotMetadata{Name: "test-snapshot"},
 		Resources: []Resource{
-			{
-				APIVersion: "v1",
-				Kind:       "Pod",
-				Metadata: map[string]interface{}{
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata": map[string]interface{}{
 					"name":      "test-pod",
 					"namespace": "default",
 				},
-				Spec: map[string]interface{}{
+				"spec": map[string]interface{}{
 					"containers": []interface{}{
 						map[string]interface{}{
 							"name":  "nginx",
@@ -81,7 +89,7 @@ func TestSaveSnapshotJSON(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 	}
 
@@ -94,7 +102,7 @@ func TestSaveSnapshotJSON(t *testing.T) {
 	tmpfile.Close()
 
 	// Save snapshot to file
-	err = SaveSnapshot(snapshot, tmpfile.Name(), "json")
+	err = SaveSnapshot(snapshot, tmpfile.Name(), SnapshotFileOptions{Format: FormatJSON, Overwrite: true})
 	if err != nil {
 		t.Fatalf("SaveSnapshot() error = %v", err)
 	}
@@ -107,4 +115,338 @@ func TestSaveSnapshotJSON(t *testing.T) {
 	if info.Size() == 0 {
 		t.Fatal("Snapshot file is empty")
 	}
-} 
\ No newline at end of file
+}
+
+// newFakeClient returns a kube.Client backed by a fake clientset seeded with objects
+func newFakeClient(objects ...runtime.Object) (*kube.Client, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	return kube.NewClientFromInterfaces(clientset, nil, nil), clientset
+}
+
+// newDeployment returns a deployment with the given name, namespace and ready replicas
+func newDeployment(name, namespace string, readyReplicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": name},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			ReadyReplicas: readyReplicas,
+		},
+	}
+}
+
+// newMeshSyncObjects returns the objects created by a successful Deploy
+func newMeshSyncObjects(namespace string) []runtime.Object {
+	return []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}},
+		newDeployment("meshsync", namespace, 1),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "meshsync", Namespace: namespace}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "meshsync", Namespace: namespace}},
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	client, clientset := newFakeClient()
+
+	// The fake clientset has no controllers, so mark the deployment ready on creation
+	clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deploy := action.(k8stesting.CreateAction).GetObject().(*appsv1.Deployment)
+		deploy.Status.ReadyReplicas = 1
+		return false, nil, nil
+	})
+
+	err := Deploy(context.Background(), client, DeployOptions{
+		Namespace: "meshery",
+		Version:   "v0.6.0",
+	})
+	if err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, "meshery", metav1.GetOptions{}); err != nil {
+		t.Errorf("Namespace was not created: %v", err)
+	}
+	deploy, err := clientset.AppsV1().Deployments("meshery").Get(ctx, "meshsync", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Deployment was not created: %v", err)
+	}
+	if image := deploy.Spec.Template.Spec.Containers[0].Image; image != "layer5/meshsync:v0.6.0" {
+		t.Errorf("Deployment image = %s, want layer5/meshsync:v0.6.0", image)
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); err != nil {
+		t.Errorf("Service account was not created: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); err != nil {
+		t.Errorf("Service was not created: %v", err)
+	}
+}
+
+func TestDeployExistingNamespace(t *testing.T) {
+	client, clientset := newFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}})
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		t.Errorf("Deploy() should not create an existing namespace")
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*appsv1.Deployment).Status.ReadyReplicas = 1
+		return false, nil, nil
+	})
+
+	if err := Deploy(context.Background(), client, DeployOptions{Namespace: "meshery", Version: "latest"}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+}
+
+func TestDeployInterrupted(t *testing.T) {
+	tests := []struct {
+		name            string
+		objects         []runtime.Object
+		expectNamespace bool
+	}{
+		{
+			name:            "Created namespace is removed",
+			expectNamespace: false,
+		},
+		{
+			name:            "Existing namespace is kept",
+			objects:         []runtime.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}}},
+			expectNamespace: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, clientset := newFakeClient(tt.objects...)
+
+			// Interrupt the deploy once everything is created, while it
+			// waits for the deployment to become ready
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				cancel()
+				return false, nil, nil
+			})
+
+			err := Deploy(ctx, client, DeployOptions{Namespace: "meshery", Version: "latest"})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Deploy() error = %v, want context.Canceled", err)
+			}
+
+			bg := context.Background()
+			if _, err := clientset.AppsV1().Deployments("meshery").Get(bg, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync deployment was not rolled back: %v", err)
+			}
+			if _, err := clientset.CoreV1().ServiceAccounts("meshery").Get(bg, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync service account was not rolled back: %v", err)
+			}
+			if _, err := clientset.CoreV1().Services("meshery").Get(bg, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync service was not rolled back: %v", err)
+			}
+			_, err = clientset.CoreV1().Namespaces().Get(bg, "meshery", metav1.GetOptions{})
+			if exists := err == nil; exists != tt.expectNamespace {
+				t.Errorf("Namespace exists = %v, want %v", exists, tt.expectNamespace)
+			}
+		})
+	}
+}
+
+func TestDeployAlreadyDeployed(t *testing.T) {
+	client, _ := newFakeClient(newMeshSyncObjects("meshery")...)
+
+	err := Deploy(context.Background(), client, DeployOptions{Namespace: "meshery", Version: "latest"})
+	if err == nil {
+		t.Fatal("Deploy() expected error when MeshSync is already deployed")
+	}
+}
+
+func TestDeployContextCanceled(t *testing.T) {
+	client, _ := newFakeClient()
+
+	// The deployment never becomes ready, so Deploy must return once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Deploy(ctx, client, DeployOptions{Namespace: "meshery", Version: "latest"})
+	if err == nil {
+		t.Fatal("Deploy() expected error for canceled context")
+	}
+}
+
+func TestDeployedVersion(t *testing.T) {
+	deploy := newDeployment("meshsync", "meshery", 1)
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{Name: "meshsync", Image: "layer5/meshsync:v0.6.0"}}
+	client, _ := newFakeClient(deploy)
+
+	version, err := DeployedVersion(context.Background(), client, "meshery")
+	if err != nil {
+		t.Fatalf("DeployedVersion() error = %v", err)
+	}
+	if version != "v0.6.0" {
+		t.Errorf("DeployedVersion() = %s, want v0.6.0", version)
+	}
+
+	if _, err := DeployedVersion(context.Background(), client, "other"); err == nil {
+		t.Error("DeployedVersion() expected error when MeshSync is not deployed")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		expectError bool
+	}{
+		{
+			name:        "Ready deployment and service",
+			objects:     newMeshSyncObjects("meshery"),
+			expectError: false,
+		},
+		{
+			name:        "Missing deployment",
+			objects:     nil,
+			expectError: true,
+		},
+		{
+			name:        "Deployment not ready",
+			objects:     []runtime.Object{newDeployment("meshsync", "meshery", 0)},
+			expectError: true,
+		},
+		{
+			name:        "Missing service",
+			objects:     []runtime.Object{newDeployment("meshsync", "meshery", 1)},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newFakeClient(tt.objects...)
+
+			err := Validate(context.Background(), client, "meshery")
+			if (err != nil) != tt.expectError {
+				t.Errorf("Validate() error = %v, expectError %v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestDeployed(t *testing.T) {
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		expected bool
+	}{
+		{name: "Deployed", objects: newMeshSyncObjects("meshery"), expected: true},
+		{name: "Deployed but not ready", objects: []runtime.Object{newDeployment("meshsync", "meshery", 0)}, expected: true},
+		{name: "Not deployed", objects: nil, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newFakeClient(tt.objects...)
+
+			deployed, err := Deployed(context.Background(), client, "meshery")
+			if err != nil {
+				t.Fatalf("Deployed() error = %v", err)
+			}
+			if deployed != tt.expected {
+				t.Errorf("Deployed() = %v, want %v", deployed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNamespaceExists(t *testing.T) {
+	client, _ := newFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}})
+	for namespace, want := range map[string]bool{"meshery": true, "other": false} {
+		exists, err := NamespaceExists(context.Background(), client, namespace)
+		if err != nil {
+			t.Fatalf("NamespaceExists(%s) error = %v", namespace, err)
+		}
+		if exists != want {
+			t.Errorf("NamespaceExists(%s) = %v, want %v", namespace, exists, want)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name            string
+		objects         []runtime.Object
+		force           bool
+		ignoreNotFound  bool
+		expectNamespace bool
+		expectError     bool
+	}{
+		{
+			name:            "Keep namespace",
+			objects:         newMeshSyncObjects("meshery"),
+			force:           false,
+			expectNamespace: true,
+		},
+		{
+			name:            "Force removes empty namespace",
+			objects:         newMeshSyncObjects("meshery"),
+			force:           true,
+			expectNamespace: false,
+		},
+		{
+			name:            "Force keeps namespace with other deployments",
+			objects:         append(newMeshSyncObjects("meshery"), newDeployment("other", "meshery", 1)),
+			force:           true,
+			expectNamespace: true,
+		},
+		{
+			name:        "Nothing deployed",
+			objects:     nil,
+			expectError: true,
+		},
+		{
+			name: "Partial deploy ignoring missing resources",
+			objects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "meshery"}},
+				newDeployment("meshsync", "meshery", 0),
+			},
+			force:           true,
+			ignoreNotFound:  true,
+			expectNamespace: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, clientset := newFakeClient(tt.objects...)
+			ctx := context.Background()
+
+			err := Cleanup(ctx, client, CleanupOptions{Namespace: "meshery", Force: tt.force, IgnoreNotFound: tt.ignoreNotFound})
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Cleanup() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if _, err := clientset.AppsV1().Deployments("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync deployment was not deleted: %v", err)
+			}
+			if _, err := clientset.CoreV1().Services("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync service was not deleted: %v", err)
+			}
+			if _, err := clientset.CoreV1().ServiceAccounts("meshery").Get(ctx, "meshsync", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("MeshSync service account was not deleted: %v", err)
+			}
+
+			_, err = clientset.CoreV1().Namespaces().Get(ctx, "meshery", metav1.GetOptions{})
+			if exists := err == nil; exists != tt.expectNamespace {
+				t.Errorf("Namespace exists = %v, want %v", exists, tt.expectNamespace)
+			}
+		})
+	}
+}
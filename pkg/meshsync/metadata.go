@@ -0,0 +1,123 @@
+package meshsync
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/version"
+)
+
+// DefaultSnapshotName is the snapshot name used when none is given
+const DefaultSnapshotName = "kubernetes-snapshot"
+
+// newSnapshotMetadata describes the snapshot being captured: its name and
+// labels, the cluster it comes from and how it was captured. Cluster details
+// the credentials are not allowed to read are left out rather than failing
+// the capture.
+func newSnapshotMetadata(ctx context.Context, client *kube.Client, opts CaptureOptions, kinds []resourceKind, start time.Time) SnapshotMetadata {
+	name := opts.Name
+	if name == "" {
+		name = DefaultSnapshotName
+	}
+
+	metadata := SnapshotMetadata{
+		Name:      name,
+		Timestamp: start.Format(time.RFC3339),
+		Cluster:   clusterMetadata(ctx, client, opts.ChunkSize),
+		Capture:   captureMetadata(opts, kinds),
+	}
+	if len(opts.Labels) > 0 {
+		metadata.Labels = map[string]string{}
+		for k, v := range opts.Labels {
+			metadata.Labels[k] = v
+		}
+	}
+
+	return metadata
+}
+
+// ClusterID returns a stable ID for the cluster: the UID of the kube-system
+// namespace, which lives as long as the cluster. It is empty if the
+// credentials cannot read it.
+func ClusterID(ctx context.Context, client *kube.Client) string {
+	ns, err := client.Clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return string(ns.UID)
+}
+
+// clusterMetadata identifies the cluster the snapshot is captured from
+func clusterMetadata(ctx context.Context, client *kube.Client, chunkSize int64) *ClusterInfo {
+	cluster := &ClusterInfo{Context: client.Context}
+
+	if client.Config != nil {
+		cluster.Server = client.Config.Host
+	}
+
+	cluster.ID = ClusterID(ctx, client)
+
+	if client.Discovery != nil {
+		if info, err := client.Discovery.ServerVersion(); err == nil {
+			cluster.KubernetesVersion = info.GitVersion
+		}
+	}
+
+	nodes := 0
+	_, err := listAllPages(ctx, chunkSize, func(ctx context.Context, listOpts metav1.ListOptions) (metav1.ListInterface, error) {
+		nodeList, err := client.Clientset.CoreV1().Nodes().List(ctx, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		nodes += len(nodeList.Items)
+		return nodeList, nil
+	}, func() {
+		nodes = 0
+	})
+	if err == nil {
+		cluster.NodeCount = &nodes
+	}
+
+	return cluster
+}
+
+// captureMetadata records the plugin and MeshSync versions and the filters
+// the snapshot was captured with
+func captureMetadata(opts CaptureOptions, kinds []resourceKind) *CaptureInfo {
+	capture := &CaptureInfo{
+		PluginVersion:   version.Version,
+		MeshSyncVersion: opts.MeshSyncVersion,
+		Filters:         CaptureFilters{AllNamespaces: opts.AllNamespaces},
+	}
+	for _, kind := range kinds {
+		capture.Filters.Kinds = append(capture.Filters.Kinds, kind.GVR.GroupResource().String())
+	}
+	if !opts.AllNamespaces {
+		capture.Filters.Namespace = opts.Namespace
+	}
+
+	return capture
+}
+
+// countKey returns the key a resource kind is counted under in the snapshot
+// status, such as "Deployment.apps" or "Service"
+func countKey(kind resourceKind) string {
+	return schema.GroupKind{Group: kind.GVR.Group, Kind: kind.Kind}.String()
+}
+
+// imageTag returns the tag of a container image reference, or "latest" when
+// it has none
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
@@ -0,0 +1,115 @@
+package meshsync
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/version"
+)
+
+func TestCaptureSnapshotMetadata(t *testing.T) {
+	client, _ := newFakeCaptureClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID("cluster-uid")}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		newDeployment("web", "default", 1),
+		newDeployment("api", "default", 1),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+	)
+	client.Config = &rest.Config{Host: "https://prod.example.com:6443"}
+	client.Context = "prod"
+
+	snapshot, err := CaptureSnapshot(context.Background(), client, CaptureOptions{
+		Namespace:       "default",
+		Name:            "nightly",
+		Labels:          map[string]string{"env": "prod"},
+		Kinds:           []string{"deployments", "services", "configmaps"},
+		MeshSyncVersion: "v0.6.0",
+	})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+
+	metadata := snapshot.Metadata
+	if metadata.Name != "nightly" {
+		t.Errorf("Metadata name = %v, want nightly", metadata.Name)
+	}
+	if metadata.Labels["env"] != "prod" {
+		t.Errorf("Metadata labels = %v, want env=prod", metadata.Labels)
+	}
+
+	cluster := metadata.Cluster
+	if cluster == nil {
+		t.Fatalf("Metadata cluster is missing")
+	}
+	if cluster.Server != "https://prod.example.com:6443" || cluster.Context != "prod" || cluster.ID != "cluster-uid" {
+		t.Errorf("Metadata cluster = %+v, want server, context prod and id cluster-uid", cluster)
+	}
+	if cluster.NodeCount == nil || *cluster.NodeCount != 2 {
+		t.Errorf("Metadata cluster.nodeCount = %v, want 2", cluster.NodeCount)
+	}
+	if cluster.KubernetesVersion == "" {
+		t.Errorf("Metadata cluster.kubernetesVersion is missing")
+	}
+
+	capture := metadata.Capture
+	if capture == nil || capture.PluginVersion != version.Version || capture.MeshSyncVersion != "v0.6.0" {
+		t.Fatalf("Metadata capture = %+v, want plugin %s and MeshSync v0.6.0", capture, version.Version)
+	}
+	if filters := capture.Filters; filters.Namespace != "default" || len(filters.Kinds) != 3 {
+		t.Errorf("Metadata capture.filters = %+v, want namespace default and three kinds", filters)
+	}
+
+	if snapshot.Status == nil || snapshot.Status.Duration == "" {
+		t.Fatalf("Status = %+v, want a capture duration", snapshot.Status)
+	}
+	expectedCounts := map[string]int{"Deployment.apps": 2, "Service": 1, "ConfigMap": 0}
+	for kind, count := range expectedCounts {
+		if snapshot.Status.Counts[kind] != count {
+			t.Errorf("Status counts[%s] = %d, want %d", kind, snapshot.Status.Counts[kind], count)
+		}
+	}
+}
+
+func TestCaptureSnapshotMetadataRestricted(t *testing.T) {
+	// Without kube-system, nodes or a rest config the cluster details are left out
+	client, _ := newFakeCaptureClient()
+
+	snapshot, err := CaptureSnapshot(context.Background(), client, CaptureOptions{Namespace: "default"})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+	if snapshot.Metadata.Name != DefaultSnapshotName {
+		t.Errorf("Metadata name = %v, want %s", snapshot.Metadata.Name, DefaultSnapshotName)
+	}
+	if cluster := snapshot.Metadata.Cluster; cluster.Server != "" || cluster.Context != "" || cluster.ID != "" {
+		t.Errorf("Metadata cluster = %+v, want server, context and id left out", cluster)
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "layer5/meshsync:v0.6.0", expected: "v0.6.0"},
+		{image: "layer5/meshsync", expected: "latest"},
+		{image: "registry.local:5000/layer5/meshsync", expected: "latest"},
+		{image: "registry.local:5000/layer5/meshsync:v1", expected: "v1"},
+		{image: "layer5/meshsync@sha256:abc", expected: "sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if tag := imageTag(tt.image); tag != tt.expected {
+				t.Errorf("imageTag(%s) = %s, want %s", tt.image, tag, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/oci"
+)
+
+// Media types of snapshots pushed to OCI registries. The layer holds the
+// snapshot file as is, with +gzip or +zstd appended when it is compressed,
+// or +age when it is encrypted.
+const (
+	OCIArtifactType   = "application/vnd.meshery.meshsync.snapshot.v1"
+	OCILayerMediaType = "application/vnd.meshery.meshsync.snapshot.layer.v1"
+)
+
+// Annotations of snapshot manifests. The standard created and title keys
+// hold the capture time and the name of the pushed file.
+const (
+	ociAnnotationCreated = "org.opencontainers.image.created"
+	ociAnnotationTitle   = "org.opencontainers.image.title"
+	ociAnnotationPrefix  = "io.meshery.meshsync.snapshot."
+)
+
+// SnapshotArtifact is a snapshot manifest found in a registry
+type SnapshotArtifact struct {
+	// Ref points at the manifest by digest
+	Ref      oci.Reference
+	Manifest *oci.Manifest
+	// Layer is the snapshot file
+	Layer oci.Descriptor
+}
+
+// FileName returns the name of the file the snapshot was pushed from
+func (a *SnapshotArtifact) FileName() string {
+	name := path.Base(a.Layer.Annotations[ociAnnotationTitle])
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// OCIAnnotations returns the manifest annotations describing a snapshot:
+// its name, labels, cluster, capture time and resource counts
+func OCIAnnotations(snapshot *Snapshot) map[string]string {
+	annotations := map[string]string{
+		ociAnnotationPrefix + "name":      snapshot.Metadata.Name,
+		ociAnnotationPrefix + "resources": strconv.Itoa(len(snapshot.Resources)),
+	}
+	if snapshot.Metadata.Timestamp != "" {
+		annotations[ociAnnotationCreated] = snapshot.Metadata.Timestamp
+	}
+	if len(snapshot.Metadata.Labels) > 0 {
+		labels, _ := json.Marshal(snapshot.Metadata.Labels)
+		annotations[ociAnnotationPrefix+"labels"] = string(labels)
+	}
+	if cluster := snapshot.Metadata.Cluster; cluster != nil {
+		for key, value := range map[string]string{
+			"cluster.id":                 cluster.ID,
+			"cluster.server":             cluster.Server,
+			"cluster.context":            cluster.Context,
+			"cluster.kubernetes-version": cluster.KubernetesVersion,
+		} {
+			if value != "" {
+				annotations[ociAnnotationPrefix+key] = value
+			}
+		}
+	}
+	if status := snapshot.Status; status != nil {
+		if len(status.Counts) > 0 {
+			counts, _ := json.Marshal(status.Counts)
+			annotations[ociAnnotationPrefix+"counts"] = string(counts)
+		}
+		if status.Incomplete {
+			annotations[ociAnnotationPrefix+"incomplete"] = "true"
+		}
+	}
+	return annotations
+}
+
+// PushSnapshot pushes the snapshot file at filePath, which may also be
+// StdioPath or a sink URL, to a registry as an OCI artifact and returns the
+// reference of the manifest by digest. The file is pushed as is, so a
+// pulled snapshot is byte for byte the one pushed.
+func PushSnapshot(ctx context.Context, client *oci.Client, ref oci.Reference, filePath string) (oci.Reference, error) {
+	reader, err := OpenSnapshotData(filePath)
+	if err != nil {
+		return oci.Reference{}, err
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return oci.Reference{}, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	layer := oci.Descriptor{
+		MediaType: OCILayerMediaType,
+		Digest:    oci.Digest(data),
+		Size:      int64(len(data)),
+	}
+	// Only snapshots are pushed, and their metadata becomes annotations.
+	// Encrypted snapshots are pushed without it, which would give away what
+	// the encryption hides.
+	var annotations map[string]string
+	if !IsEncrypted(data) {
+		snapshot, err := ReadSnapshot(bytes.NewReader(data))
+		if err != nil {
+			return oci.Reference{}, fmt.Errorf("failed to load snapshot %s: %w", filePath, err)
+		}
+		annotations = OCIAnnotations(snapshot)
+	}
+	switch {
+	case IsEncrypted(data):
+		layer.MediaType += "+age"
+	case bytes.HasPrefix(data, gzipMagic):
+		layer.MediaType += "+gzip"
+	case bytes.HasPrefix(data, zstdMagic):
+		layer.MediaType += "+zstd"
+	}
+	if filePath != StdioPath {
+		layer.Annotations = map[string]string{ociAnnotationTitle: path.Base(trimQuery(filePath))}
+	}
+	config := oci.Descriptor{
+		MediaType: oci.MediaTypeEmptyJSON,
+		Digest:    oci.Digest(oci.EmptyJSON),
+		Size:      int64(len(oci.EmptyJSON)),
+	}
+
+	if err := client.PushBlob(ctx, ref, config.Digest, bytes.NewReader(oci.EmptyJSON), config.Size); err != nil {
+		return oci.Reference{}, fmt.Errorf("failed to push snapshot to %s: %w", ref, err)
+	}
+	if err := client.PushBlob(ctx, ref, layer.Digest, bytes.NewReader(data), layer.Size); err != nil {
+		return oci.Reference{}, fmt.Errorf("failed to push snapshot to %s: %w", ref, err)
+	}
+	digest, err := client.PushManifest(ctx, ref, &oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeImageManifest,
+		ArtifactType:  OCIArtifactType,
+		Config:        config,
+		Layers:        []oci.Descriptor{layer},
+		Annotations:   annotations,
+	})
+	if err != nil {
+		return oci.Reference{}, fmt.Errorf("failed to push snapshot to %s: %w", ref, err)
+	}
+	ref.Digest = digest
+	return ref, nil
+}
+
+// FetchSnapshotArtifact fetches the manifest of a snapshot pushed with
+// PushSnapshot, failing for other artifacts and images
+func FetchSnapshotArtifact(ctx context.Context, client *oci.Client, ref oci.Reference) (*SnapshotArtifact, error) {
+	manifest, digest, err := client.FetchManifest(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull snapshot from %s: %w", ref, err)
+	}
+	if manifest.ArtifactType != OCIArtifactType && manifest.Config.MediaType != OCIArtifactType {
+		return nil, fmt.Errorf("%s is not a snapshot, its artifact type is %q", ref, manifest.ArtifactType)
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == OCILayerMediaType || strings.HasPrefix(layer.MediaType, OCILayerMediaType+"+") {
+			ref.Digest = digest
+			return &SnapshotArtifact{Ref: ref, Manifest: manifest, Layer: layer}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s holds no snapshot layer", ref)
+}
+
+// PullSnapshot downloads the snapshot of an artifact to filePath, which may
+// also be StdioPath or a sink URL. The download is checked against the
+// layer digest before the file is moved into place.
+func PullSnapshot(ctx context.Context, client *oci.Client, artifact *SnapshotArtifact, filePath string, overwrite bool) error {
+	blob, err := client.FetchBlob(ctx, artifact.Ref, artifact.Layer)
+	if err != nil {
+		return fmt.Errorf("failed to pull snapshot from %s: %w", artifact.Ref, err)
+	}
+	defer blob.Close()
+
+	if err := SaveSnapshotData(blob, filePath, overwrite); err != nil {
+		return fmt.Errorf("failed to pull snapshot from %s: %w", artifact.Ref, err)
+	}
+	return nil
+}
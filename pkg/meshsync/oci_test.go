@@ -0,0 +1,220 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/oci"
+)
+
+// fakeRegistry is an in-process OCI registry that hands out bearer tokens
+// for the credentials user:secret
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, string) {
+	registry := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	return registry, strings.TrimPrefix(server.URL, "http://")
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-" + r.URL.Query().Get("scope")})
+		return
+	}
+	scope := "repository:snapshots/prod:pull"
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		scope += ",push"
+	}
+	if r.Header.Get("Authorization") != "Bearer token-"+scope && r.Header.Get("Authorization") != "Bearer token-repository:snapshots/prod:pull,push" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/snapshots/prod")
+	switch {
+	case r.Method == http.MethodPost && path == "/blobs/uploads/":
+		f.uploads++
+		w.Header().Set("Location", "/v2/snapshots/prod/blobs/uploads/session?state=1")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && path == "/blobs/uploads/session":
+		body, _ := io.ReadAll(r.Body)
+		if digest := r.URL.Query().Get("digest"); digest != oci.Digest(body) || r.URL.Query().Get("state") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest does not match"}]}`)
+			return
+		}
+		f.blobs[oci.Digest(body)] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/blobs/"):
+		blob, ok := f.blobs[strings.TrimPrefix(path, "/blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/manifests/"):
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != oci.MediaTypeImageManifest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.manifests[strings.TrimPrefix(path, "/manifests/")] = body
+		f.manifests[oci.Digest(body)] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/manifests/"):
+		manifest, ok := f.manifests[strings.TrimPrefix(path, "/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", oci.MediaTypeImageManifest)
+		w.Write(manifest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestOCIClient() *oci.Client {
+	client := oci.NewClient()
+	client.Credentials = func(registry string) (oci.Credentials, error) {
+		return oci.Credentials{Username: "user", Password: "secret"}, nil
+	}
+	return client
+}
+
+func TestPushPullSnapshot(t *testing.T) {
+	registry, host := newFakeRegistry(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	input := filepath.Join(dir, "prod.yaml.gz")
+	snapshot := newTestSnapshot(3, &SnapshotStatus{Counts: map[string]int{"Deployment.apps": 3}})
+	snapshot.Metadata.Cluster = &ClusterInfo{ID: "5c1e0d", Context: "prod"}
+	if err := SaveSnapshot(snapshot, input, SnapshotFileOptions{Format: FormatYAML, Compression: CompressionGzip}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	ref, err := oci.ParseReference("oci://" + host + "/snapshots/prod:v1")
+	if err != nil {
+		t.Fatalf("ParseReference() error = %v", err)
+	}
+	client := newTestOCIClient()
+	pushed, err := PushSnapshot(ctx, client, ref, input)
+	if err != nil {
+		t.Fatalf("PushSnapshot() error = %v", err)
+	}
+	if pushed.Digest == "" || pushed.Tag != "v1" {
+		t.Errorf("PushSnapshot() = %s, want the tag and manifest digest", pushed)
+	}
+
+	// Blobs the registry has are not uploaded again
+	if _, err := PushSnapshot(ctx, client, ref, input); err != nil {
+		t.Fatalf("PushSnapshot() again error = %v", err)
+	}
+	if registry.uploads != 2 {
+		t.Errorf("Pushing twice uploaded %d blobs, want 2", registry.uploads)
+	}
+
+	// A new client pulls with a fresh token
+	client = newTestOCIClient()
+	artifact, err := FetchSnapshotArtifact(ctx, client, ref)
+	if err != nil {
+		t.Fatalf("FetchSnapshotArtifact() error = %v", err)
+	}
+	if artifact.Ref.Digest != pushed.Digest {
+		t.Errorf("FetchSnapshotArtifact() digest = %s, want %s", artifact.Ref.Digest, pushed.Digest)
+	}
+	if artifact.Layer.MediaType != OCILayerMediaType+"+gzip" || artifact.FileName() != "prod.yaml.gz" {
+		t.Errorf("Layer media type = %s, file name = %s", artifact.Layer.MediaType, artifact.FileName())
+	}
+	annotations := artifact.Manifest.Annotations
+	for key, want := range map[string]string{
+		ociAnnotationCreated:                    snapshot.Metadata.Timestamp,
+		ociAnnotationPrefix + "cluster.id":      "5c1e0d",
+		ociAnnotationPrefix + "cluster.context": "prod",
+		ociAnnotationPrefix + "resources":       "3",
+		ociAnnotationPrefix + "counts":          `{"Deployment.apps":3}`,
+		ociAnnotationPrefix + "name":            snapshot.Metadata.Name,
+	} {
+		if annotations[key] != want {
+			t.Errorf("Annotation %s = %q, want %q", key, annotations[key], want)
+		}
+	}
+
+	output := filepath.Join(dir, "pulled.yaml.gz")
+	if err := PullSnapshot(ctx, client, artifact, output, false); err != nil {
+		t.Fatalf("PullSnapshot() error = %v", err)
+	}
+	want, _ := os.ReadFile(input)
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, want) {
+		t.Error("PullSnapshot() did not write the pushed file as is")
+	}
+	if err := PullSnapshot(ctx, client, artifact, output, false); err == nil {
+		t.Error("PullSnapshot() over an existing file error = nil, want an error")
+	}
+
+	// A blob that does not match its digest is not saved
+	registry.mu.Lock()
+	registry.blobs[artifact.Layer.Digest] = append([]byte{}, want[:len(want)-1]...)
+	registry.mu.Unlock()
+	corrupt := filepath.Join(dir, "corrupt.yaml.gz")
+	if err := PullSnapshot(ctx, client, artifact, corrupt, false); err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Errorf("PullSnapshot() of a corrupt blob error = %v, want a digest error", err)
+	}
+	if _, err := os.Stat(corrupt); err == nil {
+		t.Error("PullSnapshot() saved a corrupt blob")
+	}
+
+	missing, _ := oci.ParseReference("oci://" + host + "/snapshots/prod:v2")
+	if _, err := FetchSnapshotArtifact(ctx, client, missing); !oci.IsNotFound(err) {
+		t.Errorf("FetchSnapshotArtifact() of a missing tag error = %v, want not found", err)
+	}
+
+	anonymous := oci.NewClient()
+	anonymous.Credentials = func(string) (oci.Credentials, error) { return oci.Credentials{}, nil }
+	if _, err := FetchSnapshotArtifact(ctx, anonymous, ref); err == nil || !strings.Contains(err.Error(), "docker login") {
+		t.Errorf("FetchSnapshotArtifact() without credentials error = %v, want a docker login hint", err)
+	}
+}
+
+func TestFetchSnapshotArtifactOtherArtifact(t *testing.T) {
+	registry, host := newFakeRegistry(t)
+	manifest, _ := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeImageManifest,
+		Config:        oci.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json"},
+	})
+	registry.manifests["latest"] = manifest
+
+	ref, _ := oci.ParseReference(host + "/snapshots/prod")
+	if _, err := FetchSnapshotArtifact(context.Background(), newTestOCIClient(), ref); err == nil || !strings.Contains(err.Error(), "not a snapshot") {
+		t.Errorf("FetchSnapshotArtifact() of an image error = %v, want not a snapshot", err)
+	}
+}
@@ -0,0 +1,59 @@
+package meshsync
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultChunkSize is the default number of items requested per list call,
+// matching kubectl's --chunk-size default
+const DefaultChunkSize int64 = 500
+
+// maxListRestarts bounds how often a single list is restarted after its
+// continue token expired
+const maxListRestarts = 3
+
+// listPageFunc fetches a single page of a collection
+type listPageFunc func(ctx context.Context, opts metav1.ListOptions) (metav1.ListInterface, error)
+
+// listAllPages fetches a collection chunkSize items at a time, following
+// continue tokens until the server reports no more pages. If a continue token
+// expires (410 Gone) the list is restarted from a fresh resourceVersion;
+// restart is called first so the caller can discard the items it already
+// collected. It returns the number of restarts that were needed.
+func listAllPages(ctx context.Context, chunkSize int64, listPage listPageFunc, restart func()) (int, error) {
+	if chunkSize < 0 {
+		chunkSize = 0
+	}
+
+	restarts := 0
+	opts := metav1.ListOptions{Limit: chunkSize}
+	for {
+		list, err := listPage(ctx, opts)
+		if err != nil {
+			if opts.Continue != "" && isExpired(err) {
+				if restarts >= maxListRestarts {
+					return restarts, fmt.Errorf("continue token expired %d times: %w", restarts+1, err)
+				}
+				restarts++
+				restart()
+				opts = metav1.ListOptions{Limit: chunkSize}
+				continue
+			}
+			return restarts, err
+		}
+
+		if list.GetContinue() == "" {
+			return restarts, nil
+		}
+		opts.Continue = list.GetContinue()
+	}
+}
+
+// isExpired reports whether err means a continue token is no longer valid
+func isExpired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
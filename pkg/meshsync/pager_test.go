@@ -0,0 +1,158 @@
+package meshsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// pagedList serves items from a fixed collection, using the offset of the
+// next page as the continue token
+type pagedList struct {
+	items    []string
+	calls    []metav1.ListOptions
+	failures map[string]error
+}
+
+func (p *pagedList) listPage(ctx context.Context, opts metav1.ListOptions) (metav1.ListInterface, []string, error) {
+	p.calls = append(p.calls, opts)
+	if err, ok := p.failures[opts.Continue]; ok {
+		delete(p.failures, opts.Continue)
+		return nil, nil, err
+	}
+
+	start := 0
+	if opts.Continue != "" {
+		start, _ = strconv.Atoi(opts.Continue)
+	}
+	end := len(p.items)
+	if opts.Limit > 0 && start+int(opts.Limit) < end {
+		end = start + int(opts.Limit)
+	}
+
+	list := &metav1.List{}
+	if end < len(p.items) {
+		list.Continue = strconv.Itoa(end)
+	}
+	return list, p.items[start:end], nil
+}
+
+func newPagedList(n int) *pagedList {
+	p := &pagedList{failures: map[string]error{}}
+	for i := 0; i < n; i++ {
+		p.items = append(p.items, fmt.Sprintf("item-%d", i))
+	}
+	return p
+}
+
+func TestListAllPages(t *testing.T) {
+	expired := apierrors.NewResourceExpired("continue token expired")
+
+	tests := []struct {
+		name             string
+		items            int
+		chunkSize        int64
+		failures         map[string]error
+		expectedCalls    int
+		expectedRestarts int
+		expectError      bool
+	}{
+		{
+			name:          "Single page",
+			items:         3,
+			chunkSize:     5,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Multiple pages",
+			items:         12,
+			chunkSize:     5,
+			expectedCalls: 3,
+		},
+		{
+			name:          "Paging disabled",
+			items:         12,
+			chunkSize:     0,
+			expectedCalls: 1,
+		},
+		{
+			name:             "Expired continue token restarts",
+			items:            12,
+			chunkSize:        5,
+			failures:         map[string]error{"10": expired},
+			expectedCalls:    6,
+			expectedRestarts: 1,
+		},
+		{
+			name:          "Other errors are returned",
+			items:         12,
+			chunkSize:     5,
+			failures:      map[string]error{"5": apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)},
+			expectedCalls: 2,
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPagedList(tt.items)
+			for token, err := range tt.failures {
+				p.failures[token] = err
+			}
+
+			var collected []string
+			restarts, err := listAllPages(context.Background(), tt.chunkSize, func(ctx context.Context, opts metav1.ListOptions) (metav1.ListInterface, error) {
+				list, items, err := p.listPage(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				collected = append(collected, items...)
+				return list, nil
+			}, func() {
+				collected = nil
+			})
+			if (err != nil) != tt.expectError {
+				t.Fatalf("listAllPages() error = %v, expectError %v", err, tt.expectError)
+			}
+			if len(p.calls) != tt.expectedCalls {
+				t.Errorf("listAllPages() made %d calls, want %d", len(p.calls), tt.expectedCalls)
+			}
+			if restarts != tt.expectedRestarts {
+				t.Errorf("listAllPages() restarts = %d, want %d", restarts, tt.expectedRestarts)
+			}
+			if tt.expectError {
+				return
+			}
+			if len(collected) != tt.items {
+				t.Errorf("listAllPages() collected %d items, want %d", len(collected), tt.items)
+			}
+			for _, call := range p.calls {
+				if call.Limit != tt.chunkSize {
+					t.Errorf("listAllPages() sent limit %d, want %d", call.Limit, tt.chunkSize)
+				}
+			}
+		})
+	}
+}
+
+func TestListAllPagesGivesUp(t *testing.T) {
+	calls := 0
+	_, err := listAllPages(context.Background(), 1, func(ctx context.Context, opts metav1.ListOptions) (metav1.ListInterface, error) {
+		calls++
+		if opts.Continue != "" {
+			return nil, apierrors.NewResourceExpired("continue token expired")
+		}
+		return &metav1.List{ListMeta: metav1.ListMeta{Continue: "next"}}, nil
+	}, func() {})
+	if err == nil {
+		t.Fatal("listAllPages() expected error when the continue token keeps expiring")
+	}
+	if want := 2 * (maxListRestarts + 1); calls != want {
+		t.Errorf("listAllPages() made %d calls, want %d", calls, want)
+	}
+}
@@ -0,0 +1,396 @@
+package meshsync
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RenameOptions changes where and under which names resources are restored
+type RenameOptions struct {
+	// Namespaces maps namespaces in the snapshot to the namespaces their
+	// resources are restored to, e.g. prod to prod-replay
+	Namespaces map[string]string
+	// NamePrefix and NameSuffix are added to the name of every resource
+	// other than namespaces and custom resource definitions
+	NamePrefix string
+	NameSuffix string
+}
+
+// Validate checks that the target namespaces are valid namespace names and
+// that the prefix and suffix can be added to names and label values
+func (o RenameOptions) Validate() error {
+	for from, to := range o.Namespaces {
+		if from == "" {
+			return fmt.Errorf("invalid namespace mapping %q=%q: the source namespace is empty", from, to)
+		}
+		if errs := validation.IsDNS1123Label(to); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace mapping %s=%s: %s", from, to, errs[0])
+		}
+	}
+	if o.NamePrefix != "" || o.NameSuffix != "" {
+		if errs := validation.IsValidLabelValue(o.NamePrefix + "a" + o.NameSuffix); len(errs) > 0 {
+			return fmt.Errorf("invalid name prefix %q or suffix %q: %s", o.NamePrefix, o.NameSuffix, errs[0])
+		}
+	}
+	return nil
+}
+
+// keepsClusterNames reports whether namespaced resources are restored next
+// to the originals while cluster-scoped ones keep their names, so restoring
+// those would replace the originals
+func (o RenameOptions) keepsClusterNames() bool {
+	return len(o.Namespaces) > 0 && o.NamePrefix == "" && o.NameSuffix == ""
+}
+
+// Kinds that references inside other resources point to
+var (
+	serviceAccountKind = schema.GroupKind{Kind: "ServiceAccount"}
+	configMapKind      = schema.GroupKind{Kind: "ConfigMap"}
+	secretKind         = schema.GroupKind{Kind: "Secret"}
+	serviceKind        = schema.GroupKind{Kind: "Service"}
+	pvcKind            = schema.GroupKind{Kind: "PersistentVolumeClaim"}
+	roleKind           = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "Role"}
+	clusterRoleKind    = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}
+)
+
+// cronJobNameMaxLength is the longest CronJob name, leaving room for the
+// suffix of the jobs it creates within a 63 character label value
+const cronJobNameMaxLength = 52
+
+// nameValidators check the names of kinds that do not take any DNS
+// subdomain name of up to 253 characters, as most kinds do
+var nameValidators = map[schema.GroupKind]func(string) []string{
+	{Kind: "Service"}:             validation.IsDNS1035Label,
+	{Group: "batch", Kind: "Job"}: validation.IsDNS1123Label,
+	{Group: "batch", Kind: "CronJob"}: func(name string) []string {
+		errs := validation.IsDNS1123Subdomain(name)
+		if len(name) > cronJobNameMaxLength {
+			errs = append(errs, validation.MaxLenError(cronJobNameMaxLength))
+		}
+		return errs
+	},
+	// RBAC names only need to be valid path segments, as in system:aggregate-to-view
+	roleKind:        path.IsValidPathSegmentName,
+	clusterRoleKind: path.IsValidPathSegmentName,
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}:        path.IsValidPathSegmentName,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}: path.IsValidPathSegmentName,
+}
+
+// validateName checks that name is valid for a resource of kind
+func validateName(kind schema.GroupKind, name string) []string {
+	if validate, ok := nameValidators[kind]; ok {
+		return validate(name)
+	}
+	return validation.IsDNS1123Subdomain(name)
+}
+
+// podSpecPaths are where the pod spec sits in workload kinds
+var podSpecPaths = map[schema.GroupKind][]string{
+	{Kind: "Pod"}:                        {"spec"},
+	{Kind: "ReplicationController"}:      {"spec", "template", "spec"},
+	{Group: "apps", Kind: "ReplicaSet"}:  {"spec", "template", "spec"},
+	{Group: "apps", Kind: "Deployment"}:  {"spec", "template", "spec"},
+	{Group: "apps", Kind: "StatefulSet"}: {"spec", "template", "spec"},
+	{Group: "apps", Kind: "DaemonSet"}:   {"spec", "template", "spec"},
+	{Group: "batch", Kind: "Job"}:        {"spec", "template", "spec"},
+	{Group: "batch", Kind: "CronJob"}:    {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// objectRef identifies a resource of the snapshot by its original namespace and name
+type objectRef struct {
+	kind      schema.GroupKind
+	namespace string
+	name      string
+}
+
+// renamer rewrites resources, and the references between them, according
+// to RenameOptions. References are only renamed when they point to a
+// resource of the snapshot, so references to objects that already exist
+// in the cluster, such as the default service account, keep working.
+type renamer struct {
+	opts RenameOptions
+	// renamed holds the resources whose names get the prefix and suffix
+	renamed map[objectRef]bool
+	// err is set when a renamed label value is not a valid one
+	err error
+}
+
+// newRenamer returns a renamer for the resources of a snapshot
+func newRenamer(resources []Resource, opts RenameOptions) *renamer {
+	r := &renamer{opts: opts, renamed: map[objectRef]bool{}}
+	if !r.renamesNames() {
+		return r
+	}
+	for _, resource := range resources {
+		kind := resource.GroupVersionKind().GroupKind()
+		if kind == (schema.GroupKind{Kind: "Namespace"}) || kind == crdGroupKind {
+			continue
+		}
+		r.renamed[objectRef{kind: kind, namespace: resource.Namespace(), name: resource.Name()}] = true
+	}
+	return r
+}
+
+// renamesNames reports whether names, rather than only namespaces, change
+func (r *renamer) renamesNames() bool {
+	return r.opts.NamePrefix != "" || r.opts.NameSuffix != ""
+}
+
+// namespace returns the namespace a snapshot namespace is restored to
+func (r *renamer) namespace(namespace string) string {
+	if to, ok := r.opts.Namespaces[namespace]; ok {
+		return to
+	}
+	return namespace
+}
+
+// name returns the restored name of a reference to an object of the given
+// kind in the original namespace
+func (r *renamer) name(kind schema.GroupKind, namespace, name string) string {
+	if r.renamed[objectRef{kind: kind, namespace: namespace, name: name}] {
+		return r.opts.NamePrefix + name + r.opts.NameSuffix
+	}
+	return name
+}
+
+// labelValue returns the restored value of a label pods are selected by,
+// so restored services and workloads only select restored pods
+func (r *renamer) labelValue(value string) string {
+	if value == "" || !r.renamesNames() {
+		return value
+	}
+	renamed := r.opts.NamePrefix + value + r.opts.NameSuffix
+	if errs := validation.IsValidLabelValue(renamed); len(errs) > 0 && r.err == nil {
+		r.err = fmt.Errorf("label value %q cannot be renamed to %q: %s", value, renamed, errs[0])
+	}
+	return renamed
+}
+
+// rename returns a renamed copy of a resource. The resource itself is not
+// modified. An error is returned if the renamed name, or a renamed label
+// value, is not valid, such as one over the length limit of its kind.
+func (r *renamer) rename(resource Resource) (Resource, error) {
+	kind := resource.GroupVersionKind().GroupKind()
+	namespace, name := resource.Namespace(), resource.Name()
+	if kind != (schema.GroupKind{Kind: "Namespace"}) {
+		if renamed := r.name(kind, namespace, name); renamed != name {
+			if errs := validateName(kind, renamed); len(errs) > 0 {
+				return Resource{}, fmt.Errorf("failed to rename %s %s to %s: %s", resource.Kind(), name, renamed, errs[0])
+			}
+		}
+	}
+
+	renamed := NewResource(deepCopyValue(resource.Object).(map[string]interface{}))
+	if metadata := renamed.Metadata(); metadata != nil {
+		if kind == (schema.GroupKind{Kind: "Namespace"}) {
+			metadata["name"] = r.namespace(name)
+		} else {
+			metadata["name"] = r.name(kind, namespace, name)
+		}
+		if namespace != "" {
+			metadata["namespace"] = r.namespace(namespace)
+		}
+	}
+
+	switch kind {
+	case schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"},
+		schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:
+		r.renameBinding(renamed, namespace)
+	case serviceKind:
+		r.renameLabelValues(renamed.Object, "spec", "selector")
+	case schema.GroupKind{Group: "networking.k8s.io", Kind: "Ingress"}:
+		r.renameIngress(renamed, namespace)
+	case serviceAccountKind:
+		r.renameRefList(renamed.Object["secrets"], secretKind, namespace)
+		r.renameRefList(renamed.Object["imagePullSecrets"], secretKind, namespace)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		r.renameField(renamed.Object, serviceKind, namespace, "spec", "serviceName")
+	}
+
+	if path, ok := podSpecPaths[kind]; ok {
+		r.renameWorkload(renamed, path, namespace)
+	}
+	if r.err != nil {
+		return Resource{}, fmt.Errorf("failed to rename %s %s: %w", resource.Kind(), name, r.err)
+	}
+	return renamed, nil
+}
+
+// renameBinding rewrites the role and service account subjects of a
+// RoleBinding or ClusterRoleBinding
+func (r *renamer) renameBinding(binding Resource, namespace string) {
+	if roleRef, ok := binding.Object["roleRef"].(map[string]interface{}); ok {
+		switch roleRef["kind"] {
+		case "Role":
+			r.renameField(roleRef, roleKind, namespace, "name")
+		case "ClusterRole":
+			r.renameField(roleRef, clusterRoleKind, "", "name")
+		}
+	}
+
+	subjects, _ := binding.Object["subjects"].([]interface{})
+	for _, item := range subjects {
+		subject, ok := item.(map[string]interface{})
+		if !ok || subject["kind"] != "ServiceAccount" {
+			continue
+		}
+		subjectNamespace, _ := subject["namespace"].(string)
+		if subjectNamespace == "" {
+			subjectNamespace = namespace
+		}
+		r.renameField(subject, serviceAccountKind, subjectNamespace, "name")
+		if _, ok := subject["namespace"].(string); ok {
+			subject["namespace"] = r.namespace(subjectNamespace)
+		}
+	}
+}
+
+// renameIngress rewrites the backend services and TLS secrets of an Ingress
+func (r *renamer) renameIngress(ingress Resource, namespace string) {
+	r.renameField(ingress.Object, serviceKind, namespace, "spec", "defaultBackend", "service", "name")
+
+	rules, _ := ingress.Field("spec", "rules")
+	for _, rule := range asList(rules) {
+		paths, _ := NewResource(asMap(rule)).Field("http", "paths")
+		for _, path := range asList(paths) {
+			r.renameField(asMap(path), serviceKind, namespace, "backend", "service", "name")
+		}
+	}
+
+	tls, _ := ingress.Field("spec", "tls")
+	for _, item := range asList(tls) {
+		r.renameField(asMap(item), secretKind, namespace, "secretName")
+	}
+}
+
+// renameWorkload rewrites the pod spec of a workload at path and the
+// labels its pods are selected by
+func (r *renamer) renameWorkload(workload Resource, path []string, namespace string) {
+	spec, _ := workload.Field(path...)
+	if podSpec := asMap(spec); podSpec != nil {
+		r.renamePodSpec(podSpec, namespace)
+	}
+
+	// The pod labels sit next to the pod spec, and the selector next to the template
+	templatePath := path[:len(path)-1]
+	if len(templatePath) == 0 {
+		r.renameLabelValues(workload.Object, "metadata", "labels")
+		return
+	}
+	r.renameLabelValues(workload.Object, append(append([]string{}, templatePath...), "metadata", "labels")...)
+
+	selectorPath := append(append([]string{}, templatePath[:len(templatePath)-1]...), "selector")
+	if workload.GroupVersionKind().GroupKind() == (schema.GroupKind{Kind: "ReplicationController"}) {
+		r.renameLabelValues(workload.Object, selectorPath...)
+		return
+	}
+	r.renameLabelValues(workload.Object, append(selectorPath, "matchLabels")...)
+	expressions, _ := workload.Field(append(selectorPath, "matchExpressions")...)
+	for _, item := range asList(expressions) {
+		values := asList(asMap(item)["values"])
+		for i, value := range values {
+			if s, ok := value.(string); ok {
+				values[i] = r.labelValue(s)
+			}
+		}
+	}
+}
+
+// renamePodSpec rewrites the service account, config maps, secrets and
+// volume claims a pod spec refers to
+func (r *renamer) renamePodSpec(spec map[string]interface{}, namespace string) {
+	r.renameField(spec, serviceAccountKind, namespace, "serviceAccountName")
+	r.renameField(spec, serviceAccountKind, namespace, "serviceAccount")
+	r.renameRefList(spec["imagePullSecrets"], secretKind, namespace)
+
+	for _, item := range asList(spec["volumes"]) {
+		volume := asMap(item)
+		r.renameField(volume, configMapKind, namespace, "configMap", "name")
+		r.renameField(volume, secretKind, namespace, "secret", "secretName")
+		r.renameField(volume, pvcKind, namespace, "persistentVolumeClaim", "claimName")
+		projected, _ := NewResource(volume).Field("projected", "sources")
+		for _, source := range asList(projected) {
+			r.renameField(asMap(source), configMapKind, namespace, "configMap", "name")
+			r.renameField(asMap(source), secretKind, namespace, "secret", "name")
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, item := range asList(spec[field]) {
+			container := asMap(item)
+			for _, source := range asList(container["envFrom"]) {
+				r.renameField(asMap(source), configMapKind, namespace, "configMapRef", "name")
+				r.renameField(asMap(source), secretKind, namespace, "secretRef", "name")
+			}
+			for _, env := range asList(container["env"]) {
+				r.renameField(asMap(env), configMapKind, namespace, "valueFrom", "configMapKeyRef", "name")
+				r.renameField(asMap(env), secretKind, namespace, "valueFrom", "secretKeyRef", "name")
+			}
+		}
+	}
+}
+
+// renameField renames the reference to an object of kind at path in object
+func (r *renamer) renameField(object map[string]interface{}, kind schema.GroupKind, namespace string, path ...string) {
+	parent := object
+	for _, field := range path[:len(path)-1] {
+		parent = asMap(parent[field])
+	}
+	field := path[len(path)-1]
+	if name, ok := parent[field].(string); ok {
+		parent[field] = r.name(kind, namespace, name)
+	}
+}
+
+// renameRefList renames a list of local object references, such as imagePullSecrets
+func (r *renamer) renameRefList(refs interface{}, kind schema.GroupKind, namespace string) {
+	for _, ref := range asList(refs) {
+		r.renameField(asMap(ref), kind, namespace, "name")
+	}
+}
+
+// renameLabelValues rewrites the values of the label map at path in object
+func (r *renamer) renameLabelValues(object map[string]interface{}, path ...string) {
+	value, _ := NewResource(object).Field(path...)
+	labels := asMap(value)
+	for key, value := range labels {
+		if s, ok := value.(string); ok {
+			labels[key] = r.labelValue(s)
+		}
+	}
+}
+
+// asMap returns value as an object, or nil if it is not one
+func asMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+// asList returns value as a list, or nil if it is not one
+func asList(value interface{}) []interface{} {
+	l, _ := value.([]interface{})
+	return l
+}
+
+// deepCopyValue copies the maps and lists of a decoded object
+func deepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = deepCopyValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = deepCopyValue(item)
+		}
+		return l
+	default:
+		return value
+	}
+}
@@ -0,0 +1,311 @@
+package meshsync
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newRenameSnapshot returns resources in namespace prod that refer to each
+// other, and to objects that are not in the snapshot
+func newRenameSnapshot() []Resource {
+	podSpec := map[string]interface{}{
+		"serviceAccountName": "web",
+		"imagePullSecrets":   []interface{}{map[string]interface{}{"name": "registry"}},
+		"containers": []interface{}{
+			map[string]interface{}{
+				"name":    "web",
+				"envFrom": []interface{}{map[string]interface{}{"configMapRef": map[string]interface{}{"name": "web-config"}}},
+				"env": []interface{}{
+					map[string]interface{}{"name": "TOKEN", "valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "web-token", "key": "token"}}},
+					map[string]interface{}{"name": "CA", "valueFrom": map[string]interface{}{"configMapKeyRef": map[string]interface{}{"name": "kube-root-ca.crt", "key": "ca.crt"}}},
+				},
+			},
+		},
+		"volumes": []interface{}{
+			map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "web-config"}},
+			map[string]interface{}{"name": "token", "secret": map[string]interface{}{"secretName": "web-token"}},
+		},
+	}
+
+	return []Resource{
+		NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": "prod"},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod"},
+			"secrets":    []interface{}{map[string]interface{}{"name": "web-token"}},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "web-config", "namespace": "prod"},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "web-token", "namespace": "prod"},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata":   map[string]interface{}{"name": "reader", "namespace": "prod"},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata":   map[string]interface{}{"name": "web-reader", "namespace": "prod"},
+			"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "Role", "name": "reader"},
+			"subjects": []interface{}{
+				map[string]interface{}{"kind": "ServiceAccount", "name": "web", "namespace": "prod"},
+				map[string]interface{}{"kind": "ServiceAccount", "name": "monitor", "namespace": "ops"},
+				map[string]interface{}{"kind": "User", "name": "web"},
+			},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]interface{}{"name": "web-view"},
+			"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "view"},
+			"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "web", "namespace": "prod"}},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod"},
+			"spec":       map[string]interface{}{"selector": map[string]interface{}{"app": "web"}},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "Ingress",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod"},
+			"spec": map[string]interface{}{
+				"defaultBackend": map[string]interface{}{"service": map[string]interface{}{"name": "web"}},
+				"rules": []interface{}{
+					map[string]interface{}{"http": map[string]interface{}{"paths": []interface{}{
+						map[string]interface{}{"path": "/", "backend": map[string]interface{}{"service": map[string]interface{}{"name": "web"}}},
+						map[string]interface{}{"path": "/legacy", "backend": map[string]interface{}{"service": map[string]interface{}{"name": "legacy"}}},
+					}}},
+				},
+				"tls": []interface{}{map[string]interface{}{"secretName": "web-token"}},
+			},
+		}),
+		NewResource(map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod", "labels": map[string]interface{}{"app": "web"}},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "web"}},
+					"spec":     podSpec,
+				},
+			},
+		}),
+	}
+}
+
+// renamedObject returns the renamed resource of the given kind
+func renamedObject(t *testing.T, resources []Resource, kind string) map[string]interface{} {
+	t.Helper()
+	for _, resource := range resources {
+		if resource.Kind() == kind {
+			return resource.Object
+		}
+	}
+	t.Fatalf("No %s in renamed resources", kind)
+	return nil
+}
+
+func TestRenameResources(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RenameOptions
+		// want maps kind and dotted path to the expected value
+		want map[string]interface{}
+	}{
+		{
+			name: "Namespace mapping only",
+			opts: RenameOptions{Namespaces: map[string]string{"prod": "prod-replay"}},
+			want: map[string]interface{}{
+				"Namespace metadata.name":                      "prod-replay",
+				"ConfigMap metadata.name":                      "web-config",
+				"ConfigMap metadata.namespace":                 "prod-replay",
+				"ClusterRoleBinding metadata.namespace":        nil,
+				"ClusterRoleBinding subjects.0.namespace":      "prod-replay",
+				"RoleBinding subjects.0.namespace":             "prod-replay",
+				"RoleBinding subjects.1.namespace":             "ops",
+				"Service spec.selector.app":                    "web",
+				"Deployment spec.template.metadata.labels.app": "web",
+			},
+		},
+		{
+			name: "Namespace mapping with prefix and suffix",
+			opts: RenameOptions{Namespaces: map[string]string{"prod": "staging"}, NamePrefix: "replay-", NameSuffix: "-1"},
+			want: map[string]interface{}{
+				"Namespace metadata.name":                                                         "staging",
+				"ConfigMap metadata.name":                                                         "replay-web-config-1",
+				"ConfigMap metadata.namespace":                                                    "staging",
+				"ServiceAccount secrets.0.name":                                                   "replay-web-token-1",
+				"RoleBinding metadata.name":                                                       "replay-web-reader-1",
+				"RoleBinding roleRef.name":                                                        "replay-reader-1",
+				"RoleBinding subjects.0.name":                                                     "replay-web-1",
+				"RoleBinding subjects.0.namespace":                                                "staging",
+				"RoleBinding subjects.1.name":                                                     "monitor",
+				"RoleBinding subjects.2.name":                                                     "web",
+				"ClusterRoleBinding metadata.name":                                                "replay-web-view-1",
+				"ClusterRoleBinding roleRef.name":                                                 "view",
+				"ClusterRoleBinding subjects.0.name":                                              "replay-web-1",
+				"Service metadata.name":                                                           "replay-web-1",
+				"Service spec.selector.app":                                                       "replay-web-1",
+				"Ingress spec.defaultBackend.service.name":                                        "replay-web-1",
+				"Ingress spec.rules.0.http.paths.0.backend.service.name":                          "replay-web-1",
+				"Ingress spec.rules.0.http.paths.1.backend.service.name":                          "legacy",
+				"Ingress spec.tls.0.secretName":                                                   "replay-web-token-1",
+				"Deployment metadata.labels.app":                                                  "web",
+				"Deployment spec.selector.matchLabels.app":                                        "replay-web-1",
+				"Deployment spec.template.metadata.labels.app":                                    "replay-web-1",
+				"Deployment spec.template.spec.serviceAccountName":                                "replay-web-1",
+				"Deployment spec.template.spec.imagePullSecrets.0.name":                           "registry",
+				"Deployment spec.template.spec.containers.0.envFrom.0.configMapRef.name":          "replay-web-config-1",
+				"Deployment spec.template.spec.containers.0.env.0.valueFrom.secretKeyRef.name":    "replay-web-token-1",
+				"Deployment spec.template.spec.containers.0.env.1.valueFrom.configMapKeyRef.name": "kube-root-ca.crt",
+				"Deployment spec.template.spec.volumes.0.configMap.name":                          "replay-web-config-1",
+				"Deployment spec.template.spec.volumes.1.secret.secretName":                       "replay-web-token-1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources := newRenameSnapshot()
+			original := newRenameSnapshot()
+			r := newRenamer(resources, tt.opts)
+
+			var renamed []Resource
+			for _, resource := range resources {
+				object, err := r.rename(resource)
+				if err != nil {
+					t.Fatalf("rename() error = %v", err)
+				}
+				renamed = append(renamed, object)
+			}
+			if !reflect.DeepEqual(resources, original) {
+				t.Errorf("Renaming modified the snapshot resources")
+			}
+
+			for key, want := range tt.want {
+				kind, path, _ := strings.Cut(key, " ")
+				got := lookupPath(renamedObject(t, renamed, kind), strings.Split(path, "."))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+// lookupPath follows a dotted path through maps and lists, where list
+// elements are addressed by index
+func lookupPath(value interface{}, path []string) interface{} {
+	for _, field := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func TestRenameOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RenameOptions
+		wantErr string
+	}{
+		{name: "Valid", opts: RenameOptions{Namespaces: map[string]string{"prod": "prod-replay"}}},
+		{name: "Empty source", opts: RenameOptions{Namespaces: map[string]string{"": "prod-replay"}}, wantErr: "source namespace is empty"},
+		{name: "Invalid target", opts: RenameOptions{Namespaces: map[string]string{"prod": "Prod_Replay"}}, wantErr: "invalid namespace mapping prod=Prod_Replay"},
+		{name: "Valid prefix", opts: RenameOptions{NamePrefix: "replay-", NameSuffix: "-1"}},
+		{name: "Invalid prefix", opts: RenameOptions{NamePrefix: "-replay"}, wantErr: `invalid name prefix "-replay"`},
+		{name: "Prefix too long", opts: RenameOptions{NamePrefix: strings.Repeat("r", 63)}, wantErr: "must be no more than 63 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenameNameTooLong(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		kind       string
+		name       string
+		wantErr    string
+	}{
+		{apiVersion: "v1", kind: "Service", name: strings.Repeat("s", 57), wantErr: "63 characters"},
+		{apiVersion: "v1", kind: "Service", name: strings.Repeat("s", 56)},
+		{apiVersion: "batch/v1", kind: "Job", name: strings.Repeat("j", 57), wantErr: "63 characters"},
+		{apiVersion: "batch/v1", kind: "CronJob", name: strings.Repeat("c", 46), wantErr: "52 characters"},
+		{apiVersion: "batch/v1", kind: "CronJob", name: strings.Repeat("c", 45)},
+		{apiVersion: "apps/v1", kind: "Deployment", name: strings.Repeat("d", 56)},
+		{apiVersion: "apps/v1", kind: "Deployment", name: strings.Repeat("d", 247), wantErr: "253 characters"},
+		{apiVersion: "rbac.authorization.k8s.io/v1", kind: "ClusterRole", name: "system:aggregate-to-view"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.kind, len(tt.name)), func(t *testing.T) {
+			resource := NewResource(map[string]interface{}{
+				"apiVersion": tt.apiVersion,
+				"kind":       tt.kind,
+				"metadata":   map[string]interface{}{"name": tt.name, "namespace": "shop"},
+			})
+			r := newRenamer([]Resource{resource}, RenameOptions{NamePrefix: "replay-", Namespaces: map[string]string{"shop": "shop-replay"}})
+			_, err := r.rename(resource)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("rename() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("rename() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenameLabelValueTooLong(t *testing.T) {
+	resources := newRenameSnapshot()
+	long := strings.Repeat("w", 60)
+	deployment := resources[len(resources)-1]
+	deployment.Object["spec"].(map[string]interface{})["selector"] = map[string]interface{}{"matchLabels": map[string]interface{}{"app": long}}
+
+	r := newRenamer(resources, RenameOptions{NamePrefix: "replay-"})
+	if _, err := r.rename(resources[0]); err != nil {
+		t.Fatalf("rename() of %s error = %v", resources[0].Kind(), err)
+	}
+	_, err := r.rename(deployment)
+	if err == nil || !strings.Contains(err.Error(), "replay-"+long) || !strings.Contains(err.Error(), "63 characters") {
+		t.Errorf("rename() error = %v, want the label value over 63 characters", err)
+	}
+}
@@ -0,0 +1,169 @@
+package meshsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// Resource represents a kubernetes resource in the snapshot. It holds the
+// full object as returned by the API server, so kinds with top-level fields
+// other than spec and status, such as ConfigMap data, Role rules or custom
+// resources, are kept whole. It is encoded as the object itself.
+type Resource struct {
+	Object map[string]interface{}
+}
+
+// NewResource wraps a Kubernetes object
+func NewResource(object map[string]interface{}) Resource {
+	return Resource{Object: object}
+}
+
+// APIVersion returns the apiVersion of the object
+func (r Resource) APIVersion() string {
+	apiVersion, _, _ := unstructured.NestedString(r.Object, "apiVersion")
+	return apiVersion
+}
+
+// Kind returns the kind of the object
+func (r Resource) Kind() string {
+	kind, _, _ := unstructured.NestedString(r.Object, "kind")
+	return kind
+}
+
+// GroupVersionKind returns the parsed apiVersion and kind of the object
+func (r Resource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion(), r.Kind())
+}
+
+// Name returns metadata.name
+func (r Resource) Name() string {
+	name, _, _ := unstructured.NestedString(r.Object, "metadata", "name")
+	return name
+}
+
+// Namespace returns metadata.namespace, empty for cluster-scoped objects
+func (r Resource) Namespace() string {
+	namespace, _, _ := unstructured.NestedString(r.Object, "metadata", "namespace")
+	return namespace
+}
+
+// Metadata returns the metadata object, or nil if there is none
+func (r Resource) Metadata() map[string]interface{} {
+	return r.nestedMap("metadata")
+}
+
+// Spec returns the spec object, or nil if there is none
+func (r Resource) Spec() map[string]interface{} {
+	return r.nestedMap("spec")
+}
+
+// Status returns the status object, or nil if there is none
+func (r Resource) Status() map[string]interface{} {
+	return r.nestedMap("status")
+}
+
+// Field returns the value at the given path in the object
+func (r Resource) Field(fields ...string) (interface{}, bool) {
+	value, found, err := unstructured.NestedFieldNoCopy(r.Object, fields...)
+	return value, found && err == nil
+}
+
+// Unstructured returns the object for use with client-go. The returned
+// object shares its fields with the resource.
+func (r Resource) Unstructured() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: r.Object}
+}
+
+// nestedMap returns the top-level field as a map without copying it
+func (r Resource) nestedMap(field string) map[string]interface{} {
+	m, _ := r.Object[field].(map[string]interface{})
+	return m
+}
+
+// MarshalJSON encodes the resource as the Kubernetes object
+func (r Resource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Object)
+}
+
+// UnmarshalJSON decodes a Kubernetes object. Numbers decode to int64 or
+// float64 as they do in unstructured objects, so integers beyond 2^53 are
+// kept exactly.
+func (r *Resource) UnmarshalJSON(data []byte) error {
+	r.Object = nil
+	return utiljson.Unmarshal(data, &r.Object)
+}
+
+// MarshalYAML encodes the resource as the Kubernetes object, with apiVersion,
+// kind and metadata first and spec and status last, as kubectl shows them
+func (r Resource) MarshalYAML() (interface{}, error) {
+	object := yaml.MapSlice{}
+	for _, key := range []string{"apiVersion", "kind", "metadata"} {
+		if value, ok := r.Object[key]; ok {
+			object = append(object, yaml.MapItem{Key: key, Value: value})
+		}
+	}
+
+	keys := make([]string, 0, len(r.Object))
+	for key := range r.Object {
+		switch key {
+		case "apiVersion", "kind", "metadata", "spec", "status":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		object = append(object, yaml.MapItem{Key: key, Value: r.Object[key]})
+	}
+
+	for _, key := range []string{"spec", "status"} {
+		if value, ok := r.Object[key]; ok {
+			object = append(object, yaml.MapItem{Key: key, Value: value})
+		}
+	}
+	return object, nil
+}
+
+// UnmarshalYAML decodes a Kubernetes object
+func (r *Resource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var object map[string]interface{}
+	if err := unmarshal(&object); err != nil {
+		return err
+	}
+	for key, value := range object {
+		object[key] = normalizeYAML(value)
+	}
+	r.Object = object
+	return nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values gopkg.in/yaml.v2
+// decodes objects to into map[string]interface{}, as JSON decoding gives
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return m
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeYAML(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
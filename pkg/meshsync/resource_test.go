@@ -0,0 +1,170 @@
+package meshsync
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newRoundTripObjects returns objects of kinds with top-level fields other
+// than spec and status
+func newRoundTripObjects() []runtime.Object {
+	return []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"},
+			Data:       map[string]string{"mode": "fast"},
+			BinaryData: map[string][]byte{"blob": []byte{0, 1, 2}},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default"},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+		},
+		&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "default"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "default"},
+			Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: "app", Namespace: "default"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: "reader"},
+		},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Secrets:          []corev1.ObjectReference{{Name: "app-token"}},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "app.1", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app", Namespace: "default"},
+			Reason:         "Started",
+			Message:        "Started container app",
+			Type:           corev1.EventTypeNormal,
+			Count:          3,
+		},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Gizmo",
+			"metadata":   map[string]interface{}{"name": "gadget", "namespace": "default"},
+			"config":     map[string]interface{}{"size": int64(3), "colors": []interface{}{"red", "blue"}},
+			"enabled":    true,
+		}},
+	}
+}
+
+func TestResourceRoundTrip(t *testing.T) {
+	client, _ := newFakeCaptureClient(newRoundTripObjects()...)
+	captured, err := CaptureSnapshot(context.Background(), client, CaptureOptions{
+		Namespace: "default",
+		Kinds:     []string{"configmaps", "secrets", "roles", "rolebindings", "serviceaccounts", "events", "gizmos"},
+	})
+	if err != nil {
+		t.Fatalf("CaptureSnapshot() error = %v", err)
+	}
+	if len(captured.Resources) != 7 {
+		t.Fatalf("CaptureSnapshot() captured %v, want 7 resources", resourceNames(captured))
+	}
+
+	// Fields outside metadata, spec and status are captured
+	expectedFields := map[string][]string{
+		"ConfigMap":      {"data", "binaryData"},
+		"Secret":         {"data", "type"},
+		"Role":           {"rules"},
+		"RoleBinding":    {"subjects", "roleRef"},
+		"ServiceAccount": {"secrets", "imagePullSecrets"},
+		"Event":          {"involvedObject", "reason", "message", "type", "count"},
+		"Gizmo":          {"config", "enabled"},
+	}
+	for _, resource := range captured.Resources {
+		for _, field := range expectedFields[resource.Kind()] {
+			if _, ok := resource.Field(field); !ok {
+				t.Errorf("Captured %s is missing %s", resource.Kind(), field)
+			}
+		}
+	}
+
+	// Every format decodes back to the same objects
+	expected := jsonObjects(t, captured.Resources)
+	for _, format := range []string{FormatYAML, FormatYAMLStream, FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			decoded, err := DecodeSnapshot(encodeSnapshot(t, captured, format))
+			if err != nil {
+				t.Fatalf("DecodeSnapshot() error = %v", err)
+			}
+			got := jsonObjects(t, decoded.Resources)
+			for i := range expected {
+				if i >= len(got) || !reflect.DeepEqual(got[i], expected[i]) {
+					t.Errorf("Resource %d = %v, want %v", i, got, expected[i])
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestResourceLargeIntegers(t *testing.T) {
+	// Above 2^53, where float64 can no longer hold every integer
+	const large = int64(9007199254740993)
+	snapshot := newTestSnapshot(1, nil)
+	snapshot.Resources[0].Object["spec"].(map[string]interface{})["generation"] = large
+
+	for _, format := range []string{FormatYAML, FormatYAMLStream, FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			decoded, err := DecodeSnapshot(encodeSnapshot(t, snapshot, format))
+			if err != nil {
+				t.Fatalf("DecodeSnapshot() error = %v", err)
+			}
+			got, found, err := unstructured.NestedInt64(decoded.Resources[0].Object, "spec", "generation")
+			if err != nil || !found || got != large {
+				t.Errorf("Decoded spec.generation = %v, %v, %v, want %d", got, found, err, large)
+			}
+		})
+	}
+}
+
+func TestResourceAccessors(t *testing.T) {
+	resource := NewResource(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec":       map[string]interface{}{"replicas": int64(2)},
+	})
+
+	if resource.APIVersion() != "apps/v1" || resource.Kind() != "Deployment" || resource.Name() != "web" || resource.Namespace() != "default" {
+		t.Errorf("Accessors = %s %s %s/%s, want apps/v1 Deployment default/web", resource.APIVersion(), resource.Kind(), resource.Namespace(), resource.Name())
+	}
+	if resource.Spec()["replicas"] != int64(2) || resource.Status() != nil {
+		t.Errorf("Spec = %v and status = %v, want 2 replicas and no status", resource.Spec(), resource.Status())
+	}
+	if replicas, ok := resource.Field("spec", "replicas"); !ok || replicas != int64(2) {
+		t.Errorf("Field(spec, replicas) = %v, %v, want 2", replicas, ok)
+	}
+	if _, ok := resource.Field("spec", "missing"); ok {
+		t.Errorf("Field(spec, missing) found, want not found")
+	}
+	if resource.Unstructured().GetName() != "web" {
+		t.Errorf("Unstructured().GetName() = %s, want web", resource.Unstructured().GetName())
+	}
+}
+
+// jsonObjects returns the resources as decoded JSON, so that objects
+// captured with int64 values compare equal to decoded ones
+func jsonObjects(t *testing.T, resources []Resource) []interface{} {
+	t.Helper()
+	data, err := json.Marshal(resources)
+	if err != nil {
+		t.Fatalf("Failed to marshal resources: %v", err)
+	}
+	var objects []interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		t.Fatalf("Failed to unmarshal resources: %v", err)
+	}
+	return objects
+}
@@ -0,0 +1,391 @@
+package meshsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// DefaultFieldManager is the field manager resources are restored with
+const DefaultFieldManager = "kubectl-meshsync-snapshot"
+
+// DefaultCRDTimeout is how long a restore waits for the custom resource
+// definitions it applied to be served before giving up on their resources
+const DefaultCRDTimeout = 30 * time.Second
+
+// restorePhases orders the kinds a restore applies first, so that every
+// resource can find what it refers to. Kinds that are not listed, such as
+// services or custom resources, are applied after secrets and before workloads.
+var restorePhases = [][]schema.GroupKind{
+	{{Kind: "Namespace"}},
+	{crdGroupKind},
+	{
+		{Kind: "ServiceAccount"},
+		{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+		{Group: "rbac.authorization.k8s.io", Kind: "Role"},
+		{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"},
+		{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"},
+	},
+	{{Kind: "ConfigMap"}, {Kind: "Secret"}},
+	nil,
+	{
+		{Kind: "Pod"},
+		{Kind: "ReplicationController"},
+		{Group: "apps", Kind: "ReplicaSet"},
+		{Group: "apps", Kind: "Deployment"},
+		{Group: "apps", Kind: "StatefulSet"},
+		{Group: "apps", Kind: "DaemonSet"},
+		{Group: "batch", Kind: "Job"},
+		{Group: "batch", Kind: "CronJob"},
+	},
+}
+
+// crdGroupKind is the kind of custom resource definitions
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// SkippedClusterScoped is why cluster-scoped resources, other than
+// namespaces and custom resource definitions, are skipped when namespaces
+// are mapped without a name prefix or suffix: under their own names they
+// would replace the originals
+const SkippedClusterScoped = "cluster-scoped, would replace the original without a name prefix or suffix"
+
+// restoreDefaultPhase is the phase of kinds not listed in restorePhases
+const restoreDefaultPhase = 4
+
+// RestoreOptions contains options for restoring a snapshot
+type RestoreOptions struct {
+	// FieldManager owns the fields the restore applies; DefaultFieldManager
+	// is used when empty
+	FieldManager string
+	// DryRun sends every apply as a server-side dry run, so nothing is persisted
+	DryRun bool
+	// PruneStatus leaves the captured status out; otherwise it is applied
+	// to the status subresource of kinds that have one
+	PruneStatus bool
+	// Rename maps namespaces and renames resources, and the references
+	// between them, before they are applied
+	Rename RenameOptions
+	// CRDTimeout is how long to wait for applied custom resource
+	// definitions to be served; DefaultCRDTimeout is used when 0
+	CRDTimeout time.Duration
+	// ForceConflicts takes over fields owned by other field managers;
+	// otherwise resources with such fields fail with a conflict
+	ForceConflicts bool
+}
+
+// RestoreResult records what happened to one resource of the snapshot
+type RestoreResult struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// Skipped is why the resource was not applied, if it was skipped
+	Skipped string
+	// Err is why the resource failed to apply, if it failed
+	Err error
+	// Conflict is set when the resource failed because fields it sets are
+	// owned by another field manager
+	Conflict bool
+}
+
+// RestoreReport lists the outcome for every resource of a restored snapshot
+// in the order they were applied
+type RestoreReport struct {
+	Results []RestoreResult
+}
+
+// Applied returns the number of resources that were applied
+func (r *RestoreReport) Applied() int {
+	applied := 0
+	for _, result := range r.Results {
+		if result.Skipped == "" && result.Err == nil {
+			applied++
+		}
+	}
+	return applied
+}
+
+// Skipped returns the resources that were not applied on purpose
+func (r *RestoreReport) Skipped() []RestoreResult {
+	var skipped []RestoreResult
+	for _, result := range r.Results {
+		if result.Skipped != "" {
+			skipped = append(skipped, result)
+		}
+	}
+	return skipped
+}
+
+// Failed returns the resources that failed to apply
+func (r *RestoreReport) Failed() []RestoreResult {
+	var failed []RestoreResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Conflicts returns the resources that failed because another field
+// manager owns fields they set
+func (r *RestoreReport) Conflicts() []RestoreResult {
+	var conflicts []RestoreResult
+	for _, result := range r.Results {
+		if result.Conflict {
+			conflicts = append(conflicts, result)
+		}
+	}
+	return conflicts
+}
+
+// RestoreSnapshot applies the resources of a snapshot to the cluster with
+// server-side apply, in dependency order: namespaces, custom resource
+// definitions, RBAC, config maps and secrets, then everything else, then
+// workloads, after renaming them according to opts.Rename. Fields owned by
+// other field managers are only taken over with opts.ForceConflicts.
+// Resources that fail to apply are recorded in the report and do not stop
+// the restore; an error is only returned if the restore could not run at all
+// or ctx was cancelled.
+func RestoreSnapshot(ctx context.Context, client *kube.Client, snapshot *Snapshot, opts RestoreOptions) (*RestoreReport, error) {
+	if opts.FieldManager == "" {
+		opts.FieldManager = DefaultFieldManager
+	}
+	if opts.CRDTimeout == 0 {
+		opts.CRDTimeout = DefaultCRDTimeout
+	}
+	if err := opts.Rename.Validate(); err != nil {
+		return nil, err
+	}
+
+	applyOpts := metav1.ApplyOptions{FieldManager: opts.FieldManager, Force: opts.ForceConflicts}
+	if opts.DryRun {
+		applyOpts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	r := &restorer{
+		client:    client,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery)),
+		opts:      opts,
+		applyOpts: applyOpts,
+	}
+
+	// Everything is renamed before the first apply, so an invalid name
+	// fails the restore before it changes the cluster
+	renamer := newRenamer(snapshot.Resources, opts.Rename)
+	resources := make([]Resource, 0, len(snapshot.Resources))
+	for _, resource := range snapshot.Resources {
+		renamed, err := renamer.rename(resource)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, renamed)
+	}
+
+	report := &RestoreReport{}
+	for _, resource := range sortForRestore(resources) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Results = append(report.Results, r.restore(ctx, resource))
+	}
+	return report, nil
+}
+
+// sortForRestore returns the resources in the order they are applied,
+// keeping the snapshot order within each phase
+func sortForRestore(resources []Resource) []Resource {
+	phases := map[schema.GroupKind]int{}
+	for phase, kinds := range restorePhases {
+		for _, kind := range kinds {
+			phases[kind] = phase
+		}
+	}
+	phase := func(resource Resource) int {
+		if p, ok := phases[resource.GroupVersionKind().GroupKind()]; ok {
+			return p
+		}
+		return restoreDefaultPhase
+	}
+
+	sorted := make([]Resource, len(resources))
+	copy(sorted, resources)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return phase(sorted[i]) < phase(sorted[j])
+	})
+	return sorted
+}
+
+// restorer applies resources one at a time
+type restorer struct {
+	client    *kube.Client
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+	opts      RestoreOptions
+	applyOpts metav1.ApplyOptions
+	// appliedCRDs is set once a custom resource definition has been
+	// applied, after which unknown kinds are waited for
+	appliedCRDs bool
+}
+
+// restore applies a single resource and its status
+func (r *restorer) restore(ctx context.Context, resource Resource) RestoreResult {
+	gvk := resource.GroupVersionKind()
+	result := RestoreResult{
+		APIVersion: resource.APIVersion(),
+		Kind:       resource.Kind(),
+		Namespace:  resource.Namespace(),
+		Name:       resource.Name(),
+	}
+
+	if owner := controllerOf(resource); owner != "" {
+		result.Skipped = fmt.Sprintf("managed by %s", owner)
+		return result
+	}
+
+	mapping, err := r.restMapping(ctx, gvk)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	namespace := ""
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace = result.Namespace
+	} else if r.opts.Rename.keepsClusterNames() && gvk.GroupKind() != (schema.GroupKind{Kind: "Namespace"}) && gvk.GroupKind() != crdGroupKind {
+		result.Skipped = SkippedClusterScoped
+		return result
+	}
+	resourceClient := r.client.Dynamic.Resource(mapping.Resource).Namespace(namespace)
+
+	object := restoreObject(resource)
+	if _, err := resourceClient.Apply(ctx, result.Name, object.Unstructured(), r.applyOpts); err != nil {
+		result.Err = err
+		result.Conflict = apierrors.IsConflict(err)
+		return result
+	}
+	if gvk.GroupKind() == crdGroupKind && !r.opts.DryRun {
+		r.appliedCRDs = true
+	}
+
+	if status := resource.Status(); status != nil && !r.opts.PruneStatus {
+		statusObject := NewResource(map[string]interface{}{
+			"apiVersion": result.APIVersion,
+			"kind":       result.Kind,
+			"metadata":   map[string]interface{}{"name": result.Name},
+			"status":     status,
+		})
+		if namespace != "" {
+			statusObject.Metadata()["namespace"] = namespace
+		}
+		_, err := resourceClient.ApplyStatus(ctx, result.Name, statusObject.Unstructured(), r.applyOpts)
+		// Kinds without a status subresource keep their status in the object
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsMethodNotSupported(err) {
+			result.Err = fmt.Errorf("failed to apply status: %w", err)
+			result.Conflict = apierrors.IsConflict(err)
+		}
+	}
+	return result
+}
+
+// restMapping finds the resource type for a kind. Once custom resource
+// definitions have been applied, unknown kinds are looked up again until
+// they are served or CRDTimeout passes.
+func (r *restorer) restMapping(ctx context.Context, gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err == nil || !meta.IsNoMatchError(err) || !r.appliedCRDs {
+		return mapping, err
+	}
+
+	pollErr := wait.PollUntilContextTimeout(ctx, time.Second, r.opts.CRDTimeout, true, func(ctx context.Context) (bool, error) {
+		r.mapper.Reset()
+		mapping, err = r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return true, err
+	})
+	if err == nil && pollErr != nil {
+		err = pollErr
+	}
+	return mapping, err
+}
+
+// jobControllerLabels are set by the API server on Jobs and their pod
+// templates to select the pods of the Job with the given UID
+var jobControllerLabels = []string{"controller-uid", "batch.kubernetes.io/controller-uid"}
+
+// restoreObject returns the object to apply for a resource: without status,
+// metadata set by the API server, or fields the target cluster assigns
+// itself, such as the cluster IPs of services and the selectors of jobs
+func restoreObject(resource Resource) *Resource {
+	object := StripResource(&resource, ExportOptions{StripStatus: true, StripServerFields: true})
+	switch resource.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Service"}:
+		object.Object = withoutField(object.Object, "spec", "clusterIP")
+		object.Object = withoutField(object.Object, "spec", "clusterIPs")
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		// The selector matches the UID of the captured Job, so the API
+		// server rejects it for a new one; it generates its own instead
+		if manual, _, _ := unstructured.NestedBool(object.Object, "spec", "manualSelector"); !manual {
+			object.Object = withoutField(object.Object, "spec", "selector")
+			for _, label := range jobControllerLabels {
+				object.Object = withoutField(object.Object, "metadata", "labels", label)
+				object.Object = withoutField(object.Object, "spec", "template", "metadata", "labels", label)
+			}
+		}
+	}
+	return object
+}
+
+// withoutField returns object without the nested field at path. The maps
+// along the path are copied rather than changed, since they may be shared
+// with the snapshot.
+func withoutField(object map[string]interface{}, path ...string) map[string]interface{} {
+	value, ok := object[path[0]]
+	if !ok {
+		return object
+	}
+	if len(path) > 1 {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			return object
+		}
+		value = withoutField(child, path[1:]...)
+	}
+
+	copied := make(map[string]interface{}, len(object))
+	for key, v := range object {
+		copied[key] = v
+	}
+	if len(path) == 1 {
+		delete(copied, path[0])
+	} else {
+		copied[path[0]] = value
+	}
+	return copied
+}
+
+// controllerOf returns the kind and name of the controller that owns a
+// resource, such as the ReplicaSet of a Pod, or an empty string. The
+// controller recreates such resources once it is restored itself.
+func controllerOf(resource Resource) string {
+	owners, _ := resource.Metadata()["ownerReferences"].([]interface{})
+	for _, owner := range owners {
+		ref, _ := owner.(map[string]interface{})
+		if controller, _ := ref["controller"].(bool); controller {
+			return fmt.Sprintf("%v %v", ref["kind"], ref["name"])
+		}
+	}
+	return ""
+}
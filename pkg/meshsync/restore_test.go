@@ -0,0 +1,478 @@
+package meshsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newRestoreSnapshot returns a snapshot with resources from every restore
+// phase, in an order that is not the restore order
+func newRestoreSnapshot() *Snapshot {
+	return &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Resources: []Resource{
+			NewResource(map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":            "web",
+					"namespace":       "shop",
+					"uid":             "1234",
+					"resourceVersion": "42",
+				},
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"readyReplicas": int64(2)},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata": map[string]interface{}{
+					"name":      "web-abc",
+					"namespace": "shop",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{"kind": "ReplicaSet", "name": "web-6d4f", "controller": true},
+					},
+				},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+				"spec":       map[string]interface{}{"clusterIP": "10.0.0.12", "clusterIPs": []interface{}{"10.0.0.12"}, "type": "ClusterIP"},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "web-config", "namespace": "shop"},
+				"data":       map[string]interface{}{"mode": "debug"},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "Role",
+				"metadata":   map[string]interface{}{"name": "reader", "namespace": "shop"},
+				"rules":      []interface{}{map[string]interface{}{"verbs": []interface{}{"get"}}},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "Gizmo",
+				"metadata":   map[string]interface{}{"name": "sprocket", "namespace": "shop"},
+				"config":     map[string]interface{}{"size": "large"},
+			}),
+			NewResource(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": "shop"},
+				"status":     map[string]interface{}{"phase": "Active"},
+			}),
+		},
+	}
+}
+
+// appliedObject is an apply request seen by the fake dynamic client
+type appliedObject struct {
+	name   string
+	object map[string]interface{}
+}
+
+// recordApplies makes the fake dynamic client record every apply and fail
+// those named in failures
+func recordApplies(t *testing.T, fake *k8stesting.Fake, failures map[string]error) *[]appliedObject {
+	applied := &[]appliedObject{}
+	fake.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		name := fmt.Sprintf("%s/%s/%s", patch.GetResource().Resource, patch.GetNamespace(), patch.GetName())
+		if patch.GetSubresource() != "" {
+			name += "/" + patch.GetSubresource()
+		}
+		if err, ok := failures[name]; ok {
+			return true, nil, err
+		}
+
+		object := map[string]interface{}{}
+		if err := json.Unmarshal(patch.GetPatch(), &object); err != nil {
+			t.Errorf("Apply of %s sent invalid JSON: %v", name, err)
+		}
+		*applied = append(*applied, appliedObject{name: name, object: object})
+		return true, &unstructured.Unstructured{Object: object}, nil
+	})
+	return applied
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        RestoreOptions
+		failures    map[string]error
+		wantApplied []string
+		wantFailed  []string
+	}{
+		{
+			name: "Dependency order with status",
+			wantApplied: []string{
+				"namespaces//shop",
+				"namespaces//shop/status",
+				"roles/shop/reader",
+				"configmaps/shop/web-config",
+				"services/shop/web",
+				"gizmos/shop/sprocket",
+				"deployments/shop/web",
+				"deployments/shop/web/status",
+			},
+		},
+		{
+			name: "Prune status",
+			opts: RestoreOptions{PruneStatus: true},
+			wantApplied: []string{
+				"namespaces//shop",
+				"roles/shop/reader",
+				"configmaps/shop/web-config",
+				"services/shop/web",
+				"gizmos/shop/sprocket",
+				"deployments/shop/web",
+			},
+		},
+		{
+			name: "Failures do not stop the restore",
+			opts: RestoreOptions{PruneStatus: true},
+			failures: map[string]error{
+				"configmaps/shop/web-config": apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "web-config", fmt.Errorf("denied")),
+			},
+			wantApplied: []string{
+				"namespaces//shop",
+				"roles/shop/reader",
+				"services/shop/web",
+				"gizmos/shop/sprocket",
+				"deployments/shop/web",
+			},
+			wantFailed: []string{"ConfigMap/shop/web-config"},
+		},
+		{
+			name: "Missing status subresource is ignored",
+			failures: map[string]error{
+				"namespaces//shop/status": apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "shop"),
+			},
+			wantApplied: []string{
+				"namespaces//shop",
+				"roles/shop/reader",
+				"configmaps/shop/web-config",
+				"services/shop/web",
+				"gizmos/shop/sprocket",
+				"deployments/shop/web",
+				"deployments/shop/web/status",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, dynamicClient := newFakeCaptureClient()
+			applied := recordApplies(t, &dynamicClient.Fake, tt.failures)
+
+			report, err := RestoreSnapshot(context.Background(), client, newRestoreSnapshot(), tt.opts)
+			if err != nil {
+				t.Fatalf("RestoreSnapshot() error = %v", err)
+			}
+
+			var names []string
+			for _, object := range *applied {
+				names = append(names, object.name)
+			}
+			if !reflect.DeepEqual(names, tt.wantApplied) {
+				t.Errorf("Applied %v, want %v", names, tt.wantApplied)
+			}
+
+			var failed []string
+			for _, result := range report.Failed() {
+				failed = append(failed, fmt.Sprintf("%s/%s/%s", result.Kind, result.Namespace, result.Name))
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("Failed = %v, want %v", failed, tt.wantFailed)
+			}
+
+			skipped := report.Skipped()
+			if len(skipped) != 1 || skipped[0].Name != "web-abc" || skipped[0].Skipped != "managed by ReplicaSet web-6d4f" {
+				t.Errorf("Skipped = %+v, want the pod managed by its ReplicaSet", skipped)
+			}
+			if want := len(tt.wantApplied) - strings.Count(strings.Join(tt.wantApplied, " "), "/status"); report.Applied() != want {
+				t.Errorf("Applied() = %d, want %d", report.Applied(), want)
+			}
+		})
+	}
+}
+
+func TestRestoreObject(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+	snapshot := newRestoreSnapshot()
+	if _, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{}); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+
+	objects := map[string]map[string]interface{}{}
+	for _, object := range *applied {
+		objects[object.name] = object.object
+	}
+
+	deployment := NewResource(objects["deployments/shop/web"])
+	if deployment.Status() != nil {
+		t.Errorf("Applied deployment has status %v, want it applied separately", deployment.Status())
+	}
+	for _, field := range []string{"uid", "resourceVersion"} {
+		if _, ok := deployment.Metadata()[field]; ok {
+			t.Errorf("Applied deployment has metadata.%s, want it stripped", field)
+		}
+	}
+	if deployment.Spec()["replicas"] != float64(2) {
+		t.Errorf("Applied deployment spec = %v, want 2 replicas", deployment.Spec())
+	}
+
+	status := NewResource(objects["deployments/shop/web/status"])
+	if status.Status()["readyReplicas"] != float64(2) || status.Namespace() != "shop" || status.Spec() != nil {
+		t.Errorf("Applied status = %v, want only the captured status", status.Object)
+	}
+
+	service := NewResource(objects["services/shop/web"])
+	if _, ok := service.Spec()["clusterIP"]; ok || service.Spec()["type"] != "ClusterIP" {
+		t.Errorf("Applied service spec = %v, want it without cluster IPs", service.Spec())
+	}
+	if _, ok := snapshot.Resources[2].Spec()["clusterIP"]; !ok {
+		t.Errorf("Restore modified the snapshot service spec %v", snapshot.Resources[2].Spec())
+	}
+
+	if gizmo := NewResource(objects["gizmos/shop/sprocket"]); !reflect.DeepEqual(gizmo.Object["config"], map[string]interface{}{"size": "large"}) {
+		t.Errorf("Applied gizmo = %v, want its config kept", gizmo.Object)
+	}
+}
+
+func TestRestoreJob(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+	controllerLabels := func() map[string]interface{} {
+		return map[string]interface{}{
+			"controller-uid":                     "5678",
+			"batch.kubernetes.io/controller-uid": "5678",
+			"job-name":                           "migrate",
+		}
+	}
+	job := NewResource(map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": "migrate", "namespace": "shop", "uid": "5678", "labels": controllerLabels()},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"batch.kubernetes.io/controller-uid": "5678"}},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": controllerLabels()},
+				"spec":     map[string]interface{}{"restartPolicy": "Never"},
+			},
+		},
+		"status": map[string]interface{}{"succeeded": int64(1)},
+	})
+	manual := NewResource(map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": "manual", "namespace": "shop"},
+		"spec": map[string]interface{}{
+			"manualSelector": true,
+			"selector":       map[string]interface{}{"matchLabels": map[string]interface{}{"app": "manual"}},
+		},
+	})
+	snapshot := &Snapshot{Resources: []Resource{job, manual}}
+	report, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{PruneStatus: true})
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 0 {
+		t.Fatalf("Failed = %+v, want the jobs restored", failed)
+	}
+
+	objects := map[string]Resource{}
+	for _, object := range *applied {
+		objects[object.name] = NewResource(object.object)
+	}
+	restored := objects["jobs/shop/migrate"]
+	if _, ok := restored.Spec()["selector"]; ok {
+		t.Errorf("Applied job spec = %v, want it without the selector", restored.Spec())
+	}
+	wantLabels := map[string]string{"job-name": "migrate"}
+	if labels, _, _ := unstructured.NestedStringMap(restored.Object, "metadata", "labels"); !reflect.DeepEqual(labels, wantLabels) {
+		t.Errorf("Applied job labels = %v, want %v", labels, wantLabels)
+	}
+	if labels, _, _ := unstructured.NestedStringMap(restored.Object, "spec", "template", "metadata", "labels"); !reflect.DeepEqual(labels, wantLabels) {
+		t.Errorf("Applied job template labels = %v, want %v", labels, wantLabels)
+	}
+	if labels, _, _ := unstructured.NestedStringMap(job.Object, "spec", "template", "metadata", "labels"); len(labels) != 3 {
+		t.Errorf("Restore modified the snapshot job template labels %v", labels)
+	}
+	if _, ok := objects["jobs/shop/manual"].Spec()["selector"]; !ok {
+		t.Errorf("Applied job with a manual selector = %v, want its selector kept", objects["jobs/shop/manual"].Spec())
+	}
+}
+
+func TestRestoreSnapshotUnknownKind(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+	snapshot := &Snapshot{Resources: []Resource{
+		NewResource(map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]interface{}{"name": "w", "namespace": "shop"},
+		}),
+	}}
+	report, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 1 || !strings.Contains(failed[0].Err.Error(), "no matches for kind") {
+		t.Errorf("Failed = %+v, want the unknown Widget", failed)
+	}
+	if len(*applied) != 0 {
+		t.Errorf("Applied %v, want nothing", *applied)
+	}
+}
+
+func TestRestoreSnapshotConflicts(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	recordApplies(t, &dynamicClient.Fake, map[string]error{
+		"configmaps/shop/web-config": apierrors.NewApplyConflict([]metav1.StatusCause{{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit"`,
+			Field:   ".data.mode",
+		}}, `Apply failed with 1 conflict: conflict with "kubectl-edit": .data.mode`),
+		"roles/shop/reader": apierrors.NewForbidden(schema.GroupResource{Resource: "roles"}, "reader", fmt.Errorf("denied")),
+	})
+
+	report, err := RestoreSnapshot(context.Background(), client, newRestoreSnapshot(), RestoreOptions{PruneStatus: true})
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 2 {
+		t.Errorf("Failed = %+v, want the role and the config map", failed)
+	}
+	conflicts := report.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Name != "web-config" || !strings.Contains(conflicts[0].Err.Error(), "kubectl-edit") {
+		t.Errorf("Conflicts = %+v, want the config map owned by kubectl-edit", conflicts)
+	}
+}
+
+func TestRestoreSnapshotClusterScoped(t *testing.T) {
+	binding := NewResource(map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata":   map[string]interface{}{"name": "web-view"},
+		"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "view"},
+		"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "web", "namespace": "shop"}},
+	})
+
+	tests := []struct {
+		name        string
+		rename      RenameOptions
+		wantApplied []string
+		wantSkipped string
+	}{
+		{
+			name:        "Restored in place",
+			wantApplied: []string{"namespaces//shop", "clusterrolebindings//web-view"},
+		},
+		{
+			name:        "Namespace mapping only",
+			rename:      RenameOptions{Namespaces: map[string]string{"shop": "shop-replay"}},
+			wantApplied: []string{"namespaces//shop-replay"},
+			wantSkipped: SkippedClusterScoped,
+		},
+		{
+			name:        "Namespace mapping with prefix",
+			rename:      RenameOptions{Namespaces: map[string]string{"shop": "shop-replay"}, NamePrefix: "replay-"},
+			wantApplied: []string{"namespaces//shop-replay", "clusterrolebindings//replay-web-view"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, dynamicClient := newFakeCaptureClient()
+			applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+			snapshot := &Snapshot{Resources: []Resource{
+				binding,
+				NewResource(map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Namespace",
+					"metadata":   map[string]interface{}{"name": "shop"},
+				}),
+			}}
+			report, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{Rename: tt.rename})
+			if err != nil {
+				t.Fatalf("RestoreSnapshot() error = %v", err)
+			}
+
+			var names []string
+			for _, object := range *applied {
+				names = append(names, object.name)
+			}
+			if !reflect.DeepEqual(names, tt.wantApplied) {
+				t.Errorf("Applied %v, want %v", names, tt.wantApplied)
+			}
+			skipped := report.Skipped()
+			if tt.wantSkipped == "" && len(skipped) != 0 {
+				t.Errorf("Skipped = %+v, want nothing", skipped)
+			}
+			if tt.wantSkipped != "" && (len(skipped) != 1 || skipped[0].Name != "web-view" || skipped[0].Skipped != tt.wantSkipped) {
+				t.Errorf("Skipped = %+v, want the ClusterRoleBinding", skipped)
+			}
+		})
+	}
+}
+
+func TestRestoreSnapshotInvalidLabelValue(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+	snapshot := newRestoreSnapshot()
+	snapshot.Resources = append(snapshot.Resources, NewResource(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "long", "namespace": "shop"},
+		"spec":       map[string]interface{}{"selector": map[string]interface{}{"app": strings.Repeat("l", 60)}},
+	}))
+	_, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{Rename: RenameOptions{NameSuffix: "-replay"}})
+	if err == nil || !strings.Contains(err.Error(), "failed to rename Service long") {
+		t.Errorf("RestoreSnapshot() error = %v, want the invalid label value of the service", err)
+	}
+	if len(*applied) != 0 {
+		t.Errorf("Applied %v, want nothing before the label value is rejected", *applied)
+	}
+}
+
+func TestRestoreSnapshotNameTooLong(t *testing.T) {
+	client, dynamicClient := newFakeCaptureClient()
+	applied := recordApplies(t, &dynamicClient.Fake, nil)
+
+	snapshot := newRestoreSnapshot()
+	long := strings.Repeat("l", 60)
+	snapshot.Resources = append(snapshot.Resources, NewResource(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": long, "namespace": "shop"},
+	}))
+	_, err := RestoreSnapshot(context.Background(), client, snapshot, RestoreOptions{Rename: RenameOptions{NameSuffix: "-replay"}})
+	if err == nil || !strings.Contains(err.Error(), "failed to rename Service "+long) {
+		t.Errorf("RestoreSnapshot() error = %v, want the service name over 63 characters", err)
+	}
+	if len(*applied) != 0 {
+		t.Errorf("Applied %v, want nothing before the name is rejected", *applied)
+	}
+}
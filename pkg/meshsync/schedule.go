@@ -0,0 +1,404 @@
+package meshsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// ScheduleName is the name of the CronJob, and of the service account and
+// namespaced RBAC objects it runs with, installed for scheduled captures.
+// The cluster-scoped ones are named per namespace, see
+// ScheduleClusterRoleName.
+const ScheduleName = "meshsync-snapshot"
+
+// ScheduleClusterRoleName returns the name of the ClusterRole and
+// ClusterRoleBinding of the schedule in namespace, so schedules in several
+// namespaces each have their own
+func ScheduleClusterRoleName(namespace string) string {
+	return ScheduleName + "-" + namespace
+}
+
+// ScheduleSinkMountPath is where the sink volume claim is mounted in
+// scheduled capture pods
+const ScheduleSinkMountPath = "/snapshots"
+
+// DefaultScheduleSink is where scheduled captures write snapshots when a
+// sink volume claim is given
+const DefaultScheduleSink = ScheduleSinkMountPath + "/" + ClusterPlaceholder + "-" + TimestampPlaceholder + ".yaml.gz"
+
+// ScheduleOptions contains options for scheduled in-cluster captures
+type ScheduleOptions struct {
+	// Namespace is where MeshSync and the CronJob run
+	Namespace string
+	// Schedule is the cron schedule, e.g. "0 * * * *"
+	Schedule string
+	// Image runs the plugin, with the plugin as its entrypoint
+	Image string
+	// Sink is the output path or sink URL each capture writes to, with
+	// {timestamp} and {cluster} placeholders; DefaultScheduleSink is used
+	// when empty. configmap:// and pvc:// sinks must be in Namespace.
+	Sink string
+	// SinkClaim is a PersistentVolumeClaim in Namespace mounted at
+	// ScheduleSinkMountPath, for sinks on a volume
+	SinkClaim string
+	// SinkSecret is a Secret in Namespace whose keys are set as environment
+	// variables in the capture pod, such as the credentials of an s3:// sink
+	SinkSecret string
+	// Kinds and AllNamespaces select what is captured, as for CaptureOptions
+	Kinds         []string
+	AllNamespaces bool
+	// MesheryURL imports each snapshot to Meshery when set
+	MesheryURL string
+	// MesheryTokenSecret is a Secret in Namespace whose "token" key holds
+	// the Meshery token
+	MesheryTokenSecret string
+	// Retention is the number of snapshots kept in the sink, 0 keeps all
+	Retention int
+	// ConcurrencyPolicy decides what happens when a capture is still
+	// running at the next scheduled time; Forbid is used when empty
+	ConcurrencyPolicy batchv1.ConcurrencyPolicy
+}
+
+// Validate checks the options that the API server cannot check for us
+func (o ScheduleOptions) Validate() error {
+	if o.Schedule == "" {
+		return fmt.Errorf("a schedule is required")
+	}
+	if o.Image == "" {
+		return fmt.Errorf("an image with the plugin as its entrypoint is required")
+	}
+	switch o.ConcurrencyPolicy {
+	case "", batchv1.AllowConcurrent, batchv1.ForbidConcurrent, batchv1.ReplaceConcurrent:
+	default:
+		return fmt.Errorf("unsupported concurrency policy %q (supported: Allow, Forbid, Replace)", o.ConcurrencyPolicy)
+	}
+	if o.Retention < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+	if o.Sink == StdioPath {
+		return fmt.Errorf("scheduled captures cannot write to stdout, give a sink path")
+	}
+	if IsSinkURL(o.Sink) && !SinkPrunes(o.Sink) && o.Retention > 0 {
+		return fmt.Errorf("retention is not supported for the sink %s, expire old snapshots in the storage itself", o.Sink)
+	}
+	switch {
+	case strings.HasPrefix(o.Sink, PVCScheme+"://"):
+		namespace, claim, _, err := ParsePVCLocation(o.Sink)
+		if err != nil {
+			return err
+		}
+		if namespace != o.Namespace {
+			return fmt.Errorf("the sink %s must be in the namespace %s of the schedule", o.Sink, o.Namespace)
+		}
+		if o.SinkClaim != "" && o.SinkClaim != claim {
+			return fmt.Errorf("the sink %s is on the claim %s, not %s", o.Sink, claim, o.SinkClaim)
+		}
+	case strings.HasPrefix(o.Sink, ConfigMapScheme+"://"):
+		if u, err := url.Parse(o.Sink); err != nil || u.Host != o.Namespace {
+			return fmt.Errorf("the sink %s must be in the namespace %s of the schedule", o.Sink, o.Namespace)
+		}
+	}
+	if o.sinkClaim() == "" && (o.Sink == "" || strings.HasPrefix(o.Sink, ScheduleSinkMountPath+"/")) {
+		return fmt.Errorf("the sink %s needs a volume claim to write to", o.sink())
+	}
+	return nil
+}
+
+// sink returns the output path scheduled captures write to. A pvc:// sink
+// is written through the claim mounted in the pod.
+func (o ScheduleOptions) sink() string {
+	if o.Sink == "" {
+		return DefaultScheduleSink
+	}
+	if _, _, filePath, err := ParsePVCLocation(o.Sink); err == nil {
+		return ScheduleSinkMountPath + filePath
+	}
+	return o.Sink
+}
+
+// sinkClaim returns the volume claim mounted for the sink, if any
+func (o ScheduleOptions) sinkClaim() string {
+	if _, claim, _, err := ParsePVCLocation(o.Sink); err == nil {
+		return claim
+	}
+	return o.SinkClaim
+}
+
+// DeploySchedule installs, or updates, a CronJob that captures a snapshot
+// in the cluster on the given schedule, along with a service account that
+// can read the kinds it captures
+func DeploySchedule(ctx context.Context, client *kube.Client, opts ScheduleOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	kinds, err := resolveKinds(client, opts.Kinds)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{"app": ScheduleName}
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: ScheduleName, Namespace: opts.Namespace, Labels: labels},
+	}
+	if _, err := client.Clientset.CoreV1().ServiceAccounts(opts.Namespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+
+	clusterName := ScheduleClusterRoleName(opts.Namespace)
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, Labels: labels},
+		Rules:      scheduleRules(kinds),
+	}
+	clusterRoles := client.Clientset.RbacV1().ClusterRoles()
+	_, err = clusterRoles.Create(ctx, role, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Deploying again with other kinds changes what the captures can read
+		existing, getErr := clusterRoles.Get(ctx, clusterName, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get cluster role: %w", getErr)
+		}
+		role.ResourceVersion = existing.ResourceVersion
+		_, err = clusterRoles.Update(ctx, role, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create cluster role: %w", err)
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, Labels: labels},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterName},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: ScheduleName, Namespace: opts.Namespace}},
+	}
+	clusterRoleBindings := client.Clientset.RbacV1().ClusterRoleBindings()
+	_, err = clusterRoleBindings.Create(ctx, binding, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Bind the service account even if the binding was changed since
+		existing, getErr := clusterRoleBindings.Get(ctx, clusterName, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get cluster role binding: %w", getErr)
+		}
+		if existing.RoleRef != binding.RoleRef {
+			// The role of a binding cannot be changed, so replace it
+			if err := clusterRoleBindings.Delete(ctx, clusterName, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("failed to replace cluster role binding: %w", err)
+			}
+			_, err = clusterRoleBindings.Create(ctx, binding, metav1.CreateOptions{})
+		} else {
+			binding.ResourceVersion = existing.ResourceVersion
+			_, err = clusterRoleBindings.Update(ctx, binding, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create cluster role binding: %w", err)
+	}
+
+	if strings.HasPrefix(opts.Sink, ConfigMapScheme+"://") {
+		if err := deploySinkRole(ctx, client, opts.Namespace, labels); err != nil {
+			return err
+		}
+	}
+
+	cronJobs := client.Clientset.BatchV1().CronJobs(opts.Namespace)
+	cronJob := newScheduleCronJob(opts)
+	_, err = cronJobs.Create(ctx, cronJob, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Deploying again changes the schedule of the existing CronJob
+		existing, getErr := cronJobs.Get(ctx, ScheduleName, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get capture CronJob: %w", getErr)
+		}
+		cronJob.ResourceVersion = existing.ResourceVersion
+		_, err = cronJobs.Update(ctx, cronJob, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create capture CronJob: %w", err)
+	}
+	return nil
+}
+
+// scheduleRules returns what scheduled captures may read: the kinds they
+// capture, and what run needs around them. Nothing else is readable, so
+// secrets are only readable when they are captured.
+func scheduleRules(kinds []resourceKind) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		// The cluster ID, the namespaces a capture covers and the node count
+		{APIGroups: []string{""}, Resources: []string{"namespaces", "nodes"}, Verbs: []string{"get", "list"}},
+		// The MeshSync deployment and service the capture is validated against
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, ResourceNames: []string{"meshsync"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"services"}, ResourceNames: []string{"meshsync"}, Verbs: []string{"get"}},
+	}
+
+	resources := map[string][]string{}
+	for _, kind := range kinds {
+		resources[kind.GVR.Group] = append(resources[kind.GVR.Group], kind.GVR.Resource)
+	}
+	groups := make([]string, 0, len(resources))
+	for group := range resources {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		sort.Strings(resources[group])
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: resources[group],
+			Verbs:     []string{"get", "list"},
+		})
+	}
+	return rules
+}
+
+// deploySinkRole lets the capture service account store snapshots in
+// ConfigMaps in its namespace, and remove old ones for retention
+func deploySinkRole(ctx context.Context, client *kube.Client, namespace string, labels map[string]string) error {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: ScheduleName, Namespace: namespace, Labels: labels},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "list", "create", "update", "delete"},
+		}},
+	}
+	if _, err := client.Clientset.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create sink role: %w", err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: ScheduleName, Namespace: namespace, Labels: labels},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: ScheduleName},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: ScheduleName, Namespace: namespace}},
+	}
+	if _, err := client.Clientset.RbacV1().RoleBindings(namespace).Create(ctx, binding, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create sink role binding: %w", err)
+	}
+	return nil
+}
+
+// newScheduleCronJob returns the CronJob that runs the plugin's run command
+// against the MeshSync deployment in the same namespace
+func newScheduleCronJob(opts ScheduleOptions) *batchv1.CronJob {
+	concurrencyPolicy := opts.ConcurrencyPolicy
+	if concurrencyPolicy == "" {
+		concurrencyPolicy = batchv1.ForbidConcurrent
+	}
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = DefaultKinds
+	}
+
+	// MeshSync is already deployed, so run only captures, saves and imports
+	args := []string{
+		"run",
+		"--namespace", opts.Namespace,
+		"--kinds", strings.Join(kinds, ","),
+		"--output", opts.sink(),
+		"--overwrite",
+		"--keep",
+		"--keep-last", strconv.Itoa(opts.Retention),
+	}
+	if opts.AllNamespaces {
+		args = append(args, "--all-namespaces")
+	}
+
+	container := corev1.Container{
+		Name:  "capture",
+		Image: opts.Image,
+	}
+	if opts.MesheryURL != "" {
+		args = append(args, "--url", opts.MesheryURL)
+		if opts.MesheryTokenSecret != "" {
+			// The kubelet expands $(MESHERY_TOKEN), so the token stays in the Secret
+			args = append(args, "--token", "$(MESHERY_TOKEN)")
+			container.Env = []corev1.EnvVar{{
+				Name: "MESHERY_TOKEN",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: opts.MesheryTokenSecret},
+					Key:                  "token",
+				}},
+			}}
+		}
+	}
+	container.Args = args
+	if opts.SinkSecret != "" {
+		container.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: opts.SinkSecret}},
+		}}
+	}
+
+	podSpec := corev1.PodSpec{
+		ServiceAccountName: ScheduleName,
+		RestartPolicy:      corev1.RestartPolicyNever,
+		Containers:         []corev1.Container{container},
+	}
+	if claim := opts.sinkClaim(); claim != "" {
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "sink",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claim,
+			}},
+		}}
+		podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "sink", MountPath: ScheduleSinkMountPath}}
+	}
+
+	labels := map[string]string{"app": ScheduleName}
+	backoffLimit := int32(1)
+	successfulJobs, failedJobs := int32(3), int32(1)
+	return &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: ScheduleName, Namespace: opts.Namespace, Labels: labels},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   opts.Schedule,
+			ConcurrencyPolicy:          concurrencyPolicy,
+			SuccessfulJobsHistoryLimit: &successfulJobs,
+			FailedJobsHistoryLimit:     &failedJobs,
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					BackoffLimit: &backoffLimit,
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       podSpec,
+					},
+				},
+			},
+		},
+	}
+}
+
+// CleanupSchedule removes the capture CronJob in namespace, its jobs and its
+// RBAC objects, leaving the schedules of other namespaces in place. Objects
+// that do not exist are skipped.
+func CleanupSchedule(ctx context.Context, client *kube.Client, namespace string) error {
+	propagation := metav1.DeletePropagationBackground
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	clusterName := ScheduleClusterRoleName(namespace)
+	errs := []error{
+		client.Clientset.BatchV1().CronJobs(namespace).Delete(ctx, ScheduleName, deleteOpts),
+		client.Clientset.RbacV1().ClusterRoleBindings().Delete(ctx, clusterName, deleteOpts),
+		client.Clientset.RbacV1().ClusterRoles().Delete(ctx, clusterName, deleteOpts),
+		client.Clientset.RbacV1().RoleBindings(namespace).Delete(ctx, ScheduleName, deleteOpts),
+		client.Clientset.RbacV1().Roles(namespace).Delete(ctx, ScheduleName, deleteOpts),
+		client.Clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, ScheduleName, deleteOpts),
+	}
+	for i, err := range errs {
+		if apierrors.IsNotFound(err) {
+			errs[i] = nil
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove capture schedule: %w", err)
+	}
+	return nil
+}
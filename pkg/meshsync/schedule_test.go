@@ -0,0 +1,294 @@
+package meshsync
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testScheduleImage is the plugin image of scheduled captures in tests
+const testScheduleImage = "example.com/meshsync-snapshot:test"
+
+func TestDeploySchedule(t *testing.T) {
+	client, clientset := newFakeClient()
+	clientset.Resources = fakeAPIResources
+	ctx := context.Background()
+
+	opts := ScheduleOptions{
+		Namespace:          "meshery",
+		Schedule:           "0 * * * *",
+		Image:              testScheduleImage,
+		SinkClaim:          "snapshots",
+		Kinds:              []string{"deployments", "services"},
+		MesheryURL:         "http://meshery:9081",
+		MesheryTokenSecret: "meshery-token",
+		Retention:          24,
+	}
+	if err := DeploySchedule(ctx, client, opts); err != nil {
+		t.Fatalf("DeploySchedule() error = %v", err)
+	}
+
+	cronJob, err := clientset.BatchV1().CronJobs("meshery").Get(ctx, ScheduleName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get CronJob: %v", err)
+	}
+	if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+		t.Errorf("ConcurrencyPolicy = %s, want %s", cronJob.Spec.ConcurrencyPolicy, batchv1.ForbidConcurrent)
+	}
+
+	pod := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	if pod.ServiceAccountName != ScheduleName {
+		t.Errorf("ServiceAccountName = %s, want %s", pod.ServiceAccountName, ScheduleName)
+	}
+	if len(pod.Volumes) != 1 || pod.Volumes[0].PersistentVolumeClaim.ClaimName != "snapshots" {
+		t.Errorf("Volumes = %+v, want the snapshots claim", pod.Volumes)
+	}
+	container := pod.Containers[0]
+	wantArgs := []string{
+		"run",
+		"--namespace", "meshery",
+		"--kinds", "deployments,services",
+		"--output", DefaultScheduleSink,
+		"--overwrite",
+		"--keep",
+		"--keep-last", "24",
+		"--url", "http://meshery:9081",
+		"--token", "$(MESHERY_TOKEN)",
+	}
+	if !reflect.DeepEqual(container.Args, wantArgs) {
+		t.Errorf("Args = %v, want %v", container.Args, wantArgs)
+	}
+	if len(container.Env) != 1 || container.Env[0].ValueFrom.SecretKeyRef.Name != "meshery-token" {
+		t.Errorf("Env = %+v, want the token from meshery-token", container.Env)
+	}
+	if len(container.EnvFrom) != 0 {
+		t.Errorf("EnvFrom = %+v, want none without a sink secret", container.EnvFrom)
+	}
+
+	s3Pod := newScheduleCronJob(ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, Sink: "s3://snapshots/{timestamp}.yaml.gz", SinkSecret: "s3-credentials"}).Spec.JobTemplate.Spec.Template.Spec
+	if envFrom := s3Pod.Containers[0].EnvFrom; len(envFrom) != 1 || envFrom[0].SecretRef.Name != "s3-credentials" {
+		t.Errorf("EnvFrom = %+v, want the s3-credentials secret", envFrom)
+	}
+	if len(s3Pod.Volumes) != 0 {
+		t.Errorf("Volumes = %+v, want none without a claim", s3Pod.Volumes)
+	}
+
+	for _, get := range []func() error{
+		func() error {
+			_, err := clientset.CoreV1().ServiceAccounts("meshery").Get(ctx, ScheduleName, metav1.GetOptions{})
+			return err
+		},
+		func() error {
+			_, err := clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{})
+			return err
+		},
+		func() error {
+			_, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{})
+			return err
+		},
+	} {
+		if err := get(); err != nil {
+			t.Errorf("Failed to get schedule RBAC object: %v", err)
+		}
+	}
+
+	role, err := clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get cluster role: %v", err)
+	}
+	if readable := scheduleReadable(role.Rules); !reflect.DeepEqual(readable, []string{"/namespaces", "/nodes", "/services", "apps/deployments"}) {
+		t.Errorf("Cluster role lists %v, want only the captured kinds, namespaces and nodes", readable)
+	}
+
+	// Deploying again updates the schedule and the kinds it can read in place
+	opts.Schedule = "*/15 * * * *"
+	opts.ConcurrencyPolicy = batchv1.ReplaceConcurrent
+	opts.Kinds = []string{"deployments", "secrets"}
+	if err := DeploySchedule(ctx, client, opts); err != nil {
+		t.Fatalf("DeploySchedule() again error = %v", err)
+	}
+	role, err = clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get cluster role: %v", err)
+	}
+	if readable := scheduleReadable(role.Rules); !reflect.DeepEqual(readable, []string{"/namespaces", "/nodes", "/secrets", "apps/deployments"}) {
+		t.Errorf("Updated cluster role lists %v, want secrets once they are captured", readable)
+	}
+	cronJob, err = clientset.BatchV1().CronJobs("meshery").Get(ctx, ScheduleName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get CronJob: %v", err)
+	}
+	if cronJob.Spec.Schedule != "*/15 * * * *" || cronJob.Spec.ConcurrencyPolicy != batchv1.ReplaceConcurrent {
+		t.Errorf("Updated CronJob schedule = %s, policy = %s", cronJob.Spec.Schedule, cronJob.Spec.ConcurrencyPolicy)
+	}
+
+	if err := CleanupSchedule(ctx, client, "meshery"); err != nil {
+		t.Fatalf("CleanupSchedule() error = %v", err)
+	}
+	if _, err := clientset.BatchV1().CronJobs("meshery").Get(ctx, ScheduleName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("CronJob still exists after cleanup, error = %v", err)
+	}
+	if _, err := clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("ClusterRole still exists after cleanup, error = %v", err)
+	}
+
+	// Nothing left to remove is not an error
+	if err := CleanupSchedule(ctx, client, "meshery"); err != nil {
+		t.Errorf("CleanupSchedule() without a schedule error = %v", err)
+	}
+}
+
+// scheduleReadable returns the group/resource pairs that rules allow to
+// list, sorted
+func scheduleReadable(rules []rbacv1.PolicyRule) []string {
+	var readable []string
+	for _, rule := range rules {
+		if !slices.Contains(rule.Verbs, "list") {
+			continue
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				readable = append(readable, group+"/"+resource)
+			}
+		}
+	}
+	sort.Strings(readable)
+	return readable
+}
+
+func TestDeployScheduleNamespaces(t *testing.T) {
+	client, clientset := newFakeClient()
+	clientset.Resources = fakeAPIResources
+	ctx := context.Background()
+
+	for _, namespace := range []string{"meshery", "staging"} {
+		opts := ScheduleOptions{Namespace: namespace, Schedule: "0 * * * *", Image: testScheduleImage, SinkClaim: "snapshots"}
+		if err := DeploySchedule(ctx, client, opts); err != nil {
+			t.Fatalf("DeploySchedule(%s) error = %v", namespace, err)
+		}
+	}
+	for _, namespace := range []string{"meshery", "staging"} {
+		binding, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, ScheduleClusterRoleName(namespace), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Failed to get cluster role binding of %s: %v", namespace, err)
+		}
+		want := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: ScheduleName, Namespace: namespace}}
+		if !reflect.DeepEqual(binding.Subjects, want) || binding.RoleRef.Name != ScheduleClusterRoleName(namespace) {
+			t.Errorf("Cluster role binding of %s binds %+v to %s, want its own service account and role", namespace, binding.Subjects, binding.RoleRef.Name)
+		}
+	}
+
+	// Deploying again restores a binding that was changed
+	bindings := clientset.RbacV1().ClusterRoleBindings()
+	binding, _ := bindings.Get(ctx, ScheduleClusterRoleName("staging"), metav1.GetOptions{})
+	binding.Subjects = nil
+	if _, err := bindings.Update(ctx, binding, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeploySchedule(ctx, client, ScheduleOptions{Namespace: "staging", Schedule: "0 * * * *", Image: testScheduleImage, SinkClaim: "snapshots"}); err != nil {
+		t.Fatalf("DeploySchedule() again error = %v", err)
+	}
+	if binding, _ = bindings.Get(ctx, ScheduleClusterRoleName("staging"), metav1.GetOptions{}); len(binding.Subjects) != 1 {
+		t.Errorf("Redeployed binding subjects = %+v, want the staging service account", binding.Subjects)
+	}
+
+	// Cleaning up one namespace leaves the other schedule working
+	if err := CleanupSchedule(ctx, client, "staging"); err != nil {
+		t.Fatalf("CleanupSchedule() error = %v", err)
+	}
+	if _, err := bindings.Get(ctx, ScheduleClusterRoleName("staging"), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Cluster role binding of staging still exists after cleanup, error = %v", err)
+	}
+	if _, err := clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("staging"), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Cluster role of staging still exists after cleanup, error = %v", err)
+	}
+	if _, err := bindings.Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{}); err != nil {
+		t.Errorf("Cluster role binding of meshery removed by the staging cleanup: %v", err)
+	}
+	if _, err := clientset.RbacV1().ClusterRoles().Get(ctx, ScheduleClusterRoleName("meshery"), metav1.GetOptions{}); err != nil {
+		t.Errorf("Cluster role of meshery removed by the staging cleanup: %v", err)
+	}
+	if _, err := clientset.BatchV1().CronJobs("meshery").Get(ctx, ScheduleName, metav1.GetOptions{}); err != nil {
+		t.Errorf("CronJob of meshery removed by the staging cleanup: %v", err)
+	}
+}
+
+func TestDeployScheduleClusterSinks(t *testing.T) {
+	client, clientset := newFakeClient()
+	clientset.Resources = fakeAPIResources
+	ctx := context.Background()
+
+	pvcPod := newScheduleCronJob(ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "pvc://meshery/snapshots/prod/{timestamp}.yaml.gz"}).Spec.JobTemplate.Spec.Template.Spec
+	if len(pvcPod.Volumes) != 1 || pvcPod.Volumes[0].PersistentVolumeClaim.ClaimName != "snapshots" {
+		t.Errorf("Volumes = %+v, want the snapshots claim", pvcPod.Volumes)
+	}
+	if args := strings.Join(pvcPod.Containers[0].Args, " "); !strings.Contains(args, "--output "+ScheduleSinkMountPath+"/prod/{timestamp}.yaml.gz") {
+		t.Errorf("Args = %s, want the output on the mounted claim", args)
+	}
+
+	opts := ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "configmap://meshery/prod-{timestamp}", Retention: 3}
+	if err := DeploySchedule(ctx, client, opts); err != nil {
+		t.Fatalf("DeploySchedule() error = %v", err)
+	}
+	role, err := clientset.RbacV1().Roles("meshery").Get(ctx, ScheduleName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get sink role: %v", err)
+	}
+	if resources := role.Rules[0].Resources; !reflect.DeepEqual(resources, []string{"configmaps"}) {
+		t.Errorf("Role resources = %v, want configmaps", resources)
+	}
+	if _, err := clientset.RbacV1().RoleBindings("meshery").Get(ctx, ScheduleName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Failed to get sink role binding: %v", err)
+	}
+
+	if err := CleanupSchedule(ctx, client, "meshery"); err != nil {
+		t.Fatalf("CleanupSchedule() error = %v", err)
+	}
+	if _, err := clientset.RbacV1().Roles("meshery").Get(ctx, ScheduleName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Role still exists after cleanup, error = %v", err)
+	}
+}
+
+func TestScheduleOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ScheduleOptions
+		wantErr string
+	}{
+		{name: "Valid", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, SinkClaim: "snapshots"}},
+		{name: "Sink without claim", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, Sink: "/data/{timestamp}.yaml"}},
+		{name: "S3 sink", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, Sink: "s3://snapshots/{cluster}/{timestamp}.yaml.gz", SinkSecret: "s3-credentials"}},
+		{name: "S3 sink with retention", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, Sink: "s3://snapshots/{timestamp}.yaml.gz", Retention: 24}, wantErr: "retention is not supported"},
+		{name: "ConfigMap sink with retention", opts: ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "configmap://meshery/prod-{timestamp}", Retention: 24}},
+		{name: "PVC sink without claim", opts: ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "pvc://meshery/snapshots/{timestamp}.yaml.gz", Retention: 24}},
+		{name: "ConfigMap sink in another namespace", opts: ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "configmap://default/prod-{timestamp}"}, wantErr: "must be in the namespace meshery"},
+		{name: "PVC sink in another namespace", opts: ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "pvc://default/snapshots/a.yaml"}, wantErr: "must be in the namespace meshery"},
+		{name: "PVC sink on another claim", opts: ScheduleOptions{Namespace: "meshery", Schedule: "0 * * * *", Image: testScheduleImage, Sink: "pvc://meshery/snapshots/a.yaml", SinkClaim: "backups"}, wantErr: "not backups"},
+		{name: "No schedule", opts: ScheduleOptions{Image: testScheduleImage, SinkClaim: "snapshots"}, wantErr: "a schedule is required"},
+		{name: "No image", opts: ScheduleOptions{Schedule: "0 * * * *", SinkClaim: "snapshots"}, wantErr: "an image"},
+		{name: "Default sink without claim", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage}, wantErr: "needs a volume claim"},
+		{name: "Stdout sink", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, Sink: StdioPath}, wantErr: "cannot write to stdout"},
+		{name: "Negative retention", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, SinkClaim: "snapshots", Retention: -1}, wantErr: "retention"},
+		{name: "Unknown policy", opts: ScheduleOptions{Schedule: "0 * * * *", Image: testScheduleImage, SinkClaim: "snapshots", ConcurrencyPolicy: "Queue"}, wantErr: "unsupported concurrency policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
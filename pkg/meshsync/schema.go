@@ -0,0 +1,204 @@
+package meshsync
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Snapshot API versions. Resources and status are encoded the same way in
+// every version; they differ in how the metadata is laid out.
+const (
+	// APIVersionV1Alpha1 snapshots carry free-form metadata
+	APIVersionV1Alpha1 = "meshery.layer5.io/v1alpha1"
+	// APIVersionV1Alpha2 snapshots carry typed metadata, see SnapshotMetadata
+	APIVersionV1Alpha2 = "meshery.layer5.io/v1alpha2"
+
+	// SnapshotAPIVersion is the version new snapshots are written in
+	SnapshotAPIVersion = APIVersionV1Alpha2
+	// SnapshotKind is the kind of every snapshot document
+	SnapshotKind = "MeshSync"
+)
+
+// SupportedAPIVersions lists the snapshot versions that can be loaded and converted to
+var SupportedAPIVersions = []string{APIVersionV1Alpha1, APIVersionV1Alpha2}
+
+//go:embed schema/*.json
+var schemas embed.FS
+
+// SnapshotMetadata describes a snapshot: its name and labels, the cluster it
+// was captured from and how it was captured
+type SnapshotMetadata struct {
+	Name      string            `json:"name" yaml:"name"`
+	Timestamp string            `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Annotations holds metadata that has no typed field, such as keys
+	// carried over from a v1alpha1 snapshot
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Cluster     *ClusterInfo      `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Capture     *CaptureInfo      `json:"capture,omitempty" yaml:"capture,omitempty"`
+}
+
+// ClusterInfo identifies the cluster a snapshot was captured from
+type ClusterInfo struct {
+	// ID is the UID of the kube-system namespace, which is stable for the
+	// lifetime of the cluster
+	ID                string `json:"id,omitempty" yaml:"id,omitempty"`
+	Server            string `json:"server,omitempty" yaml:"server,omitempty"`
+	Context           string `json:"context,omitempty" yaml:"context,omitempty"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty" yaml:"kubernetesVersion,omitempty"`
+	NodeCount         *int   `json:"nodeCount,omitempty" yaml:"nodeCount,omitempty"`
+}
+
+// CaptureInfo records how a snapshot was captured
+type CaptureInfo struct {
+	PluginVersion   string         `json:"pluginVersion,omitempty" yaml:"pluginVersion,omitempty"`
+	MeshSyncVersion string         `json:"meshsyncVersion,omitempty" yaml:"meshsyncVersion,omitempty"`
+	Filters         CaptureFilters `json:"filters" yaml:"filters"`
+}
+
+// CaptureFilters are the filters a snapshot was captured with
+type CaptureFilters struct {
+	Namespace     string   `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	AllNamespaces bool     `json:"allNamespaces" yaml:"allNamespaces"`
+	Kinds         []string `json:"kinds,omitempty" yaml:"kinds,omitempty"`
+}
+
+// SnapshotV1Alpha1 is a meshery.layer5.io/v1alpha1 snapshot
+type SnapshotV1Alpha1 struct {
+	APIVersion string                 `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string                 `json:"kind" yaml:"kind"`
+	Metadata   map[string]interface{} `json:"metadata" yaml:"metadata"`
+	Resources  []Resource             `json:"resources" yaml:"resources"`
+	Status     *SnapshotStatus        `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// ParseAPIVersion returns the full snapshot API version for v, which may be
+// given with or without the group, e.g. "v1alpha1"
+func ParseAPIVersion(v string) (string, error) {
+	if !strings.Contains(v, "/") {
+		v = "meshery.layer5.io/" + v
+	}
+	for _, supported := range SupportedAPIVersions {
+		if v == supported {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported snapshot apiVersion %q (supported: %s)", v, strings.Join(SupportedAPIVersions, ", "))
+}
+
+// JSONSchema returns the published JSON Schema for a snapshot API version
+func JSONSchema(apiVersion string) ([]byte, error) {
+	apiVersion, err := ParseAPIVersion(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	return schemas.ReadFile("schema/snapshot-" + path.Base(apiVersion) + ".json")
+}
+
+// ConvertV1Alpha1ToV1Alpha2 upgrades a v1alpha1 snapshot. Well-known
+// metadata keys map to typed fields; any others are kept as annotations.
+func ConvertV1Alpha1ToV1Alpha2(in *SnapshotV1Alpha1) *Snapshot {
+	return &Snapshot{
+		APIVersion: APIVersionV1Alpha2,
+		Kind:       in.Kind,
+		Metadata:   convertMetadataFromV1Alpha1(in.Metadata),
+		Resources:  in.Resources,
+		Status:     in.Status,
+	}
+}
+
+// ConvertV1Alpha2ToV1Alpha1 downgrades a snapshot to v1alpha1, flattening
+// the typed metadata into a map
+func ConvertV1Alpha2ToV1Alpha1(in *Snapshot) *SnapshotV1Alpha1 {
+	return &SnapshotV1Alpha1{
+		APIVersion: APIVersionV1Alpha1,
+		Kind:       in.Kind,
+		Metadata:   convertMetadataToV1Alpha1(in.Metadata),
+		Resources:  in.Resources,
+		Status:     in.Status,
+	}
+}
+
+// convertMetadataFromV1Alpha1 maps v1alpha1 metadata onto SnapshotMetadata
+func convertMetadataFromV1Alpha1(in map[string]interface{}) SnapshotMetadata {
+	var out SnapshotMetadata
+	for key, value := range in {
+		var err error
+		switch key {
+		case "name":
+			out.Name = metadataString(value)
+		case "timestamp":
+			out.Timestamp = metadataString(value)
+		case "labels":
+			err = remarshal(value, &out.Labels)
+		case "annotations":
+			err = remarshal(value, &out.Annotations)
+		case "cluster":
+			err = remarshal(value, &out.Cluster)
+		case "capture":
+			err = remarshal(value, &out.Capture)
+		default:
+			err = fmt.Errorf("no typed field")
+		}
+
+		// Keep anything that does not fit a typed field as an annotation
+		if err != nil {
+			if out.Annotations == nil {
+				out.Annotations = map[string]string{}
+			}
+			out.Annotations[key] = metadataString(value)
+		}
+	}
+	return out
+}
+
+// convertMetadataToV1Alpha1 flattens SnapshotMetadata into v1alpha1 metadata
+func convertMetadataToV1Alpha1(in SnapshotMetadata) map[string]interface{} {
+	out := map[string]interface{}{}
+
+	// Annotations that came from v1alpha1 keys go back to the top level
+	for key, value := range in.Annotations {
+		out[key] = value
+	}
+
+	out["name"] = in.Name
+	if in.Timestamp != "" {
+		out["timestamp"] = in.Timestamp
+	}
+	for key, value := range map[string]interface{}{
+		"labels":  in.Labels,
+		"cluster": in.Cluster,
+		"capture": in.Capture,
+	} {
+		var converted map[string]interface{}
+		if remarshal(value, &converted) == nil && len(converted) > 0 {
+			out[key] = converted
+		}
+	}
+	return out
+}
+
+// metadataString returns a metadata value as a string, JSON encoding values
+// that are not strings already
+func metadataString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// remarshal converts between representations of the same value by a JSON round trip
+func remarshal(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,158 @@
+package meshsync
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+		wantErr  bool
+	}{
+		{version: "v1alpha1", expected: APIVersionV1Alpha1},
+		{version: "meshery.layer5.io/v1alpha2", expected: APIVersionV1Alpha2},
+		{version: "v1", wantErr: true},
+		{version: "example.com/v1alpha1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			version, err := ParseAPIVersion(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAPIVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if version != tt.expected {
+				t.Errorf("ParseAPIVersion() = %s, want %s", version, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConvertV1Alpha1(t *testing.T) {
+	metadata := map[string]interface{}{
+		"name":      "nightly",
+		"timestamp": "2024-01-02T03:04:05Z",
+		"labels":    map[string]interface{}{"env": "prod"},
+		"cluster":   map[string]interface{}{"id": "cluster-uid", "nodeCount": float64(3)},
+		"capture": map[string]interface{}{
+			"pluginVersion": "v0.2.0",
+			"filters":       map[string]interface{}{"allNamespaces": true, "kinds": []interface{}{"deployments.apps"}},
+		},
+		"owner": "platform-team",
+	}
+	v1alpha1 := &SnapshotV1Alpha1{
+		APIVersion: APIVersionV1Alpha1,
+		Kind:       SnapshotKind,
+		Metadata:   metadata,
+		Resources:  newTestSnapshot(2, nil).Resources,
+	}
+
+	upgraded := ConvertV1Alpha1ToV1Alpha2(v1alpha1)
+	if upgraded.APIVersion != APIVersionV1Alpha2 || len(upgraded.Resources) != 2 {
+		t.Fatalf("Upgraded snapshot = %s with %d resources, want %s with 2", upgraded.APIVersion, len(upgraded.Resources), APIVersionV1Alpha2)
+	}
+	m := upgraded.Metadata
+	if m.Name != "nightly" || m.Timestamp != "2024-01-02T03:04:05Z" || m.Labels["env"] != "prod" {
+		t.Errorf("Upgraded metadata = %+v, want name, timestamp and labels carried over", m)
+	}
+	if m.Cluster == nil || m.Cluster.ID != "cluster-uid" || m.Cluster.NodeCount == nil || *m.Cluster.NodeCount != 3 {
+		t.Errorf("Upgraded cluster = %+v, want id cluster-uid and 3 nodes", m.Cluster)
+	}
+	if m.Capture == nil || !m.Capture.Filters.AllNamespaces || len(m.Capture.Filters.Kinds) != 1 {
+		t.Errorf("Upgraded capture = %+v, want the filters carried over", m.Capture)
+	}
+	if m.Annotations["owner"] != "platform-team" {
+		t.Errorf("Upgraded annotations = %v, want unknown keys kept", m.Annotations)
+	}
+
+	// Converting back gives the original metadata
+	downgraded := ConvertV1Alpha2ToV1Alpha1(upgraded)
+	if downgraded.APIVersion != APIVersionV1Alpha1 {
+		t.Errorf("Downgraded apiVersion = %s, want %s", downgraded.APIVersion, APIVersionV1Alpha1)
+	}
+	if !reflect.DeepEqual(downgraded.Metadata, metadata) {
+		t.Errorf("Downgraded metadata = %v, want %v", downgraded.Metadata, metadata)
+	}
+}
+
+func TestConvertV1Alpha1UnknownValues(t *testing.T) {
+	upgraded := ConvertV1Alpha1ToV1Alpha2(&SnapshotV1Alpha1{
+		Kind: SnapshotKind,
+		Metadata: map[string]interface{}{
+			"name":   "test",
+			"labels": "not-a-map",
+			"extra":  map[string]interface{}{"replicas": float64(2)},
+		},
+	})
+
+	// Values that do not fit a typed field are JSON encoded into annotations
+	expected := map[string]string{"labels": "not-a-map", "extra": `{"replicas":2}`}
+	if !reflect.DeepEqual(upgraded.Metadata.Annotations, expected) {
+		t.Errorf("Annotations = %v, want %v", upgraded.Metadata.Annotations, expected)
+	}
+}
+
+// TestJSONSchemaMatchesTypes checks that the published schema describes the
+// same fields as the Go types
+func TestJSONSchemaMatchesTypes(t *testing.T) {
+	data, err := JSONSchema(SnapshotAPIVersion)
+	if err != nil {
+		t.Fatalf("JSONSchema() error = %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+
+	checkSchemaProperties(t, "metadata", defs["metadata"], reflect.TypeOf(SnapshotMetadata{}))
+	checkSchemaProperties(t, "status", defs["status"], reflect.TypeOf(SnapshotStatus{}))
+
+	for _, version := range SupportedAPIVersions {
+		if _, err := JSONSchema(version); err != nil {
+			t.Errorf("JSONSchema(%s) error = %v", version, err)
+		}
+	}
+}
+
+// checkSchemaProperties compares the properties of a schema object with the
+// JSON fields of a struct, descending into nested objects and arrays
+func checkSchemaProperties(t *testing.T, path string, schema interface{}, typ reflect.Type) {
+	t.Helper()
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+		if items, ok := schema.(map[string]interface{})["items"]; ok {
+			schema = items
+		}
+	}
+	properties, _ := schema.(map[string]interface{})["properties"].(map[string]interface{})
+	if typ.Kind() != reflect.Struct || properties == nil {
+		return
+	}
+
+	var fields, props []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fields = append(fields, name)
+		if prop, ok := properties[name]; ok {
+			checkSchemaProperties(t, path+"."+name, prop, field.Type)
+		}
+	}
+	for name := range properties {
+		props = append(props, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(props)
+	if !reflect.DeepEqual(fields, props) {
+		t.Errorf("Schema %s properties = %v, want %v", path, props, fields)
+	}
+}
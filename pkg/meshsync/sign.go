@@ -0,0 +1,386 @@
+package meshsync
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SignatureKind is the kind of detached snapshot signature files
+const SignatureKind = "SnapshotSignature"
+
+// SignatureExtension is appended to a snapshot path to find its signature
+const SignatureExtension = ".sig"
+
+// Signature algorithms. ECDSA signatures are ASN.1 encoded over the SHA-256
+// of the signed message, for any curve.
+const (
+	SignatureAlgorithmEd25519 = "ed25519"
+	SignatureAlgorithmECDSA   = "ecdsa-sha256"
+)
+
+// CanonicalizationV1 is the canonical form of a snapshot that signatures
+// cover: the snapshot as a meshery.layer5.io/v1alpha2 document, in the
+// layout of that version's published JSON Schema, encoded as compact JSON
+// with object keys sorted. Numbers are normalized as resources decode them:
+// integers that fit in an int64 are written exactly in decimal, and any
+// other number as the shortest decimal that round-trips its float64, so
+// 2.50 and 25e-1 are both 2.5 and 1e3 is 1000. It depends on
+// neither the Go types a snapshot is loaded into nor the version it is
+// stored in, so a change to either leaves existing signatures valid; a
+// canonical form that changes is given a new version instead.
+const CanonicalizationV1 = "meshsync-snapshot-canonical/v1"
+
+// signatureContext starts every signed message, so a snapshot signature
+// cannot be passed off as a signature over anything else
+const signatureContext = "meshsync-snapshot-signature/v1"
+
+// ErrSignatureInvalid is wrapped by errors for signatures that do not match
+// the snapshot or the trusted key
+var ErrSignatureInvalid = errors.New("snapshot signature is invalid")
+
+// ErrNoTrustedKey is wrapped by errors verifying a signature without a
+// trusted key. The public key a signature carries cannot be trusted on its
+// own, since anyone who changes a snapshot can sign it again with theirs.
+var ErrNoTrustedKey = errors.New("no trusted key to verify the signature with")
+
+// SnapshotSignature is a detached signature over the canonical bytes of a
+// snapshot, stored next to it with SignatureExtension
+type SnapshotSignature struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Algorithm  string `json:"algorithm"`
+	// Signer identifies who signed, such as an email address. It is part
+	// of the signed message.
+	Signer string `json:"signer"`
+	// KeyID is the SHA-256 fingerprint of the public key
+	KeyID string `json:"keyID"`
+	// PublicKey is the PEM encoded public key that made the signature
+	PublicKey string `json:"publicKey"`
+	// Canonicalization is the canonical form of the snapshot the digest is
+	// over, such as CanonicalizationV1. It is part of the signed message.
+	Canonicalization string `json:"canonicalization"`
+	// Digest is the SHA-256 of the canonical snapshot bytes
+	Digest    string `json:"digest"`
+	Timestamp string `json:"timestamp"`
+	Signature string `json:"signature"`
+}
+
+// SignaturePath returns the path of the detached signature of a snapshot,
+// keeping the query of a sink URL at the end
+func SignaturePath(snapshotPath string) string {
+	if IsSinkURL(snapshotPath) {
+		location, query, ok := strings.Cut(snapshotPath, "?")
+		if ok {
+			return location + SignatureExtension + "?" + query
+		}
+	}
+	return snapshotPath + SignatureExtension
+}
+
+// CanonicalSnapshot returns the bytes a snapshot signature covers, in the
+// given canonical form. The same snapshot has the same canonical bytes in
+// any format and compression, so converting or compressing a snapshot keeps
+// its signature valid, while any change to its content does not.
+func CanonicalSnapshot(snapshot *Snapshot, canonicalization string) ([]byte, error) {
+	switch canonicalization {
+	case CanonicalizationV1:
+		return canonicalSnapshotV1(snapshot)
+	case "":
+		return nil, fmt.Errorf("no canonical form given")
+	default:
+		return nil, fmt.Errorf("unsupported canonical form %q, want %s", canonicalization, CanonicalizationV1)
+	}
+}
+
+// canonicalSnapshotV1 encodes a snapshot in CanonicalizationV1. The
+// snapshot is decoded into plain JSON values first, so the struct field
+// order does not matter and maps encode with sorted keys.
+func canonicalSnapshotV1(snapshot *Snapshot) ([]byte, error) {
+	// CanonicalizationV1 is defined over v1alpha2 documents; a later
+	// SnapshotAPIVersion must be converted back to v1alpha2 here
+	if snapshot.APIVersion != APIVersionV1Alpha2 {
+		return nil, fmt.Errorf("canonical form %s covers %s snapshots, not %s", CanonicalizationV1, APIVersionV1Alpha2, snapshot.APIVersion)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	data, err = json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// LoadSigningKey reads a PEM encoded Ed25519 or ECDSA private key, in
+// PKCS#8 or, for ECDSA, SEC 1 form as written by openssl
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported signing key %s: want a PEM PRIVATE KEY or EC PRIVATE KEY, not %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key %s: want an Ed25519 or ECDSA key, not %T", path, key)
+	}
+}
+
+// LoadPublicKeys reads a keyring of one or more PEM encoded Ed25519 or
+// ECDSA public keys, such as a single key written by openssl or several
+// concatenated
+func LoadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("unsupported public key %s: want a PEM PUBLIC KEY, not %s", path, block.Type)
+		}
+		key, err := parsePublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("invalid key %s: no PEM data found", path)
+	}
+	return keys, nil
+}
+
+// readPEM reads the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid key %s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// parsePublicKey parses a DER encoded PKIX Ed25519 or ECDSA public key
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key: want an Ed25519 or ECDSA key, not %T", key)
+	}
+}
+
+// KeyID returns the SHA-256 fingerprint of a public key, as
+// SHA256:<unpadded base64> like ssh-keygen prints
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// signedMessage returns the message a signature is made over, binding the
+// snapshot digest to the algorithm, signer, time and canonical form
+func (s *SnapshotSignature) signedMessage() []byte {
+	return []byte(strings.Join([]string{signatureContext, s.Algorithm, s.Signer, s.Timestamp, s.Canonicalization, s.Digest}, "\n"))
+}
+
+// SignSnapshot signs the canonical bytes of a snapshot, in
+// CanonicalizationV1, as signer
+func SignSnapshot(snapshot *Snapshot, key crypto.Signer, signer string) (*SnapshotSignature, error) {
+	canonical, err := CanonicalSnapshot(snapshot, CanonicalizationV1)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(canonical)
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig := &SnapshotSignature{
+		APIVersion:       SnapshotAPIVersion,
+		Kind:             SignatureKind,
+		Signer:           signer,
+		KeyID:            keyID,
+		PublicKey:        string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Canonicalization: CanonicalizationV1,
+		Digest:           "sha256:" + hex.EncodeToString(digest[:]),
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	var signature []byte
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		sig.Algorithm = SignatureAlgorithmEd25519
+		signature, err = key.Sign(rand.Reader, sig.signedMessage(), crypto.Hash(0))
+	case *ecdsa.PrivateKey:
+		sig.Algorithm = SignatureAlgorithmECDSA
+		hash := sha256.Sum256(sig.signedMessage())
+		signature, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported signing key %T", key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign snapshot: %w", err)
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(signature)
+	return sig, nil
+}
+
+// VerifySnapshot checks a signature against the canonical bytes of a
+// snapshot and that it was made by one of the trusted keys. Without trusted
+// keys it fails with ErrNoTrustedKey; other failures wrap
+// ErrSignatureInvalid.
+func VerifySnapshot(snapshot *Snapshot, sig *SnapshotSignature, trusted []crypto.PublicKey) error {
+	if len(trusted) == 0 {
+		return ErrNoTrustedKey
+	}
+	if sig.Kind != SignatureKind {
+		return fmt.Errorf("%w: unexpected kind %q, want %s", ErrSignatureInvalid, sig.Kind, SignatureKind)
+	}
+	block, _ := pem.Decode([]byte(sig.PublicKey))
+	if block == nil {
+		return fmt.Errorf("%w: no public key", ErrSignatureInvalid)
+	}
+	key, err := parsePublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	// Compare fingerprints, since the key types have no common Equal
+	keyID, err := KeyID(key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	if !isTrusted(keyID, trusted) {
+		return fmt.Errorf("%w: signed with key %s, which is not a trusted key", ErrSignatureInvalid, keyID)
+	}
+
+	// The canonical form is checked by the signature below, so a signature
+	// cannot be moved to another form
+	canonical, err := CanonicalSnapshot(snapshot, sig.Canonicalization)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	digest := sha256.Sum256(canonical)
+	if sig.Digest != "sha256:"+hex.EncodeToString(digest[:]) {
+		return fmt.Errorf("%w: the snapshot was changed after it was signed", ErrSignatureInvalid)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	var valid bool
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		valid = sig.Algorithm == SignatureAlgorithmEd25519 && ed25519.Verify(key, sig.signedMessage(), signature)
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(sig.signedMessage())
+		valid = sig.Algorithm == SignatureAlgorithmECDSA && ecdsa.VerifyASN1(key, hash[:], signature)
+	}
+	if !valid {
+		return fmt.Errorf("%w: the signature does not match its signer, time or snapshot digest", ErrSignatureInvalid)
+	}
+	return nil
+}
+
+// isTrusted reports whether keyID is the fingerprint of one of the trusted
+// keys
+func isTrusted(keyID string, trusted []crypto.PublicKey) bool {
+	for _, key := range trusted {
+		if id, err := KeyID(key); err == nil && id == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveSignature writes a signature to filePath, which may be a sink URL
+func SaveSignature(sig *SnapshotSignature, filePath string, overwrite bool) error {
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode signature: %w", err)
+	}
+	if err := SaveSnapshotData(bytes.NewReader(append(data, '\n')), filePath, overwrite); err != nil {
+		return fmt.Errorf("failed to save signature: %w", err)
+	}
+	return nil
+}
+
+// LoadSignature reads a signature from filePath, which may be a sink URL.
+// A missing signature is an error wrapping fs.ErrNotExist.
+func LoadSignature(filePath string) (*SnapshotSignature, error) {
+	reader, err := OpenSnapshotData(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	defer reader.Close()
+	// Sinks such as ConfigMaps may store it compressed
+	decompressed, err := NewDecompressor(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	defer decompressed.Close()
+
+	var sig SnapshotSignature
+	if err := json.NewDecoder(io.LimitReader(decompressed, 1<<20)).Decode(&sig); err != nil {
+		return nil, fmt.Errorf("failed to parse signature %s: %w", filePath, err)
+	}
+	return &sig, nil
+}
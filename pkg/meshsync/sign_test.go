@@ -0,0 +1,275 @@
+package meshsync
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeys writes a private key and its public key as PEM files
+func writeKeys(t *testing.T, dir string, key crypto.Signer, privateType string) (string, string) {
+	t.Helper()
+	var der []byte
+	var err error
+	if privateType == "EC PRIVATE KEY" {
+		der, err = x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem")
+	os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: privateType, Bytes: der}), 0600)
+	os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600)
+	return privatePath, publicPath
+}
+
+func TestSignSnapshot(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	tests := []struct {
+		name          string
+		key           crypto.Signer
+		privateType   string
+		wantAlgorithm string
+	}{
+		{name: "Ed25519", key: edKey, privateType: "PRIVATE KEY", wantAlgorithm: SignatureAlgorithmEd25519},
+		{name: "ECDSA PKCS#8", key: ecKey, privateType: "PRIVATE KEY", wantAlgorithm: SignatureAlgorithmECDSA},
+		{name: "ECDSA SEC 1", key: otherKey, privateType: "EC PRIVATE KEY", wantAlgorithm: SignatureAlgorithmECDSA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			privatePath, publicPath := writeKeys(t, dir, tt.key, tt.privateType)
+			key, err := LoadSigningKey(privatePath)
+			if err != nil {
+				t.Fatalf("LoadSigningKey() error = %v", err)
+			}
+			trusted, err := LoadPublicKeys(publicPath)
+			if err != nil {
+				t.Fatalf("LoadPublicKeys() error = %v", err)
+			}
+
+			snapshot := newTestSnapshot(3, &SnapshotStatus{Counts: map[string]int{"Deployment.apps": 3}})
+			sig, err := SignSnapshot(snapshot, key, "alice@example.com")
+			if err != nil {
+				t.Fatalf("SignSnapshot() error = %v", err)
+			}
+			if sig.Algorithm != tt.wantAlgorithm || sig.Signer != "alice@example.com" || !strings.HasPrefix(sig.KeyID, "SHA256:") || sig.Canonicalization != CanonicalizationV1 {
+				t.Errorf("SignSnapshot() = %+v", sig)
+			}
+			if err := VerifySnapshot(snapshot, sig, trusted); err != nil {
+				t.Errorf("VerifySnapshot() error = %v", err)
+			}
+
+			// The signature covers the content, not the encoding
+			converted := filepath.Join(dir, "snapshot.json.zst")
+			if err := SaveSnapshot(snapshot, converted, SnapshotFileOptions{Format: FormatNDJSON, Compression: CompressionZstd}); err != nil {
+				t.Fatalf("SaveSnapshot() error = %v", err)
+			}
+			loaded, err := LoadSnapshot(converted)
+			if err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if err := VerifySnapshot(loaded, sig, trusted); err != nil {
+				t.Errorf("VerifySnapshot() of the converted snapshot error = %v", err)
+			}
+
+			// Any change to the snapshot, signer or key is caught
+			loaded.Resources[0].Object["metadata"].(map[string]interface{})["name"] = "edited"
+			if err := VerifySnapshot(loaded, sig, trusted); !errors.Is(err, ErrSignatureInvalid) {
+				t.Errorf("VerifySnapshot() of an edited snapshot error = %v, want ErrSignatureInvalid", err)
+			}
+			forged := *sig
+			forged.Signer = "mallory@example.com"
+			if err := VerifySnapshot(snapshot, &forged, trusted); !errors.Is(err, ErrSignatureInvalid) {
+				t.Errorf("VerifySnapshot() with a changed signer error = %v, want ErrSignatureInvalid", err)
+			}
+			for _, canonicalization := range []string{"", "meshsync-snapshot-canonical/v2"} {
+				forged := *sig
+				forged.Canonicalization = canonicalization
+				if err := VerifySnapshot(snapshot, &forged, trusted); !errors.Is(err, ErrSignatureInvalid) {
+					t.Errorf("VerifySnapshot() with canonical form %q error = %v, want ErrSignatureInvalid", canonicalization, err)
+				}
+			}
+			_, untrusted, _ := ed25519.GenerateKey(rand.Reader)
+			if err := VerifySnapshot(snapshot, sig, []crypto.PublicKey{untrusted.Public()}); err == nil || !strings.Contains(err.Error(), "not a trusted key") {
+				t.Errorf("VerifySnapshot() with another trusted key error = %v, want not a trusted key", err)
+			}
+			// The key the signature carries is not trusted on its own
+			if err := VerifySnapshot(snapshot, sig, nil); !errors.Is(err, ErrNoTrustedKey) {
+				t.Errorf("VerifySnapshot() without a trusted key error = %v, want ErrNoTrustedKey", err)
+			}
+		})
+	}
+}
+
+func TestCanonicalSnapshot(t *testing.T) {
+	snapshot := newTestSnapshot(1, &SnapshotStatus{Counts: map[string]int{"Pod": 1}})
+	snapshot.Metadata.Labels = map[string]string{"env": "prod"}
+	snapshot.Resources[0].Object["spec"].(map[string]interface{})["priority"] = int64(9007199254740993)
+
+	// The canonical form is fixed, whatever the layout of the Go types:
+	// changing these bytes breaks every signature made so far
+	want := `{"apiVersion":"meshery.layer5.io/v1alpha2","kind":"MeshSync","metadata":{"labels":{"env":"prod"},"name":"test-snapshot"},` +
+		`"resources":[{"apiVersion":"v1","kind":"Pod","metadata":{"name":"pod-0","namespace":"default"},"spec":{"containers":[{"image":"nginx:latest","name":"nginx"}],"priority":9007199254740993}}],` +
+		`"status":{"counts":{"Pod":1}}}`
+	got, err := CanonicalSnapshot(snapshot, CanonicalizationV1)
+	if err != nil {
+		t.Fatalf("CanonicalSnapshot() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("CanonicalSnapshot() = %s, want %s", got, want)
+	}
+
+	// Numbers are normalized the same way whatever format they were read from
+	for _, data := range []string{
+		`{"apiVersion":"meshery.layer5.io/v1alpha2","kind":"MeshSync","metadata":{"name":"test"},"resources":[{"spec":{"big":9007199254740993,"ratio":2.50,"exp":1e3}}]}`,
+		"apiVersion: meshery.layer5.io/v1alpha2\nkind: MeshSync\nmetadata:\n  name: test\nresources:\n- spec:\n    big: 9007199254740993\n    ratio: 25e-1\n    exp: 1000\n",
+	} {
+		decoded, err := DecodeSnapshot([]byte(data))
+		if err != nil {
+			t.Fatalf("DecodeSnapshot() error = %v", err)
+		}
+		got, err := CanonicalSnapshot(decoded, CanonicalizationV1)
+		if err != nil {
+			t.Fatalf("CanonicalSnapshot() error = %v", err)
+		}
+		if want := `"resources":[{"spec":{"big":9007199254740993,"exp":1000,"ratio":2.5}}]`; !strings.Contains(string(got), want) {
+			t.Errorf("CanonicalSnapshot() = %s, want %s", got, want)
+		}
+	}
+
+	for _, canonicalization := range []string{"", "meshsync-snapshot-canonical/v2"} {
+		if _, err := CanonicalSnapshot(snapshot, canonicalization); err == nil {
+			t.Errorf("CanonicalSnapshot(%q) error = nil, want an unsupported canonical form", canonicalization)
+		}
+	}
+	snapshot.APIVersion = APIVersionV1Alpha1
+	if _, err := CanonicalSnapshot(snapshot, CanonicalizationV1); err == nil {
+		t.Errorf("CanonicalSnapshot() of a %s snapshot error = nil, want an error", APIVersionV1Alpha1)
+	}
+}
+
+func TestSaveLoadSignature(t *testing.T) {
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	snapshot := newTestSnapshot(1, nil)
+	sig, err := SignSnapshot(snapshot, key, "ci")
+	if err != nil {
+		t.Fatalf("SignSnapshot() error = %v", err)
+	}
+
+	path := SignaturePath(filepath.Join(dir, "snapshot.yaml.gz"))
+	if err := SaveSignature(sig, path, false); err != nil {
+		t.Fatalf("SaveSignature() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != SnapshotFileMode {
+		t.Errorf("Signature file mode = %v, %v, want %v", info, err, SnapshotFileMode)
+	}
+	loaded, err := LoadSignature(path)
+	if err != nil {
+		t.Fatalf("LoadSignature() error = %v", err)
+	}
+	if err := VerifySnapshot(snapshot, loaded, []crypto.PublicKey{key.Public()}); err != nil {
+		t.Errorf("VerifySnapshot() of the loaded signature error = %v", err)
+	}
+	if err := SaveSignature(sig, path, false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveSignature() over an existing file error = %v, want fs.ErrExist", err)
+	}
+	if _, err := LoadSignature(filepath.Join(dir, "missing.sig")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSignature() of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestSignaturePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "snapshot.yaml.gz", want: "snapshot.yaml.gz.sig"},
+		{path: "s3://snapshots/prod.yaml.gz?region=eu-west-1", want: "s3://snapshots/prod.yaml.gz.sig?region=eu-west-1"},
+		{path: "configmap://meshery/prod", want: "configmap://meshery/prod.sig"},
+	}
+	for _, tt := range tests {
+		if got := SignaturePath(tt.path); got != tt.want {
+			t.Errorf("SignaturePath(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, firstPath := writeKeys(t, t.TempDir(), ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)), "PRIVATE KEY")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	privatePath, secondPath := writeKeys(t, t.TempDir(), ecKey, "EC PRIVATE KEY")
+	first, _ := os.ReadFile(firstPath)
+	second, _ := os.ReadFile(secondPath)
+	keyring := filepath.Join(dir, "keyring.pem")
+	os.WriteFile(keyring, append(first, second...), 0600)
+
+	keys, err := LoadPublicKeys(keyring)
+	if err != nil {
+		t.Fatalf("LoadPublicKeys() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("LoadPublicKeys() read %d keys, want 2", len(keys))
+	}
+	sig, err := SignSnapshot(newTestSnapshot(1, nil), ecKey, "ci")
+	if err != nil {
+		t.Fatalf("SignSnapshot() error = %v", err)
+	}
+	if err := VerifySnapshot(newTestSnapshot(1, nil), sig, keys); err != nil {
+		t.Errorf("VerifySnapshot() with a keyring error = %v", err)
+	}
+
+	notPEM := filepath.Join(dir, "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+	for path, wantErr := range map[string]string{
+		notPEM:                        "no PEM data",
+		privatePath:                   "want a PEM PUBLIC KEY",
+		filepath.Join(dir, "missing"): "failed to read key",
+	} {
+		if _, err := LoadPublicKeys(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("LoadPublicKeys(%s) error = %v, want %q", filepath.Base(path), err, wantErr)
+		}
+	}
+}
+
+func TestLoadSigningKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+	publicOnly := filepath.Join(dir, "public.pem")
+	_, publicPath := writeKeys(t, dir, ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)), "PRIVATE KEY")
+	data, _ := os.ReadFile(publicPath)
+	os.WriteFile(publicOnly, data, 0600)
+
+	for path, wantErr := range map[string]string{
+		notPEM:                        "no PEM data",
+		publicOnly:                    "want a PEM PRIVATE KEY",
+		filepath.Join(dir, "missing"): "failed to read key",
+	} {
+		if _, err := LoadSigningKey(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("LoadSigningKey(%s) error = %v, want %q", filepath.Base(path), err, wantErr)
+		}
+	}
+}
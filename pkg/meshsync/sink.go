@@ -0,0 +1,167 @@
+package meshsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/s3"
+)
+
+// Sink stores snapshot files outside the local filesystem, at a location
+// given as a URL such as s3://bucket/prefix/snapshot.yaml.gz
+type Sink interface {
+	// Exists reports whether a snapshot is stored at the location
+	Exists(ctx context.Context) (bool, error)
+	// Put stores size bytes read from r. Without overwrite, it fails with
+	// an error wrapping fs.ErrExist if a snapshot is already stored.
+	Put(ctx context.Context, r io.ReadSeeker, size int64, overwrite bool) error
+	// Open reads the stored snapshot. The caller closes the reader.
+	Open(ctx context.Context) (io.ReadCloser, error)
+}
+
+// SinkOpener opens the sink for a location URL
+type SinkOpener func(location *url.URL) (Sink, error)
+
+// sinkOpeners maps URL schemes to the sinks that handle them
+var sinkOpeners = map[string]SinkOpener{
+	"s3":            OpenS3Sink,
+	ConfigMapScheme: OpenConfigMapSink,
+	PVCScheme:       OpenPVCSink,
+}
+
+// SinkPruner is implemented by sinks that can remove old snapshots
+type SinkPruner interface {
+	// Prune removes all but the newest keep snapshots matching the sink's
+	// location, which holds a * in place of the timestamp, and returns the
+	// locations of those removed
+	Prune(ctx context.Context, keep int) ([]string, error)
+}
+
+// SinkPrunes reports whether the sink for a location can remove old
+// snapshots, for --keep-last and scheduled retention
+func SinkPrunes(location string) bool {
+	scheme, _, _ := strings.Cut(location, "://")
+	return scheme == ConfigMapScheme || scheme == PVCScheme
+}
+
+// RegisterSink makes SaveSnapshot and LoadSnapshot handle locations with
+// the given URL scheme through sinks opened by open
+func RegisterSink(scheme string, open SinkOpener) {
+	sinkOpeners[scheme] = open
+}
+
+// IsSinkURL reports whether a snapshot path is a URL with a registered
+// sink scheme rather than a local file
+func IsSinkURL(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	return ok && sinkOpeners[scheme] != nil
+}
+
+// OpenSink opens the sink for a location URL
+func OpenSink(location string) (Sink, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot location: %w", err)
+	}
+	open := sinkOpeners[u.Scheme]
+	if open == nil {
+		return nil, fmt.Errorf("unsupported snapshot location %q", location)
+	}
+	return open(u)
+}
+
+// trimQuery returns a path without the query of a sink URL, for looking at
+// its extension
+func trimQuery(path string) string {
+	if IsSinkURL(path) {
+		path, _, _ = strings.Cut(path, "?")
+	}
+	return path
+}
+
+// S3Sink stores a snapshot as an object in an S3-compatible object store
+type S3Sink struct {
+	Client *s3.Client
+	Bucket string
+	Key    string
+}
+
+// OpenS3Sink opens an s3://bucket/key location. Credentials, region and
+// endpoint are found by the default AWS configuration chain; the query can
+// set endpoint, region, path-style, sse and sse-kms-key-id.
+func OpenS3Sink(location *url.URL) (Sink, error) {
+	key := strings.TrimPrefix(location.Path, "/")
+	if location.Host == "" || key == "" || strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("invalid S3 location %s, want s3://bucket/path/snapshot.yaml.gz", location.Redacted())
+	}
+
+	cfg := s3.Config{}
+	query := location.Query()
+	for name := range query {
+		value := query.Get(name)
+		switch name {
+		case "endpoint":
+			cfg.Endpoint = value
+		case "region":
+			cfg.Region = value
+		case "path-style":
+			pathStyle, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path-style %q in S3 location: %w", value, err)
+			}
+			cfg.PathStyle = pathStyle
+		case "sse":
+			cfg.SSE = value
+		case "sse-kms-key-id":
+			cfg.SSEKMSKeyID = value
+		default:
+			return nil, fmt.Errorf("unknown S3 location option %q (supported: endpoint, region, path-style, sse, sse-kms-key-id)", name)
+		}
+	}
+
+	client, err := s3.NewClient(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{Client: client, Bucket: location.Host, Key: key}, nil
+}
+
+// Exists reports whether the object exists
+func (s *S3Sink) Exists(ctx context.Context) (bool, error) {
+	exists, err := s.Client.HeadObject(ctx, s.Bucket, s.Key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for snapshot s3://%s/%s: %w", s.Bucket, s.Key, err)
+	}
+	return exists, nil
+}
+
+// Put uploads the snapshot, in parts when it is large. Without overwrite,
+// the upload is conditional on the object not existing, so a snapshot
+// stored meanwhile is kept.
+func (s *S3Sink) Put(ctx context.Context, r io.ReadSeeker, size int64, overwrite bool) error {
+	err := s.Client.PutObject(ctx, s.Bucket, s.Key, r, s3.PutOptions{IfNoneMatch: !overwrite})
+	if s3.IsPreconditionFailed(err) {
+		return fmt.Errorf("snapshot s3://%s/%s: %w", s.Bucket, s.Key, fs.ErrExist)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload snapshot to s3://%s/%s: %w", s.Bucket, s.Key, err)
+	}
+	return nil
+}
+
+// Open downloads the snapshot
+func (s *S3Sink) Open(ctx context.Context) (io.ReadCloser, error) {
+	body, err := s.Client.GetObject(ctx, s.Bucket, s.Key)
+	if s3.IsNotFound(err) {
+		return nil, fmt.Errorf("snapshot s3://%s/%s: %w", s.Bucket, s.Key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot s3://%s/%s: %w", s.Bucket, s.Key, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,288 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// ConfigMapScheme is the URL scheme of snapshots stored in ConfigMaps,
+// configmap://namespace/name
+const ConfigMapScheme = "configmap"
+
+// configMapChunkSize is the most snapshot data kept in one ConfigMap.
+// Binary data grows by a third when stored as base64, so this keeps each
+// ConfigMap under the 1MiB object size limit.
+const configMapChunkSize = 700 << 10
+
+// Labels, annotations and keys of snapshot ConfigMaps. The ConfigMap named
+// in the location holds the first chunk and points at the others, which are
+// named after it and the checksum of the snapshot.
+const (
+	configMapRoleLabel        = "meshsync-snapshot/role"
+	configMapChunksAnnotation = "meshsync-snapshot/chunks"
+	configMapSHA256Annotation = "meshsync-snapshot/sha256"
+	configMapDataKey          = "snapshot"
+	configMapRoleHead         = "head"
+	configMapRoleChunk        = "chunk"
+)
+
+// ConfigMapSink stores a snapshot across ConfigMaps in the cluster
+type ConfigMapSink struct {
+	Client    *kube.Client
+	Namespace string
+	Name      string
+}
+
+// OpenConfigMapSink opens a configmap://namespace/name location with the
+// kubeconfig or in-cluster credentials
+func OpenConfigMapSink(location *url.URL) (Sink, error) {
+	client, err := kube.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+	return NewConfigMapSink(client, location)
+}
+
+// NewConfigMapSink opens a configmap://namespace/name location with the
+// given client. Names are lowercased, since ConfigMap names cannot hold
+// the capitals of {timestamp}; a * in the name matches any characters when
+// pruning.
+func NewConfigMapSink(client *kube.Client, location *url.URL) (*ConfigMapSink, error) {
+	name := strings.ToLower(strings.TrimPrefix(location.Path, "/"))
+	if location.Host == "" || name == "" || strings.Contains(name, "/") || location.RawQuery != "" {
+		return nil, fmt.Errorf("invalid ConfigMap location %s, want configmap://namespace/name", location)
+	}
+	// Leave room for the suffix of the chunk names
+	if errs := validation.IsDNS1123Subdomain(strings.ReplaceAll(name, "*", "x") + "-0123456789-99"); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid ConfigMap name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return &ConfigMapSink{Client: client, Namespace: location.Host, Name: name}, nil
+}
+
+// location returns the URL of a snapshot in the sink's namespace
+func (s *ConfigMapSink) location(name string) string {
+	return ConfigMapScheme + "://" + s.Namespace + "/" + name
+}
+
+// chunkName returns the name of the ConfigMap that holds chunk i of the
+// snapshot with the given checksum
+func (s *ConfigMapSink) chunkName(name, sum string, i int) string {
+	return fmt.Sprintf("%s-%s-%d", name, sum[:10], i)
+}
+
+// Exists reports whether the ConfigMap holding the snapshot exists
+func (s *ConfigMapSink) Exists(ctx context.Context) (bool, error) {
+	_, err := s.Client.Clientset.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check for snapshot %s: %w", s.location(s.Name), err)
+	}
+	return true, nil
+}
+
+// Put stores the snapshot, compressed with gzip unless it already is or is
+// encrypted, since readers decrypt before they decompress. The chunks are written before the ConfigMap pointing at them, and the chunks
+// of a replaced snapshot removed after, so readers never see a mix.
+func (s *ConfigMapSink) Put(ctx context.Context, r io.ReadSeeker, size int64, overwrite bool) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) && !bytes.HasPrefix(data, zstdMagic) && !IsEncrypted(data) {
+		var compressed bytes.Buffer
+		compressor, err := NewCompressor(&compressed, CompressionGzip)
+		if err != nil {
+			return err
+		}
+		_, err = compressor.Write(data)
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to compress snapshot: %w", err)
+		}
+		data = compressed.Bytes()
+	}
+	checksum := sha256.Sum256(data)
+	sum := hex.EncodeToString(checksum[:])
+
+	configMaps := s.Client.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	existing, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		existing, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store snapshot %s: %w", s.location(s.Name), err)
+	}
+	if existing != nil && !overwrite {
+		return fmt.Errorf("snapshot %s: %w", s.location(s.Name), fs.ErrExist)
+	}
+
+	var chunks [][]byte
+	for len(data) > configMapChunkSize {
+		chunks = append(chunks, data[:configMapChunkSize])
+		data = data[configMapChunkSize:]
+	}
+	chunks = append(chunks, data)
+
+	for i := 1; i < len(chunks); i++ {
+		chunk := s.newConfigMap(s.chunkName(s.Name, sum, i), configMapRoleChunk, chunks[i])
+		_, err := configMaps.Create(ctx, chunk, metav1.CreateOptions{})
+		// A chunk of the same snapshot stored before holds the same data
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to store snapshot %s: %w", s.location(s.Name), err)
+		}
+	}
+
+	head := s.newConfigMap(s.Name, configMapRoleHead, chunks[0])
+	head.Annotations = map[string]string{
+		configMapChunksAnnotation: strconv.Itoa(len(chunks)),
+		configMapSHA256Annotation: sum,
+	}
+	if existing == nil {
+		_, err = configMaps.Create(ctx, head, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			err = fmt.Errorf("snapshot %s: %w", s.location(s.Name), fs.ErrExist)
+		}
+	} else {
+		head.ResourceVersion = existing.ResourceVersion
+		_, err = configMaps.Update(ctx, head, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		// Unless they are the chunks of the snapshot already stored
+		if existing == nil || existing.Annotations[configMapSHA256Annotation] != sum {
+			s.deleteChunks(ctx, s.Name, sum, len(chunks))
+		}
+		return fmt.Errorf("failed to store snapshot %s: %w", s.location(s.Name), err)
+	}
+
+	if existing != nil && existing.Annotations[configMapSHA256Annotation] != sum {
+		if err := s.deleteChunks(ctx, s.Name, existing.Annotations[configMapSHA256Annotation], chunkCount(existing)); err != nil {
+			return fmt.Errorf("failed to remove replaced snapshot chunks: %w", err)
+		}
+	}
+	return nil
+}
+
+// newConfigMap returns a ConfigMap holding a chunk of snapshot data
+func (s *ConfigMapSink) newConfigMap(name, role string, data []byte) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "kubectl-meshsync-snapshot",
+				configMapRoleLabel:             role,
+			},
+		},
+		BinaryData: map[string][]byte{configMapDataKey: data},
+	}
+}
+
+// chunkCount returns the number of chunks a head ConfigMap points at
+func chunkCount(head *corev1.ConfigMap) int {
+	chunks, err := strconv.Atoi(head.Annotations[configMapChunksAnnotation])
+	if err != nil || chunks < 1 {
+		return 1
+	}
+	return chunks
+}
+
+// deleteChunks removes the chunks after the first of a snapshot
+func (s *ConfigMapSink) deleteChunks(ctx context.Context, name, sum string, chunks int) error {
+	if len(sum) < 10 {
+		return nil
+	}
+	var errs []error
+	for i := 1; i < chunks; i++ {
+		err := s.Client.Clientset.CoreV1().ConfigMaps(s.Namespace).Delete(ctx, s.chunkName(name, sum, i), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Open reads the snapshot back from its chunks and verifies its checksum
+func (s *ConfigMapSink) Open(ctx context.Context) (io.ReadCloser, error) {
+	configMaps := s.Client.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	head, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("snapshot %s: %w", s.location(s.Name), fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", s.location(s.Name), err)
+	}
+	sum := head.Annotations[configMapSHA256Annotation]
+	if len(sum) < 10 {
+		return nil, fmt.Errorf("ConfigMap %s/%s does not hold a snapshot", s.Namespace, s.Name)
+	}
+
+	data := append([]byte{}, head.BinaryData[configMapDataKey]...)
+	for i := 1; i < chunkCount(head); i++ {
+		chunk, err := configMaps.Get(ctx, s.chunkName(s.Name, sum, i), metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d of snapshot %s: %w", i, s.location(s.Name), err)
+		}
+		data = append(data, chunk.BinaryData[configMapDataKey]...)
+	}
+	if checksum := sha256.Sum256(data); hex.EncodeToString(checksum[:]) != sum {
+		return nil, fmt.Errorf("snapshot %s is corrupt or changed while reading, its checksum does not match", s.location(s.Name))
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Prune removes all but the newest keep snapshots whose names match the
+// sink's name, which holds a * in place of the timestamp
+func (s *ConfigMapSink) Prune(ctx context.Context, keep int) ([]string, error) {
+	configMaps := s.Client.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	list, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: configMapRoleLabel + "=" + configMapRoleHead})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var heads []corev1.ConfigMap
+	for _, head := range list.Items {
+		if matched, _ := path.Match(s.Name, head.Name); matched {
+			heads = append(heads, head)
+		}
+	}
+	if len(heads) <= keep {
+		return nil, nil
+	}
+
+	// Timestamps sort lexically, so the oldest snapshots come first
+	sort.Slice(heads, func(i, j int) bool { return heads[i].Name < heads[j].Name })
+	var removed []string
+	for _, head := range heads[:len(heads)-keep] {
+		err := configMaps.Delete(ctx, head.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return removed, fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+		if err := s.deleteChunks(ctx, head.Name, head.Annotations[configMapSHA256Annotation], chunkCount(&head)); err != nil {
+			return removed, fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+		removed = append(removed, s.location(head.Name))
+	}
+	return removed, nil
+}
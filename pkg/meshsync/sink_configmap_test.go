@@ -0,0 +1,195 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// useSink makes locations with the scheme open through open for the test
+func useSink(t *testing.T, scheme string, open SinkOpener) {
+	previous := sinkOpeners[scheme]
+	RegisterSink(scheme, open)
+	t.Cleanup(func() { RegisterSink(scheme, previous) })
+}
+
+func TestConfigMapSink(t *testing.T) {
+	client, clientset := newFakeClient()
+	useSink(t, ConfigMapScheme, func(location *url.URL) (Sink, error) {
+		return NewConfigMapSink(client, location)
+	})
+	ctx := context.Background()
+
+	const location = "configmap://meshery/prod-20260101t120000z"
+	opts := SnapshotFileOptions{Format: FormatYAML, Compression: CompressionFromPath(location)}
+	if err := SaveSnapshot(newTestSnapshot(3, nil), location, opts); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	head, err := clientset.CoreV1().ConfigMaps("meshery").Get(ctx, "prod-20260101t120000z", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get snapshot ConfigMap: %v", err)
+	}
+	if data := head.BinaryData[configMapDataKey]; !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("ConfigMap data = %q, want a gzip snapshot", data)
+	}
+
+	snapshot, err := LoadSnapshot(location)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != 3 {
+		t.Errorf("LoadSnapshot() read %d resources, want 3", len(snapshot.Resources))
+	}
+	if err := SaveSnapshot(newTestSnapshot(1, nil), location, opts); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveSnapshot() to an existing snapshot error = %v, want fs.ErrExist", err)
+	}
+	if _, err := LoadSnapshot("configmap://meshery/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSnapshot() of a missing snapshot error = %v, want fs.ErrNotExist", err)
+	}
+
+	// Snapshots over the ConfigMap size limit are split into chunks
+	sink := &ConfigMapSink{Client: client, Namespace: "meshery", Name: "large"}
+	data := append(append([]byte{}, gzipMagic...), make([]byte, 2*configMapChunkSize)...)
+	rand.Read(data[len(gzipMagic):])
+	if err := sink.Put(ctx, bytes.NewReader(data), int64(len(data)), false); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got := countConfigMaps(t, client); got != 4 {
+		t.Errorf("Put() stored %d ConfigMaps, want 4", got)
+	}
+	if got := readSink(t, sink); !bytes.Equal(got, data) {
+		t.Errorf("Open() read %d bytes, want the %d bytes stored", len(got), len(data))
+	}
+
+	// Replacing a snapshot removes the chunks of the old one
+	if err := sink.Put(ctx, bytes.NewReader(data[:100]), 100, true); err != nil {
+		t.Fatalf("Put() with overwrite error = %v", err)
+	}
+	if got := countConfigMaps(t, client); got != 2 {
+		t.Errorf("Put() with overwrite left %d ConfigMaps, want 2", got)
+	}
+	if got := readSink(t, sink); !bytes.Equal(got, data[:100]) {
+		t.Errorf("Open() read %q after overwrite, want the new snapshot", got)
+	}
+
+	head, _ = clientset.CoreV1().ConfigMaps("meshery").Get(ctx, "large", metav1.GetOptions{})
+	head.BinaryData[configMapDataKey] = []byte("changed")
+	clientset.CoreV1().ConfigMaps("meshery").Update(ctx, head, metav1.UpdateOptions{})
+	if _, err := sink.Open(ctx); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Open() of a changed snapshot error = %v, want a checksum error", err)
+	}
+}
+
+func TestConfigMapSinkEncrypted(t *testing.T) {
+	client, clientset := newFakeClient()
+	useSink(t, ConfigMapScheme, func(location *url.URL) (Sink, error) {
+		return NewConfigMapSink(client, location)
+	})
+	identity, _ := age.GenerateX25519Identity()
+	useIdentities(t, identity)
+
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			location := "configmap://meshery/encrypted-" + compression
+			opts := SnapshotFileOptions{Format: FormatYAML, Compression: compression, Recipients: []age.Recipient{identity.Recipient()}}
+			if err := SaveSnapshot(newTestSnapshot(3, nil), location, opts); err != nil {
+				t.Fatalf("SaveSnapshot() error = %v", err)
+			}
+			head, err := clientset.CoreV1().ConfigMaps("meshery").Get(context.Background(), "encrypted-"+compression, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get snapshot ConfigMap: %v", err)
+			}
+			if data := head.BinaryData[configMapDataKey]; !IsEncrypted(data) {
+				t.Errorf("ConfigMap data starts with %q, want the age ciphertext as written", data[:8])
+			}
+
+			snapshot, err := LoadSnapshot(location)
+			if err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if len(snapshot.Resources) != 3 {
+				t.Errorf("LoadSnapshot() read %d resources, want 3", len(snapshot.Resources))
+			}
+		})
+	}
+}
+
+func TestConfigMapSinkPrune(t *testing.T) {
+	client, _ := newFakeClient()
+	useSink(t, ConfigMapScheme, func(location *url.URL) (Sink, error) {
+		return NewConfigMapSink(client, location)
+	})
+
+	const location = "configmap://meshery/{cluster}-{timestamp}"
+	for _, timestamp := range []string{"20260101T100000Z", "20260101T110000Z", "20260101T120000Z"} {
+		path := strings.NewReplacer(ClusterPlaceholder, "prod", TimestampPlaceholder, timestamp).Replace(location)
+		if err := SaveSnapshot(newTestSnapshot(1, nil), path, SnapshotFileOptions{Format: FormatYAML}); err != nil {
+			t.Fatalf("SaveSnapshot() error = %v", err)
+		}
+	}
+	if err := SaveSnapshot(newTestSnapshot(1, nil), "configmap://meshery/dev-20260101t090000z", SnapshotFileOptions{Format: FormatYAML}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	removed, err := PruneSnapshots(location, "prod", 2)
+	if err != nil {
+		t.Fatalf("PruneSnapshots() error = %v", err)
+	}
+	if want := []string{"configmap://meshery/prod-20260101t100000z"}; strings.Join(removed, ",") != strings.Join(want, ",") {
+		t.Errorf("PruneSnapshots() removed %v, want %v", removed, want)
+	}
+	if got := countConfigMaps(t, client); got != 3 {
+		t.Errorf("PruneSnapshots() left %d ConfigMaps, want 3", got)
+	}
+}
+
+func TestNewConfigMapSinkErrors(t *testing.T) {
+	for _, location := range []string{
+		"configmap://meshery",
+		"configmap:///prod",
+		"configmap://meshery/prod/snapshot",
+		"configmap://meshery/prod?compress=zstd",
+		"configmap://meshery/prod_snapshot",
+	} {
+		t.Run(location, func(t *testing.T) {
+			u, _ := url.Parse(location)
+			if _, err := NewConfigMapSink(nil, u); err == nil {
+				t.Errorf("NewConfigMapSink(%s) error = nil, want an error", location)
+			}
+		})
+	}
+}
+
+func countConfigMaps(t *testing.T, client *kube.Client) int {
+	t.Helper()
+	list, err := client.Clientset.CoreV1().ConfigMaps("meshery").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list ConfigMaps: %v", err)
+	}
+	return len(list.Items)
+}
+
+func readSink(t *testing.T, sink Sink) []byte {
+	t.Helper()
+	r, err := sink.Open(context.Background())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,303 @@
+package meshsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// PVCScheme is the URL scheme of snapshots stored on a PersistentVolumeClaim,
+// pvc://namespace/claim/path
+const PVCScheme = "pvc"
+
+// PVCHelperImage runs the helper pod that mounts a claim to read and write
+// snapshots on it from outside the cluster
+var PVCHelperImage = "busybox:1.36"
+
+// pvcMountPath is where the helper pod mounts the claim
+const pvcMountPath = "/data"
+
+// pvcHelperTimeout bounds how long the helper pod has to start
+const pvcHelperTimeout = 2 * time.Minute
+
+// Shell scripts the helper pod runs, with the snapshot path as $0
+const (
+	pvcExistsScript = `if [ -e "$0" ]; then echo found; else echo missing; fi`
+	pvcOpenScript   = `if [ -e "$0" ]; then echo found; cat "$0"; else echo missing; fi`
+	// The snapshot is written next to the target and moved into place; a
+	// hard link fails if the target was created meanwhile, where mv would
+	// replace it
+	pvcPutScript = `umask 077
+mkdir -p "$(dirname "$0")" || exit 1
+if [ "$1" != overwrite ] && [ -e "$0" ]; then echo exists; exit 0; fi
+tmp="$0.tmp.$$"
+cat > "$tmp" || { rm -f "$tmp"; exit 1; }
+if [ "$1" = overwrite ]; then mv "$tmp" "$0" || exit 1
+elif ! ln "$tmp" "$0" 2>/dev/null; then rm -f "$tmp"; echo exists; exit 0
+else rm -f "$tmp"; fi
+echo stored`
+	pvcListScript   = `ls -1 "$0" 2>/dev/null; true`
+	pvcRemoveScript = `rm -f -- "$@"`
+)
+
+// PodExecutor runs a command in a pod, streaming stdin to it and its output
+// to stdout
+type PodExecutor func(ctx context.Context, namespace, pod string, command []string, stdin io.Reader, stdout io.Writer) error
+
+// PVCSink stores a snapshot as a file on a PersistentVolumeClaim. Each
+// operation starts a helper pod that mounts the claim and runs in it.
+type PVCSink struct {
+	Client    *kube.Client
+	Namespace string
+	Claim     string
+	// Path is the path of the snapshot on the volume
+	Path string
+	// Exec runs commands in the helper pod; pod exec through Client when nil
+	Exec PodExecutor
+}
+
+// OpenPVCSink opens a pvc://namespace/claim/path location with the
+// kubeconfig or in-cluster credentials
+func OpenPVCSink(location *url.URL) (Sink, error) {
+	client, err := kube.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+	return NewPVCSink(client, location)
+}
+
+// NewPVCSink opens a pvc://namespace/claim/path location with the given
+// client
+func NewPVCSink(client *kube.Client, location *url.URL) (*PVCSink, error) {
+	claim, filePath, _ := strings.Cut(strings.TrimPrefix(location.Path, "/"), "/")
+	filePath = path.Clean("/" + filePath)
+	if location.Host == "" || claim == "" || filePath == "/" || location.RawQuery != "" {
+		return nil, fmt.Errorf("invalid PVC location %s, want pvc://namespace/claim/path/snapshot.yaml.gz", location)
+	}
+	return &PVCSink{Client: client, Namespace: location.Host, Claim: claim, Path: filePath}, nil
+}
+
+// ParsePVCLocation splits a pvc://namespace/claim/path location into its
+// parts, for mounting the claim directly
+func ParsePVCLocation(location string) (namespace, claim, filePath string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != PVCScheme {
+		return "", "", "", fmt.Errorf("invalid PVC location %s, want pvc://namespace/claim/path/snapshot.yaml.gz", location)
+	}
+	sink, err := NewPVCSink(nil, u)
+	if err != nil {
+		return "", "", "", err
+	}
+	return sink.Namespace, sink.Claim, sink.Path, nil
+}
+
+// location returns the URL of a file on the claim
+func (s *PVCSink) location(filePath string) string {
+	return PVCScheme + "://" + s.Namespace + "/" + s.Claim + filePath
+}
+
+// Exists reports whether the snapshot file exists on the claim
+func (s *PVCSink) Exists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := s.withHelper(ctx, func(run helperRunner) error {
+		var out bytes.Buffer
+		if err := run([]string{"sh", "-c", pvcExistsScript, s.podPath(s.Path)}, nil, &out); err != nil {
+			return err
+		}
+		exists = strings.TrimSpace(out.String()) == "found"
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check for snapshot %s: %w", s.location(s.Path), err)
+	}
+	return exists, nil
+}
+
+// Put writes the snapshot file to the claim
+func (s *PVCSink) Put(ctx context.Context, r io.ReadSeeker, size int64, overwrite bool) error {
+	mode := "create"
+	if overwrite {
+		mode = "overwrite"
+	}
+	var out bytes.Buffer
+	err := s.withHelper(ctx, func(run helperRunner) error {
+		return run([]string{"sh", "-c", pvcPutScript, s.podPath(s.Path), mode}, r, &out)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store snapshot %s: %w", s.location(s.Path), err)
+	}
+	switch strings.TrimSpace(out.String()) {
+	case "stored":
+		return nil
+	case "exists":
+		return fmt.Errorf("snapshot %s: %w", s.location(s.Path), fs.ErrExist)
+	default:
+		return fmt.Errorf("failed to store snapshot %s: %s", s.location(s.Path), strings.TrimSpace(out.String()))
+	}
+}
+
+// Open reads the snapshot file from the claim
+func (s *PVCSink) Open(ctx context.Context) (io.ReadCloser, error) {
+	var out bytes.Buffer
+	err := s.withHelper(ctx, func(run helperRunner) error {
+		return run([]string{"sh", "-c", pvcOpenScript, s.podPath(s.Path)}, nil, &out)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", s.location(s.Path), err)
+	}
+
+	reader := bufio.NewReader(&out)
+	marker, _ := reader.ReadString('\n')
+	if strings.TrimSpace(marker) != "found" {
+		return nil, fmt.Errorf("snapshot %s: %w", s.location(s.Path), fs.ErrNotExist)
+	}
+	return io.NopCloser(reader), nil
+}
+
+// Prune removes all but the newest keep snapshot files matching the sink's
+// path, which holds a * in place of the timestamp
+func (s *PVCSink) Prune(ctx context.Context, keep int) ([]string, error) {
+	var removed []string
+	err := s.withHelper(ctx, func(run helperRunner) error {
+		var out bytes.Buffer
+		dir := path.Dir(s.Path)
+		if err := run([]string{"sh", "-c", pvcListScript, s.podPath(dir)}, nil, &out); err != nil {
+			return err
+		}
+
+		var matches []string
+		for _, name := range strings.Split(out.String(), "\n") {
+			if matched, _ := path.Match(path.Base(s.Path), name); matched && name != "" {
+				matches = append(matches, path.Join(dir, name))
+			}
+		}
+		if len(matches) <= keep {
+			return nil
+		}
+
+		// Timestamps sort lexically, so the oldest snapshots come first
+		sort.Strings(matches)
+		command := []string{"sh", "-c", pvcRemoveScript, "sh"}
+		for _, match := range matches[:len(matches)-keep] {
+			command = append(command, s.podPath(match))
+			removed = append(removed, s.location(match))
+		}
+		return run(command, nil, io.Discard)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove old snapshots: %w", err)
+	}
+	return removed, nil
+}
+
+// podPath returns where a path on the volume is in the helper pod
+func (s *PVCSink) podPath(filePath string) string {
+	return pvcMountPath + filePath
+}
+
+// helperRunner runs a command in the helper pod
+type helperRunner func(command []string, stdin io.Reader, stdout io.Writer) error
+
+// withHelper starts a pod that mounts the claim, calls fn to run commands
+// in it and removes it again
+func (s *PVCSink) withHelper(ctx context.Context, fn func(run helperRunner) error) error {
+	pods := s.Client.Clientset.CoreV1().Pods(s.Namespace)
+	gracePeriod := int64(0)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "meshsync-snapshot-pvc-" + utilrand.String(5),
+			Namespace: s.Namespace,
+			Labels:    map[string]string{"app": "meshsync-snapshot-pvc"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers: []corev1.Container{{
+				Name:         "helper",
+				Image:        PVCHelperImage,
+				Command:      []string{"sleep", "3600"},
+				VolumeMounts: []corev1.VolumeMount{{Name: "snapshots", MountPath: pvcMountPath}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "snapshots",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: s.Claim,
+				}},
+			}},
+		},
+	}
+	pod, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create helper pod: %w", err)
+	}
+	// The helper pod is removed even when the operation was cancelled
+	defer pods.Delete(context.WithoutCancel(ctx), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, pvcHelperTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch current.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("helper pod %s exited", pod.Name)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("helper pod %s mounting claim %s did not start: %w", pod.Name, s.Claim, err)
+	}
+
+	exec := s.Exec
+	if exec == nil {
+		exec = s.execInPod
+	}
+	return fn(func(command []string, stdin io.Reader, stdout io.Writer) error {
+		return exec(ctx, s.Namespace, pod.Name, command, stdin, stdout)
+	})
+}
+
+// execInPod runs a command in a pod through the API server
+func (s *PVCSink) execInPod(ctx context.Context, namespace, pod string, command []string, stdin io.Reader, stdout io.Writer) error {
+	req := s.Client.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Command: command,
+			Stdin:   stdin != nil,
+			Stdout:  true,
+			Stderr:  true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(s.Client.Config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to exec in helper pod: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to exec in helper pod: %w: %s", err, msg)
+		}
+		return fmt.Errorf("failed to exec in helper pod: %w", err)
+	}
+	return nil
+}
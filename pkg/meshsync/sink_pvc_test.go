@@ -0,0 +1,135 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// localExec runs helper pod commands on the host, with the volume in dir
+func localExec(dir string) PodExecutor {
+	return func(ctx context.Context, namespace, pod string, command []string, stdin io.Reader, stdout io.Writer) error {
+		args := make([]string, len(command))
+		for i, arg := range command {
+			if strings.HasPrefix(arg, pvcMountPath+"/") {
+				arg = dir + strings.TrimPrefix(arg, pvcMountPath)
+			}
+			args[i] = arg
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin, cmd.Stdout = stdin, stdout
+		return cmd.Run()
+	}
+}
+
+func TestPVCSink(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	client, clientset := newFakeClient()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status.Phase = corev1.PodRunning
+		return false, nil, nil
+	})
+	dir := t.TempDir()
+	useSink(t, PVCScheme, func(location *url.URL) (Sink, error) {
+		sink, err := NewPVCSink(client, location)
+		if err != nil {
+			return nil, err
+		}
+		sink.Exec = localExec(dir)
+		return sink, nil
+	})
+
+	const location = "pvc://meshery/snapshots/prod/{timestamp}.yaml.gz"
+	for _, timestamp := range []string{"20260101T100000Z", "20260101T110000Z", "20260101T120000Z"} {
+		path := strings.Replace(location, TimestampPlaceholder, timestamp, 1)
+		if err := SaveSnapshot(newTestSnapshot(2, nil), path, SnapshotFileOptions{Format: FormatYAML, Compression: CompressionFromPath(path)}); err != nil {
+			t.Fatalf("SaveSnapshot() error = %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "prod", "20260101T120000Z.yaml.gz"))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot from the volume: %v", err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("Snapshot on the volume = %q, want a gzip snapshot", data)
+	}
+
+	snapshot, err := LoadSnapshot("pvc://meshery/snapshots/prod/20260101T120000Z.yaml.gz")
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != 2 {
+		t.Errorf("LoadSnapshot() read %d resources, want 2", len(snapshot.Resources))
+	}
+	if err := SaveSnapshot(newTestSnapshot(1, nil), "pvc://meshery/snapshots/prod/20260101T120000Z.yaml.gz", SnapshotFileOptions{Format: FormatYAML}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveSnapshot() to an existing file error = %v, want fs.ErrExist", err)
+	}
+	if _, err := LoadSnapshot("pvc://meshery/snapshots/prod/missing.yaml"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSnapshot() of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+
+	removed, err := PruneSnapshots(location, "prod", 1)
+	if err != nil {
+		t.Fatalf("PruneSnapshots() error = %v", err)
+	}
+	if len(removed) != 2 || removed[0] != "pvc://meshery/snapshots/prod/20260101T100000Z.yaml.gz" {
+		t.Errorf("PruneSnapshots() removed %v, want the two oldest snapshots", removed)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "prod")); len(entries) != 1 {
+		t.Errorf("PruneSnapshots() left %d files, want 1", len(entries))
+	}
+
+	// Helper pods are removed after each operation
+	pods, _ := clientset.CoreV1().Pods("meshery").List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("%d helper pods left behind, want none", len(pods.Items))
+	}
+}
+
+func TestNewPVCSink(t *testing.T) {
+	tests := []struct {
+		location string
+		wantPath string
+		wantErr  bool
+	}{
+		{location: "pvc://meshery/snapshots/prod/a.yaml.gz", wantPath: "/prod/a.yaml.gz"},
+		{location: "pvc://meshery/snapshots/../../etc/passwd", wantPath: "/etc/passwd"},
+		{location: "pvc://meshery/snapshots", wantErr: true},
+		{location: "pvc://meshery/snapshots/", wantErr: true},
+		{location: "pvc:///snapshots/a.yaml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			u, _ := url.Parse(tt.location)
+			sink, err := NewPVCSink(nil, u)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NewPVCSink() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPVCSink() error = %v", err)
+			}
+			if sink.Claim != "snapshots" || sink.Path != tt.wantPath {
+				t.Errorf("NewPVCSink() claim = %s, path = %s, want snapshots, %s", sink.Claim, sink.Path, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package meshsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+// fakeS3 is an in-process S3-compatible object store addressed path-style
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	// parts holds the parts of multipart uploads in progress by key, and
+	// uploads counts the multipart uploads completed
+	parts   map[string]map[int][]byte
+	uploads int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}, parts: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+// object returns a stored object and the headers it was put with
+func (f *fakeS3) object(key string) ([]byte, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key], f.headers[key]
+}
+
+func (f *fakeS3) putObject(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+	key := r.URL.Path
+	data, exists := f.objects[key]
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.parts[key] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		body, _ := io.ReadAll(r.Body)
+		f.parts[key][part] = body
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.Itoa(part)))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			io.WriteString(w, "<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>")
+			return
+		}
+		var object []byte
+		for part := 1; part <= len(f.parts[key]); part++ {
+			object = append(object, f.parts[key][part]...)
+		}
+		delete(f.parts, key)
+		f.objects[key] = object
+		f.headers[key] = r.Header.Clone()
+		f.uploads++
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>\"complete\"</ETag></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.parts, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>")
+			return
+		}
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.objects[key] = body
+		f.headers[key] = r.Header.Clone()
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	fake, server := newFakeS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+
+	location := "s3://snapshots/prod/uid/20260101T120000Z.yaml.gz?endpoint=" + server.URL + "&path-style=true&sse=AES256"
+	if !IsSinkURL(location) || IsSinkURL("snapshots/prod.yaml") {
+		t.Fatal("IsSinkURL() did not tell URLs from paths")
+	}
+	if got := CompressionFromPath(location); got != CompressionGzip {
+		t.Errorf("CompressionFromPath() = %q, want gzip", got)
+	}
+
+	opts := SnapshotFileOptions{Format: FormatYAML, Compression: CompressionFromPath(location)}
+	if err := SaveSnapshot(newTestSnapshot(3, nil), location, opts); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	const key = "/snapshots/prod/uid/20260101T120000Z.yaml.gz"
+	data, headers := fake.object(key)
+	if len(data) < 2 || data[0] != gzipMagic[0] {
+		t.Fatalf("Object %s = %q, want a gzip snapshot", key, data)
+	}
+	if sse := headers.Get("X-Amz-Server-Side-Encryption"); sse != "AES256" {
+		t.Errorf("Server-side encryption = %q, want AES256", sse)
+	}
+
+	snapshot, err := LoadSnapshot(location)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if len(snapshot.Resources) != 3 {
+		t.Errorf("LoadSnapshot() read %d resources, want 3", len(snapshot.Resources))
+	}
+
+	// Stored snapshots are kept unless overwriting
+	if err := SaveSnapshot(newTestSnapshot(1, nil), location, opts); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveSnapshot() to an existing object error = %v, want fs.ErrExist", err)
+	}
+	opts.Overwrite = true
+	if err := SaveSnapshot(newTestSnapshot(1, nil), location, opts); err != nil {
+		t.Fatalf("SaveSnapshot() with overwrite error = %v", err)
+	}
+	if snapshot, _ := LoadSnapshot(location); snapshot == nil || len(snapshot.Resources) != 1 {
+		t.Error("SaveSnapshot() with overwrite did not replace the object")
+	}
+
+	// An object stored while capturing is not replaced
+	file, err := CreateSnapshotFile(strings.Replace(location, "20260101T120000Z", "20260101T130000Z", 1), SnapshotFileOptions{Format: FormatYAML})
+	if err != nil {
+		t.Fatalf("CreateSnapshotFile() error = %v", err)
+	}
+	fake.putObject("/snapshots/prod/uid/20260101T130000Z.yaml.gz", []byte("taken"))
+	if err := WriteSnapshot(file, newTestSnapshot(1, nil)); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Close() over an object stored meanwhile error = %v, want fs.ErrExist", err)
+	}
+
+	if _, err := LoadSnapshot(strings.Replace(location, "prod/", "dev/", 1)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSnapshot() of a missing object error = %v, want fs.ErrNotExist", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "other-key")
+	if _, err := LoadSnapshot(location); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("LoadSnapshot() with the wrong key error = %v, want AccessDenied", err)
+	}
+}
+
+func TestS3SinkMultipart(t *testing.T) {
+	fake, server := newFakeS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+
+	sink, err := OpenSink("s3://snapshots/prod/large.yaml.gz?endpoint=" + server.URL + "&path-style=true")
+	if err != nil {
+		t.Fatalf("OpenSink() error = %v", err)
+	}
+	// Larger than one part, so the object is uploaded in two
+	data := make([]byte, manager.DefaultUploadPartSize+1024)
+	rand.Read(data)
+	ctx := context.Background()
+	if err := sink.Put(ctx, bytes.NewReader(data), int64(len(data)), false); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if stored, _ := fake.object("/snapshots/prod/large.yaml.gz"); !bytes.Equal(stored, data) || fake.uploads != 1 {
+		t.Errorf("Stored %d bytes in %d multipart uploads, want %d bytes in 1", len(stored), fake.uploads, len(data))
+	}
+
+	reader, err := sink.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	read, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(read, data) {
+		t.Errorf("Open() read %d bytes, error = %v, want the %d stored", len(read), err, len(data))
+	}
+
+	// The condition is checked when the upload completes
+	if err := sink.Put(ctx, bytes.NewReader(data), int64(len(data)), false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Put() over an existing object error = %v, want fs.ErrExist", err)
+	}
+}
+
+func TestOpenSinkErrors(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+
+	tests := []struct {
+		location string
+		wantErr  string
+	}{
+		{location: "s3://snapshots", wantErr: "invalid S3 location"},
+		{location: "s3://snapshots/prod/", wantErr: "invalid S3 location"},
+		{location: "s3://snapshots/a.yaml?path-style=maybe", wantErr: "invalid path-style"},
+		{location: "s3://snapshots/a.yaml?acl=public-read", wantErr: "unknown S3 location option"},
+		{location: "s3://snapshots/a.yaml?sse=des", wantErr: "unknown server-side encryption"},
+		{location: "gs://snapshots/a.yaml", wantErr: "unsupported snapshot location"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			_, err := OpenSink(tt.location)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("OpenSink() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
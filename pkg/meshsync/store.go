@@ -0,0 +1,492 @@
+package meshsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStoreDir is the store directory, relative to the home directory,
+// used when none is given
+const DefaultStoreDir = ".meshsync-snapshots"
+
+// storeIndexFile is the index of the snapshots in a store, which is rebuilt
+// from the snapshot files when it is missing
+const storeIndexFile = "index.json"
+
+// storeExtension is the extension of snapshot files in a store. Snapshots
+// are kept as gzip-compressed YAML.
+const storeExtension = ".yaml.gz"
+
+// Store lock timings. A lock older than storeLockStale is left over from a
+// process that died and is broken.
+const (
+	storeLockTimeout = 10 * time.Second
+	storeLockStale   = time.Minute
+)
+
+// storeRefPattern matches store references such as prod@latest, prod@-1 or
+// prod@20260101T120000Z
+var storeRefPattern = regexp.MustCompile(`^([^@/\\]+)@(latest|-[0-9]+|[0-9]{8}T[0-9]{6}Z)$`)
+
+// StoreEntry is the index entry of a snapshot in a store
+type StoreEntry struct {
+	// ID is the cluster directory and timestamp, e.g. 5c1e.../20260101T120000Z
+	ID string `json:"id" yaml:"id"`
+	// Cluster is the directory the snapshot is filed under: the cluster ID,
+	// or the kubeconfig context when the ID could not be read
+	Cluster   string            `json:"cluster" yaml:"cluster"`
+	Path      string            `json:"path" yaml:"path"`
+	Name      string            `json:"name" yaml:"name"`
+	Timestamp time.Time         `json:"timestamp" yaml:"timestamp"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Context and Server are the kubeconfig context and API server the
+	// snapshot was captured with
+	Context    string         `json:"context,omitempty" yaml:"context,omitempty"`
+	Server     string         `json:"server,omitempty" yaml:"server,omitempty"`
+	Resources  int            `json:"resources" yaml:"resources"`
+	Counts     map[string]int `json:"counts,omitempty" yaml:"counts,omitempty"`
+	Incomplete bool           `json:"incomplete,omitempty" yaml:"incomplete,omitempty"`
+	Size       int64          `json:"size" yaml:"size"`
+}
+
+// Matches reports whether a reference name picks out this snapshot's
+// cluster: its directory, kubeconfig context or snapshot name
+func (e StoreEntry) Matches(name string) bool {
+	return name == e.Cluster || name == e.Context || name == e.Name
+}
+
+// storeIndex is the content of the index file
+type storeIndex struct {
+	Entries []StoreEntry `json:"entries"`
+}
+
+// Store files snapshots by cluster and timestamp under a directory and
+// keeps an index of their metadata
+type Store struct {
+	Dir string
+	// OnSkip is called for every snapshot file that rebuilding the index
+	// leaves out because it cannot be read; nil skips them silently
+	OnSkip func(path string, err error)
+}
+
+// DefaultStorePath returns the store directory in the home directory
+func DefaultStorePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the home directory for the snapshot store: %w", err)
+	}
+	return filepath.Join(home, DefaultStoreDir), nil
+}
+
+// OpenStore opens the store in dir, creating the directory if needed. An
+// empty dir opens the default store.
+func OpenStore(dir string) (*Store, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultStorePath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot store: %w", err)
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// PathTemplate is the path new snapshots are written to, with {cluster}
+// and {timestamp} placeholders for ExpandSnapshotPath
+func (s *Store) PathTemplate() string {
+	return filepath.Join(s.Dir, ClusterPlaceholder, TimestampPlaceholder+storeExtension)
+}
+
+// Add indexes a snapshot written to a path from PathTemplate
+func (s *Store) Add(path string) (*StoreEntry, error) {
+	entry, err := s.newEntry(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateIndex(func(entries []StoreEntry) []StoreEntry {
+		for i := range entries {
+			if entries[i].ID == entry.ID {
+				entries[i] = *entry
+				return entries
+			}
+		}
+		return append(entries, *entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// newEntry reads the metadata of a snapshot file in the store
+func (s *Store) newEntry(path string) (*StoreEntry, error) {
+	rel, err := filepath.Rel(s.Dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") || !strings.HasSuffix(rel, storeExtension) {
+		return nil, fmt.Errorf("snapshot %s is not in the store %s", path, s.Dir)
+	}
+	rel = filepath.ToSlash(rel)
+	cluster, _, _ := strings.Cut(rel, "/")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index snapshot: %w", err)
+	}
+	snapshot, err := LoadSnapshot(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index snapshot: %w", err)
+	}
+
+	entry := &StoreEntry{
+		ID:        strings.TrimSuffix(rel, storeExtension),
+		Cluster:   cluster,
+		Path:      rel,
+		Name:      snapshot.Metadata.Name,
+		Labels:    snapshot.Metadata.Labels,
+		Resources: len(snapshot.Resources),
+		Size:      info.Size(),
+	}
+	entry.Timestamp, err = time.Parse(time.RFC3339, snapshot.Metadata.Timestamp)
+	if err != nil {
+		entry.Timestamp = info.ModTime().UTC().Truncate(time.Second)
+	}
+	if c := snapshot.Metadata.Cluster; c != nil {
+		entry.Context = c.Context
+		entry.Server = c.Server
+	}
+	if status := snapshot.Status; status != nil {
+		entry.Counts = status.Counts
+		entry.Incomplete = status.Incomplete
+	}
+	return entry, nil
+}
+
+// StoreFilter selects store entries; empty fields match everything
+type StoreFilter struct {
+	// Cluster matches the cluster directory, kubeconfig context or
+	// snapshot name, as the name in a reference does
+	Cluster string
+	Labels  map[string]string
+}
+
+func (f StoreFilter) matches(entry StoreEntry) bool {
+	if f.Cluster != "" && !entry.Matches(f.Cluster) {
+		return false
+	}
+	for key, value := range f.Labels {
+		if entry.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns the entries that match the filter, newest first
+func (s *Store) List(filter StoreFilter) ([]StoreEntry, error) {
+	index, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StoreEntry
+	for _, entry := range index.Entries {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// sortEntries sorts entries newest first
+func sortEntries(entries []StoreEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Timestamp.Equal(entries[j].Timestamp) {
+			return entries[i].Timestamp.After(entries[j].Timestamp)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+}
+
+// IsStoreRef reports whether ref has the form of a store reference, such
+// as prod@latest, prod@-1 or prod@20260101T120000Z
+func IsStoreRef(ref string) bool {
+	return storeRefPattern.MatchString(ref)
+}
+
+// Resolve finds the entry a reference points to. A reference is either an
+// entry ID or name@version, where name matches the cluster directory,
+// kubeconfig context or snapshot name and version is latest, -N for the Nth
+// snapshot before the latest, or a timestamp.
+func (s *Store) Resolve(ref string) (*StoreEntry, error) {
+	match := storeRefPattern.FindStringSubmatch(ref)
+	if match == nil {
+		entries, err := s.List(StoreFilter{})
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.ID == ref {
+				return &entry, nil
+			}
+		}
+		return nil, fmt.Errorf("no snapshot %s in the store, want an ID or a reference such as prod@latest", ref)
+	}
+
+	name, version := match[1], match[2]
+	entries, err := s.List(StoreFilter{Cluster: name})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no snapshots of %s in the store", name)
+	}
+
+	switch {
+	case version == "latest":
+		return &entries[0], nil
+	case strings.HasPrefix(version, "-"):
+		back, _ := strconv.Atoi(version[1:])
+		if back >= len(entries) {
+			return nil, fmt.Errorf("no snapshot %s in the store, there are %d snapshots of %s", ref, len(entries), name)
+		}
+		return &entries[back], nil
+	default:
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.ID, "/"+version) {
+				return &entry, nil
+			}
+		}
+		return nil, fmt.Errorf("no snapshot %s in the store", ref)
+	}
+}
+
+// FilePath returns the path of an entry's snapshot file
+func (s *Store) FilePath(entry *StoreEntry) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(entry.Path))
+}
+
+// Remove deletes the snapshots of the given entries, and any deltas
+// recorded with them, and drops them from the index. Files that are already gone are not an error.
+func (s *Store) Remove(entries ...StoreEntry) error {
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		if err := os.Remove(s.FilePath(&entry)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove snapshot %s: %w", entry.ID, err)
+		}
+		// Along with its signature and the deltas of a watch that started
+		// with the snapshot
+		os.Remove(SignaturePath(s.FilePath(&entry)))
+		os.Remove(DeltaPath(s.FilePath(&entry)))
+		ids[entry.ID] = true
+		// Drop the cluster directory once it is empty
+		os.Remove(filepath.Dir(s.FilePath(&entry)))
+	}
+
+	return s.updateIndex(func(index []StoreEntry) []StoreEntry {
+		kept := index[:0]
+		for _, entry := range index {
+			if !ids[entry.ID] {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	})
+}
+
+// GCOptions selects the snapshots garbage collection removes. When both are
+// set, only snapshots that are older and not among the newest are removed.
+type GCOptions struct {
+	// KeepLast keeps the newest N snapshots of each cluster
+	KeepLast int
+	// OlderThan removes snapshots taken longer ago than this
+	OlderThan time.Duration
+	// DryRun returns what would be removed without removing it
+	DryRun bool
+}
+
+// GC removes old snapshots and returns the removed entries
+func (s *Store) GC(opts GCOptions, now time.Time) ([]StoreEntry, error) {
+	if opts.KeepLast <= 0 && opts.OlderThan <= 0 {
+		return nil, fmt.Errorf("give the number of snapshots to keep or a maximum age")
+	}
+
+	entries, err := s.List(StoreFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []StoreEntry
+	seen := map[string]int{}
+	for _, entry := range entries {
+		// Entries are newest first, so this is the entry's rank in its cluster
+		rank := seen[entry.Cluster]
+		seen[entry.Cluster]++
+
+		if opts.KeepLast > 0 && rank < opts.KeepLast {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(entry.Timestamp) <= opts.OlderThan {
+			continue
+		}
+		removed = append(removed, entry)
+	}
+
+	if opts.DryRun || len(removed) == 0 {
+		return removed, nil
+	}
+	return removed, s.Remove(removed...)
+}
+
+// Rebuild recreates the index from the snapshot files in the store
+func (s *Store) Rebuild() error {
+	return s.writeIndex(func([]StoreEntry, bool) ([]StoreEntry, error) {
+		return s.scan()
+	})
+}
+
+// scan indexes every snapshot file in the store. Files that cannot be
+// read are skipped and reported to OnSkip, so that one corrupt snapshot
+// does not make the whole store unusable.
+func (s *Store) scan() ([]StoreEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*", "*"+storeExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan snapshot store: %w", err)
+	}
+
+	entries := []StoreEntry{}
+	for _, path := range paths {
+		entry, err := s.newEntry(path)
+		if err != nil {
+			if s.OnSkip != nil {
+				s.OnSkip(path, err)
+			}
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// readIndex reads the index, rebuilding it when it is missing
+func (s *Store) readIndex() (*storeIndex, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, storeIndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := s.Rebuild(); err != nil {
+			return nil, err
+		}
+		data, err = os.ReadFile(filepath.Join(s.Dir, storeIndexFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot store index: %w", err)
+	}
+
+	index := &storeIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid snapshot store index %s, remove it to rebuild it: %w", filepath.Join(s.Dir, storeIndexFile), err)
+	}
+	return index, nil
+}
+
+// updateIndex applies update to the index entries under the store lock and
+// writes the index atomically. A missing index is rebuilt from the snapshot
+// files first, as readIndex does, so that an update does not drop them.
+func (s *Store) updateIndex(update func([]StoreEntry) []StoreEntry) error {
+	return s.writeIndex(func(entries []StoreEntry, missing bool) ([]StoreEntry, error) {
+		if missing {
+			scanned, err := s.scan()
+			if err != nil {
+				return nil, err
+			}
+			entries = scanned
+		}
+		return update(entries), nil
+	})
+}
+
+// writeIndex replaces the index entries with those update returns for the
+// current ones, under the store lock, and writes the index atomically.
+// update is told whether the index is missing.
+func (s *Store) writeIndex(update func(entries []StoreEntry, missing bool) ([]StoreEntry, error)) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index := &storeIndex{}
+	data, err := os.ReadFile(filepath.Join(s.Dir, storeIndexFile))
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err == nil {
+		if err := json.Unmarshal(data, index); err != nil {
+			return fmt.Errorf("invalid snapshot store index %s, remove it to rebuild it: %w", filepath.Join(s.Dir, storeIndexFile), err)
+		}
+	} else if !missing {
+		return fmt.Errorf("failed to read snapshot store index: %w", err)
+	}
+
+	if index.Entries, err = update(index.Entries, missing); err != nil {
+		return err
+	}
+	if index.Entries == nil {
+		index.Entries = []StoreEntry{}
+	}
+	sortEntries(index.Entries)
+	data, err = json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot store index: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, "."+storeIndexFile+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot store index: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.Dir, storeIndexFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write snapshot store index: %w", err)
+	}
+	return nil
+}
+
+// lock takes the store lock, so that concurrent captures do not lose each
+// other's index updates, and returns a function that releases it
+func (s *Store) lock() (func(), error) {
+	path := filepath.Join(s.Dir, "index.lock")
+	deadline := time.Now().Add(storeLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock snapshot store: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > storeLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock snapshot store, remove %s if no other command is using it", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,262 @@
+package meshsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addStoreSnapshot saves a snapshot of the cluster taken at the given time
+// to the store and indexes it
+func addStoreSnapshot(t *testing.T, store *Store, cluster, context string, taken time.Time) *StoreEntry {
+	t.Helper()
+	snapshot := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata: SnapshotMetadata{
+			Name:      DefaultSnapshotName,
+			Timestamp: taken.Format(time.RFC3339),
+			Labels:    map[string]string{"env": context},
+			Cluster:   &ClusterInfo{ID: cluster, Context: context},
+		},
+		Resources: []Resource{newDeltaResource("web", "1")},
+		Status:    &SnapshotStatus{Counts: map[string]int{"ConfigMap": 1}},
+	}
+	path := ExpandSnapshotPath(store.PathTemplate(), taken, cluster)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSnapshot(snapshot, path, SnapshotFileOptions{Format: FormatYAML, Compression: CompressionGzip}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	entry, err := store.Add(path)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	return entry
+}
+
+// entryIDs returns the IDs of the entries
+func entryIDs(entries []StoreEntry) []string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestStore(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	addStoreSnapshot(t, store, "uid-prod", "prod", start)
+	addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(time.Hour))
+	addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(2*time.Hour))
+	entry := addStoreSnapshot(t, store, "uid-dev", "dev", start.Add(30*time.Minute))
+
+	if entry.ID != "uid-dev/20260101T123000Z" || entry.Context != "dev" || entry.Resources != 1 || entry.Size == 0 {
+		t.Errorf("Add() = %+v", entry)
+	}
+
+	entries, err := store.List(StoreFilter{Cluster: "prod"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []string{"uid-prod/20260101T140000Z", "uid-prod/20260101T130000Z", "uid-prod/20260101T120000Z"}
+	if ids := entryIDs(entries); !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() = %v, want %v", ids, want)
+	}
+	entries, _ = store.List(StoreFilter{Labels: map[string]string{"env": "dev"}})
+	if ids := entryIDs(entries); !reflect.DeepEqual(ids, []string{"uid-dev/20260101T123000Z"}) {
+		t.Errorf("List() by label = %v", ids)
+	}
+
+	refs := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{ref: "prod@latest", want: "uid-prod/20260101T140000Z"},
+		{ref: "uid-prod@-1", want: "uid-prod/20260101T130000Z"},
+		{ref: "prod@-2", want: "uid-prod/20260101T120000Z"},
+		{ref: "prod@20260101T130000Z", want: "uid-prod/20260101T130000Z"},
+		{ref: "uid-dev/20260101T123000Z", want: "uid-dev/20260101T123000Z"},
+		{ref: "prod@-3", wantErr: "there are 3 snapshots of prod"},
+		{ref: "staging@latest", wantErr: "no snapshots of staging"},
+		{ref: "prod", wantErr: "no snapshot prod"},
+	}
+	for _, tt := range refs {
+		entry, err := store.Resolve(tt.ref)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Resolve(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q) error = %v", tt.ref, err)
+			continue
+		}
+		if entry.ID != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.ref, entry.ID, tt.want)
+		}
+	}
+
+	// The index is rebuilt from the files when it is lost
+	if err := os.Remove(filepath.Join(store.Dir, storeIndexFile)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = store.List(StoreFilter{})
+	if err != nil {
+		t.Fatalf("List() without an index error = %v", err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("List() without an index = %v, want 4 entries", entryIDs(entries))
+	}
+
+	latest, _ := store.Resolve("dev@latest")
+	if err := store.Remove(*latest); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, "uid-dev")); !os.IsNotExist(err) {
+		t.Errorf("Remove() left the empty cluster directory, error = %v", err)
+	}
+	if _, err := store.Resolve("dev@latest"); err == nil {
+		t.Error("Resolve() found a removed snapshot")
+	}
+}
+
+func TestStoreGC(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(40 * 24 * time.Hour)
+
+	tests := []struct {
+		name        string
+		opts        GCOptions
+		wantRemoved []string
+	}{
+		{
+			name:        "Keep last",
+			opts:        GCOptions{KeepLast: 1},
+			wantRemoved: []string{"uid-prod/20260120T000000Z", "uid-prod/20260101T000000Z"},
+		},
+		{
+			name:        "Older than",
+			opts:        GCOptions{OlderThan: 30 * 24 * time.Hour},
+			wantRemoved: []string{"uid-dev/20260102T000000Z", "uid-prod/20260101T000000Z"},
+		},
+		{
+			name:        "Older than but among the newest",
+			opts:        GCOptions{KeepLast: 1, OlderThan: 30 * 24 * time.Hour},
+			wantRemoved: []string{"uid-prod/20260101T000000Z"},
+		},
+		{
+			name:        "Dry run",
+			opts:        GCOptions{KeepLast: 2, DryRun: true},
+			wantRemoved: []string{"uid-prod/20260101T000000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := OpenStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("OpenStore() error = %v", err)
+			}
+			addStoreSnapshot(t, store, "uid-prod", "prod", start)
+			addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(19*24*time.Hour))
+			addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(35*24*time.Hour))
+			addStoreSnapshot(t, store, "uid-dev", "dev", start.Add(24*time.Hour))
+
+			removed, err := store.GC(tt.opts, now)
+			if err != nil {
+				t.Fatalf("GC() error = %v", err)
+			}
+			if ids := entryIDs(removed); !reflect.DeepEqual(ids, tt.wantRemoved) {
+				t.Errorf("GC() removed %v, want %v", ids, tt.wantRemoved)
+			}
+
+			entries, _ := store.List(StoreFilter{})
+			wantLeft := 4 - len(tt.wantRemoved)
+			if tt.opts.DryRun {
+				wantLeft = 4
+			}
+			if len(entries) != wantLeft {
+				t.Errorf("%d snapshots left, want %d", len(entries), wantLeft)
+			}
+		})
+	}
+
+	store, _ := OpenStore(t.TempDir())
+	if _, err := store.GC(GCOptions{}, now); err == nil {
+		t.Error("GC() expected error without a retention rule")
+	}
+}
+
+func TestStoreAddWithoutIndex(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	addStoreSnapshot(t, store, "uid-prod", "prod", start)
+	addStoreSnapshot(t, store, "uid-dev", "dev", start.Add(30*time.Minute))
+
+	// As in a store written by an older version, or after removing the index
+	if err := os.Remove(filepath.Join(store.Dir, storeIndexFile)); err != nil {
+		t.Fatal(err)
+	}
+	addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(time.Hour))
+
+	entries, err := store.List(StoreFilter{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []string{"uid-prod/20260101T130000Z", "uid-dev/20260101T123000Z", "uid-prod/20260101T120000Z"}
+	if ids := entryIDs(entries); !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() after Add() without an index = %v, want %v", ids, want)
+	}
+}
+
+func TestStoreRebuildSkipsBadFiles(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	var skipped []string
+	store.OnSkip = func(path string, err error) {
+		skipped = append(skipped, filepath.Base(path))
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	addStoreSnapshot(t, store, "uid-prod", "prod", start)
+	addStoreSnapshot(t, store, "uid-prod", "prod", start.Add(time.Hour))
+
+	// A truncated write leaves a snapshot that is not valid gzip
+	corrupt := filepath.Join(store.Dir, "uid-prod", "20260101T140000Z"+storeExtension)
+	if err := os.WriteFile(corrupt, []byte("not gzip"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(store.Dir, storeIndexFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.List(StoreFilter{})
+	if err != nil {
+		t.Fatalf("List() with a corrupt snapshot error = %v", err)
+	}
+	if ids := entryIDs(entries); !reflect.DeepEqual(ids, []string{"uid-prod/20260101T130000Z", "uid-prod/20260101T120000Z"}) {
+		t.Errorf("List() = %v, want the readable snapshots", ids)
+	}
+	if !reflect.DeepEqual(skipped, []string{"20260101T140000Z" + storeExtension}) {
+		t.Errorf("Skipped %v, want the corrupt snapshot", skipped)
+	}
+
+	if err := store.Rebuild(); err != nil {
+		t.Errorf("Rebuild() with a corrupt snapshot error = %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package meshsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+// Watcher keeps shared informers over the captured kinds, so that a full
+// snapshot can be written from their caches and every change after it
+// recorded as a delta
+type Watcher struct {
+	client    *kube.Client
+	opts      CaptureOptions
+	kinds     []resourceKind
+	informers []cache.SharedIndexInformer
+	deltas    chan Delta
+	start     time.Time
+}
+
+// NewWatcher resolves the kinds to watch. Informers are only started by Start.
+func NewWatcher(client *kube.Client, opts CaptureOptions) (*Watcher, error) {
+	kinds, err := resolveKinds(client, opts.Kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Kinds are kept in the order captures write them
+	sort.SliceStable(kinds, func(i, j int) bool {
+		if kinds[i].GVR.Group != kinds[j].GVR.Group {
+			return kinds[i].GVR.Group < kinds[j].GVR.Group
+		}
+		return kinds[i].Kind < kinds[j].Kind
+	})
+	return &Watcher{
+		client: client,
+		opts:   opts,
+		kinds:  kinds,
+		deltas: make(chan Delta),
+	}, nil
+}
+
+// Start runs an informer for every kind until ctx ends. Namespaced kinds are
+// watched in opts.Namespace unless opts.AllNamespaces is set; cluster-scoped
+// kinds are always watched cluster-wide.
+func (w *Watcher) Start(ctx context.Context) error {
+	w.start = time.Now()
+
+	namespace := w.opts.Namespace
+	if w.opts.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	namespaced := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client.Dynamic, 0, namespace, nil)
+	clusterScoped := dynamicinformer.NewDynamicSharedInformerFactory(w.client.Dynamic, 0)
+
+	for _, kind := range w.kinds {
+		factory := clusterScoped
+		if kind.Namespaced {
+			factory = namespaced
+		}
+		informer := factory.ForResource(kind.GVR).Informer()
+		if _, err := informer.AddEventHandler(w.eventHandler(ctx, kind)); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", kind.GVR.Resource, err)
+		}
+		w.informers = append(w.informers, informer)
+	}
+
+	namespaced.Start(ctx.Done())
+	clusterScoped.Start(ctx.Done())
+	return nil
+}
+
+// eventHandler turns informer notifications after the initial list into
+// deltas. Informers buffer notifications for slow handlers, so blocking
+// here until the deltas are read loses nothing.
+func (w *Watcher) eventHandler(ctx context.Context, kind resourceKind) cache.ResourceEventHandler {
+	send := func(deltaType DeltaType, obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		object, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		delta := Delta{
+			Type:   deltaType,
+			Time:   time.Now().UTC(),
+			Object: newResource(kind, object.DeepCopy().Object),
+		}
+		select {
+		case w.deltas <- delta:
+		case <-ctx.Done():
+		}
+	}
+
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// The initial list is written as the snapshot
+			if !isInInitialList {
+				send(DeltaAdd, obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			send(DeltaUpdate, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			send(DeltaDelete, obj)
+		},
+	}
+}
+
+// WriteSnapshot waits for the informers to sync and writes their caches to
+// sw as a full snapshot, returning its status; closing sw is left to the
+// caller. Changes made while the snapshot is written may show up both in
+// it and as deltas, which is harmless since deltas carry whole objects.
+func (w *Watcher) WriteSnapshot(ctx context.Context, sw SnapshotWriter) (*SnapshotStatus, error) {
+	synced := make([]cache.InformerSynced, 0, len(w.informers))
+	for _, informer := range w.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil, fmt.Errorf("failed to sync informers: %w", context.Cause(ctx))
+	}
+
+	header := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   newSnapshotMetadata(ctx, w.client, w.opts, w.kinds, w.start),
+	}
+	if err := sw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	status := &SnapshotStatus{Counts: map[string]int{}}
+	for i, kind := range w.kinds {
+		var objects []*unstructured.Unstructured
+		for _, obj := range w.informers[i].GetStore().List() {
+			if object, ok := obj.(*unstructured.Unstructured); ok {
+				objects = append(objects, object)
+			}
+		}
+		sort.Slice(objects, func(i, j int) bool {
+			if objects[i].GetNamespace() != objects[j].GetNamespace() {
+				return objects[i].GetNamespace() < objects[j].GetNamespace()
+			}
+			return objects[i].GetName() < objects[j].GetName()
+		})
+
+		status.Counts[countKey(kind)] = len(objects)
+		for _, object := range objects {
+			resource := newResource(kind, object.DeepCopy().Object)
+			if err := sw.WriteResource(&resource); err != nil {
+				return nil, err
+			}
+		}
+	}
+	status.Duration = time.Since(w.start).Round(time.Millisecond).String()
+	return status, nil
+}
+
+// WatchDeltas passes every change observed after the initial list to write
+// until ctx ends, which is the normal way to stop watching and returns nil
+func (w *Watcher) WatchDeltas(ctx context.Context, write func(Delta) error) error {
+	for {
+		select {
+		case delta := <-w.deltas:
+			if err := write(delta); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
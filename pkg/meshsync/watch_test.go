@@ -0,0 +1,135 @@
+package meshsync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/kube"
+)
+
+func TestWatcher(t *testing.T) {
+	// Watch events carry the objects the fake dynamic client was seeded
+	// with, so it is seeded with unstructured objects under an empty scheme,
+	// which is what a real server sends to a dynamic informer
+	var objects []runtime.Object
+	for _, object := range []runtime.Object{
+		&corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		newDeployment("web", "default", 1),
+		newDeployment("api", "default", 1),
+		newDeployment("meshsync", "meshery", 1),
+		&corev1.Service{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}, ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+	} {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := &unstructured.Unstructured{Object: content}
+		if u.GetKind() == "" {
+			u.SetAPIVersion("apps/v1")
+			u.SetKind("Deployment")
+		}
+		objects = append(objects, u)
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "namespaces"}:                 "NamespaceList",
+		{Version: "v1", Resource: "services"}:                   "ServiceList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+	}, objects...)
+	clientset := fake.NewSimpleClientset()
+	clientset.Resources = fakeAPIResources
+	client := kube.NewClientFromInterfaces(clientset, dynamicClient, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher, err := NewWatcher(client, CaptureOptions{Namespace: "default", Kinds: []string{"deploy", "svc", "namespaces"}})
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	if err := watcher.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	collector := &snapshotCollector{}
+	status, err := watcher.WriteSnapshot(ctx, collector)
+	if err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	collector.Close(status)
+
+	wantNames := []string{
+		"v1/Namespace/<nil>/default",
+		"v1/Service/default/web",
+		"apps/v1/Deployment/default/api",
+		"apps/v1/Deployment/default/web",
+	}
+	if names := resourceNames(collector.snapshot); !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("WriteSnapshot() wrote %v, want %v", names, wantNames)
+	}
+	if status.Counts["Deployment.apps"] != 2 {
+		t.Errorf("Counts = %v, want 2 deployments", status.Counts)
+	}
+
+	deltas := make(chan Delta, 10)
+	go watcher.WatchDeltas(ctx, func(delta Delta) error {
+		deltas <- delta
+		return nil
+	})
+
+	deployments := dynamicClient.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"})
+	worker := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "worker", "namespace": "default"},
+	}}
+	if _, err := deployments.Namespace("default").Create(ctx, worker, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+	worker.SetLabels(map[string]string{"tier": "batch"})
+	if _, err := deployments.Namespace("default").Update(ctx, worker, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update deployment: %v", err)
+	}
+	if err := deployments.Namespace("default").Delete(ctx, "api", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete deployment: %v", err)
+	}
+	// Outside the watched namespace
+	if err := deployments.Namespace("meshery").Delete(ctx, "meshsync", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete deployment: %v", err)
+	}
+
+	want := []struct {
+		deltaType DeltaType
+		name      string
+	}{
+		{DeltaAdd, "worker"},
+		{DeltaUpdate, "worker"},
+		{DeltaDelete, "api"},
+	}
+	for i, w := range want {
+		select {
+		case delta := <-deltas:
+			if delta.Type != w.deltaType || delta.Object.Name() != w.name {
+				t.Errorf("Delta %d = %s %s, want %s %s", i, delta.Type, delta.Object.Name(), w.deltaType, w.name)
+			}
+			if delta.Object.Kind() != "Deployment" || delta.Time.IsZero() {
+				t.Errorf("Delta %d has kind %q and time %v", i, delta.Object.Kind(), delta.Time)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for delta %d", i)
+		}
+	}
+	select {
+	case delta := <-deltas:
+		t.Errorf("Unexpected delta %s %s/%s", delta.Type, delta.Object.Namespace(), delta.Object.Name())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
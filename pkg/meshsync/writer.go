@@ -0,0 +1,325 @@
+package meshsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Snapshot output formats
+const (
+	FormatYAML       = "yaml"
+	FormatYAMLStream = "yaml-stream"
+	FormatJSON       = "json"
+	FormatNDJSON     = "ndjson"
+	// FormatList exports the resources as a Kubernetes v1/List. It cannot
+	// be loaded back as a snapshot.
+	FormatList = "list"
+)
+
+// Formats lists the supported snapshot output formats
+var Formats = []string{FormatYAML, FormatYAMLStream, FormatJSON, FormatNDJSON, FormatList}
+
+// Comment prefixes that carry the snapshot header and status in the
+// yaml-stream format, where every document is a Kubernetes object
+const (
+	yamlStreamHeaderPrefix = "# snapshot: "
+	yamlStreamStatusPrefix = "# status: "
+)
+
+// ParseFormat validates a snapshot output format name
+func ParseFormat(format string) (string, error) {
+	for _, supported := range Formats {
+		if format == supported {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported snapshot format %q (supported: %s)", format, strings.Join(Formats, ", "))
+}
+
+// SnapshotWriter encodes a snapshot incrementally, so that resources can be
+// written as they are captured instead of being held in memory
+type SnapshotWriter interface {
+	// WriteHeader writes the snapshot apiVersion, kind and metadata. It must
+	// be called once, before any resources are written.
+	WriteHeader(header *Snapshot) error
+	// WriteResource appends a resource to the snapshot
+	WriteResource(resource *Resource) error
+	// Close writes the capture status, completes the document and flushes
+	// the output
+	Close(status *SnapshotStatus) error
+}
+
+// NewSnapshotWriter returns a SnapshotWriter that encodes to w in the given format.
+// JSON is written as a single document with a streamed resources array, YAML
+// as a single document with a streamed resources sequence, and NDJSON as a
+// header line followed by one resource per line and a final status line.
+// yaml-stream writes each resource as its own YAML document, so the output
+// can be used with kubectl; the header and status are kept in comments.
+// list writes only the resources, as a YAML v1/List.
+func NewSnapshotWriter(w io.Writer, format string) (SnapshotWriter, error) {
+	buf := bufio.NewWriter(w)
+	switch format {
+	case FormatYAML:
+		return &yamlWriter{w: buf}, nil
+	case FormatYAMLStream:
+		return &yamlStreamWriter{w: buf}, nil
+	case FormatJSON:
+		return &jsonWriter{w: buf}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{w: buf}, nil
+	case FormatList:
+		return &listWriter{w: buf}, nil
+	default:
+		_, err := ParseFormat(format)
+		return nil, err
+	}
+}
+
+// snapshotHeader holds the fields written before the resources
+type snapshotHeader struct {
+	APIVersion string      `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string      `json:"kind" yaml:"kind"`
+	Metadata   interface{} `json:"metadata" yaml:"metadata"`
+}
+
+// snapshotTrailer holds the fields written after the resources
+type snapshotTrailer struct {
+	Status *SnapshotStatus `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// newSnapshotHeader lays out the metadata for the snapshot's apiVersion, so
+// that a snapshot can be written in any supported version
+func newSnapshotHeader(snapshot *Snapshot) snapshotHeader {
+	header := snapshotHeader{
+		APIVersion: snapshot.APIVersion,
+		Kind:       snapshot.Kind,
+		Metadata:   snapshot.Metadata,
+	}
+	if snapshot.APIVersion == APIVersionV1Alpha1 {
+		header.Metadata = convertMetadataToV1Alpha1(snapshot.Metadata)
+	}
+	return header
+}
+
+// jsonWriter writes a snapshot as a single indented JSON document
+type jsonWriter struct {
+	w         *bufio.Writer
+	resources int
+}
+
+func (j *jsonWriter) WriteHeader(header *Snapshot) error {
+	h := newSnapshotHeader(header)
+	fmt.Fprintf(j.w, "{\n  \"apiVersion\": %s,\n  \"kind\": %s,\n  \"metadata\": ", jsonString(h.APIVersion), jsonString(h.Kind))
+	data, err := json.MarshalIndent(h.Metadata, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot metadata to JSON: %w", err)
+	}
+	j.w.Write(data)
+	_, err = j.w.WriteString(",\n  \"resources\": [")
+	return err
+}
+
+func (j *jsonWriter) WriteResource(resource *Resource) error {
+	data, err := json.MarshalIndent(resource, "    ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource to JSON: %w", err)
+	}
+	if j.resources > 0 {
+		j.w.WriteString(",")
+	}
+	j.resources++
+	j.w.WriteString("\n    ")
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close(status *SnapshotStatus) error {
+	if j.resources > 0 {
+		j.w.WriteString("\n  ")
+	}
+	j.w.WriteString("]")
+	if status != nil {
+		data, err := json.MarshalIndent(status, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot status to JSON: %w", err)
+		}
+		j.w.WriteString(",\n  \"status\": ")
+		j.w.Write(data)
+	}
+	j.w.WriteString("\n}\n")
+	return j.w.Flush()
+}
+
+// jsonString encodes s as a JSON string literal
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// yamlWriter writes a snapshot as a single YAML document
+type yamlWriter struct {
+	w         *bufio.Writer
+	resources int
+}
+
+func (y *yamlWriter) WriteHeader(header *Snapshot) error {
+	data, err := yaml.Marshal(newSnapshotHeader(header))
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot metadata to YAML: %w", err)
+	}
+	_, err = y.w.Write(data)
+	return err
+}
+
+func (y *yamlWriter) WriteResource(resource *Resource) error {
+	// Marshaling a one element sequence yields the resource as a "- " list
+	// item that can be appended to the resources sequence
+	data, err := yaml.Marshal([]*Resource{resource})
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource to YAML: %w", err)
+	}
+	if y.resources == 0 {
+		y.w.WriteString("resources:\n")
+	}
+	y.resources++
+	_, err = y.w.Write(data)
+	return err
+}
+
+func (y *yamlWriter) Close(status *SnapshotStatus) error {
+	if y.resources == 0 {
+		y.w.WriteString("resources: []\n")
+	}
+	if status != nil {
+		data, err := yaml.Marshal(snapshotTrailer{Status: status})
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot status to YAML: %w", err)
+		}
+		y.w.Write(data)
+	}
+	return y.w.Flush()
+}
+
+// yamlStreamWriter writes a snapshot as a stream of YAML documents, one
+// Kubernetes object per resource. The header and status are written as
+// comments holding JSON, which tools that apply the objects ignore.
+type yamlStreamWriter struct {
+	w *bufio.Writer
+}
+
+func (y *yamlStreamWriter) writeComment(prefix string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	y.w.WriteString(prefix)
+	y.w.Write(data)
+	return y.w.WriteByte('\n')
+}
+
+func (y *yamlStreamWriter) WriteHeader(header *Snapshot) error {
+	if err := y.writeComment(yamlStreamHeaderPrefix, newSnapshotHeader(header)); err != nil {
+		return fmt.Errorf("failed to marshal snapshot metadata to JSON: %w", err)
+	}
+	return nil
+}
+
+func (y *yamlStreamWriter) WriteResource(resource *Resource) error {
+	data, err := yaml.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource to YAML: %w", err)
+	}
+	y.w.WriteString("---\n")
+	_, err = y.w.Write(data)
+	return err
+}
+
+func (y *yamlStreamWriter) Close(status *SnapshotStatus) error {
+	if status != nil {
+		if err := y.writeComment(yamlStreamStatusPrefix, status); err != nil {
+			return fmt.Errorf("failed to marshal snapshot status to JSON: %w", err)
+		}
+	}
+	return y.w.Flush()
+}
+
+// ndjsonWriter writes a snapshot as newline delimited JSON: a header line,
+// one line per resource and, if there is a status, a final status line
+type ndjsonWriter struct {
+	w *bufio.Writer
+}
+
+func (n *ndjsonWriter) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	n.w.Write(data)
+	return n.w.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) WriteHeader(header *Snapshot) error {
+	if err := n.writeLine(newSnapshotHeader(header)); err != nil {
+		return fmt.Errorf("failed to marshal snapshot metadata to JSON: %w", err)
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) WriteResource(resource *Resource) error {
+	if err := n.writeLine(resource); err != nil {
+		return fmt.Errorf("failed to marshal resource to JSON: %w", err)
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Close(status *SnapshotStatus) error {
+	if status != nil {
+		if err := n.writeLine(snapshotTrailer{Status: status}); err != nil {
+			return fmt.Errorf("failed to marshal snapshot status to JSON: %w", err)
+		}
+	}
+	return n.w.Flush()
+}
+
+// snapshotCollector is a SnapshotWriter that assembles the snapshot in memory
+type snapshotCollector struct {
+	snapshot *Snapshot
+}
+
+func (c *snapshotCollector) WriteHeader(header *Snapshot) error {
+	c.snapshot = &Snapshot{
+		APIVersion: header.APIVersion,
+		Kind:       header.Kind,
+		Metadata:   header.Metadata,
+		Resources:  []Resource{},
+	}
+	return nil
+}
+
+func (c *snapshotCollector) WriteResource(resource *Resource) error {
+	c.snapshot.Resources = append(c.snapshot.Resources, *resource)
+	return nil
+}
+
+func (c *snapshotCollector) Close(status *SnapshotStatus) error {
+	c.snapshot.Status = status
+	return nil
+}
+
+// WriteSnapshot writes an in-memory snapshot to w
+func WriteSnapshot(w SnapshotWriter, snapshot *Snapshot) error {
+	if err := w.WriteHeader(snapshot); err != nil {
+		return err
+	}
+	for i := range snapshot.Resources {
+		if err := w.WriteResource(&snapshot.Resources[i]); err != nil {
+			return err
+		}
+	}
+	return w.Close(snapshot.Status)
+}
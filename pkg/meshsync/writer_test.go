@@ -0,0 +1,203 @@
+package meshsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// newTestSnapshot returns a snapshot with n pods
+func newTestSnapshot(n int, status *SnapshotStatus) *Snapshot {
+	snapshot := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		Metadata:   SnapshotMetadata{Name: "test-snapshot"},
+		Resources:  []Resource{},
+		Status:     status,
+	}
+	for i := 0; i < n; i++ {
+		snapshot.Resources = append(snapshot.Resources, NewResource(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      fmt.Sprintf("pod-%d", i),
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{
+						"name":  "nginx",
+						"image": "nginx:latest",
+					},
+				},
+			},
+		}))
+	}
+	return snapshot
+}
+
+// encodeSnapshot writes a snapshot in the given format
+func encodeSnapshot(t *testing.T, snapshot *Snapshot, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewSnapshotWriter(&buf, format)
+	if err != nil {
+		t.Fatalf("NewSnapshotWriter() error = %v", err)
+	}
+	if err := WriteSnapshot(w, snapshot); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decodeNDJSON reads a snapshot written by the NDJSON writer
+func decodeNDJSON(data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{Resources: []Resource{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 0; scanner.Scan(); line++ {
+		if line == 0 {
+			if err := json.Unmarshal(scanner.Bytes(), snapshot); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, err
+		}
+		if _, ok := fields["status"]; ok && len(fields) == 1 {
+			if err := json.Unmarshal(fields["status"], &snapshot.Status); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var resource Resource
+		if err := json.Unmarshal(scanner.Bytes(), &resource); err != nil {
+			return nil, err
+		}
+		snapshot.Resources = append(snapshot.Resources, resource)
+	}
+	return snapshot, scanner.Err()
+}
+
+func TestSnapshotWriter(t *testing.T) {
+	status := &SnapshotStatus{Relists: []Relist{{Resource: "pods", Namespace: "default", Restarts: 1}}}
+
+	decoders := map[string]func([]byte) (*Snapshot, error){
+		FormatJSON: func(data []byte) (*Snapshot, error) {
+			var snapshot Snapshot
+			return &snapshot, json.Unmarshal(data, &snapshot)
+		},
+		FormatYAML: func(data []byte) (*Snapshot, error) {
+			var snapshot Snapshot
+			return &snapshot, yaml.Unmarshal(data, &snapshot)
+		},
+		FormatNDJSON:     decodeNDJSON,
+		FormatYAMLStream: DecodeSnapshot,
+	}
+
+	tests := []struct {
+		name      string
+		resources int
+		status    *SnapshotStatus
+	}{
+		{name: "No resources", resources: 0},
+		{name: "One resource", resources: 1},
+		{name: "Many resources with status", resources: 5, status: status},
+	}
+
+	for format, decode := range decoders {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				snapshot := newTestSnapshot(tt.resources, tt.status)
+
+				data := encodeSnapshot(t, snapshot, format)
+				decoded, err := decode(data)
+				if err != nil {
+					t.Fatalf("Failed to decode %s snapshot: %v\n%s", format, err, data)
+				}
+				if decoded.APIVersion != snapshot.APIVersion || decoded.Kind != snapshot.Kind {
+					t.Errorf("Decoded type = %s/%s, want %s/%s", decoded.APIVersion, decoded.Kind, snapshot.APIVersion, snapshot.Kind)
+				}
+				if decoded.Metadata.Name != "test-snapshot" {
+					t.Errorf("Decoded metadata = %v, want name test-snapshot", decoded.Metadata)
+				}
+				if len(decoded.Resources) != tt.resources {
+					t.Fatalf("Decoded %d resources, want %d\n%s", len(decoded.Resources), tt.resources, data)
+				}
+				for i, resource := range decoded.Resources {
+					if name := fmt.Sprintf("pod-%d", i); resource.Metadata()["name"] != name {
+						t.Errorf("Resource %d name = %v, want %s", i, resource.Metadata()["name"], name)
+					}
+					if resource.Spec() == nil {
+						t.Errorf("Resource %d is missing spec", i)
+					}
+				}
+				if (decoded.Status != nil) != (tt.status != nil) {
+					t.Fatalf("Decoded status = %+v, want %+v", decoded.Status, tt.status)
+				}
+				if tt.status != nil && (len(decoded.Status.Relists) != 1 || decoded.Status.Relists[0] != tt.status.Relists[0]) {
+					t.Errorf("Decoded status = %+v, want %+v", decoded.Status, tt.status)
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshotWriterJSONEquivalent(t *testing.T) {
+	snapshot := newTestSnapshot(2, &SnapshotStatus{Relists: []Relist{{Resource: "pods", Restarts: 1}}})
+
+	data := encodeSnapshot(t, snapshot, FormatJSON)
+
+	// The streamed document should decode to the same value as a snapshot marshaled in one call
+	expected, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal snapshot: %v", err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Streamed JSON is invalid: %v\n%s", err, data)
+	}
+	json.Unmarshal(expected, &want)
+	gotBytes, _ := json.Marshal(got)
+	wantBytes, _ := json.Marshal(want)
+	if !bytes.Equal(gotBytes, wantBytes) {
+		t.Errorf("Streamed JSON = %s, want %s", gotBytes, wantBytes)
+	}
+}
+
+func TestSnapshotWriterUnknownFormat(t *testing.T) {
+	for _, format := range []string{"yml", "JSON", ""} {
+		if _, err := NewSnapshotWriter(&bytes.Buffer{}, format); err == nil {
+			t.Errorf("NewSnapshotWriter(%q) error = nil, want unsupported format", format)
+		}
+	}
+}
+
+func TestSnapshotWriterYAMLStream(t *testing.T) {
+	data := encodeSnapshot(t, newTestSnapshot(3, &SnapshotStatus{Duration: "1s"}), FormatYAMLStream)
+
+	// Every document is a plain Kubernetes object, as kubectl expects
+	documents := bytes.Split(data, []byte("---\n"))
+	if len(documents) != 4 {
+		t.Fatalf("yaml-stream has %d documents, want a header and 3 resources\n%s", len(documents), data)
+	}
+	for i, document := range documents[1:] {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal(document, &object); err != nil {
+			t.Fatalf("Document %d is invalid YAML: %v", i, err)
+		}
+		if object["apiVersion"] != "v1" || object["kind"] != "Pod" || object["metadata"] == nil {
+			t.Errorf("Document %d = %v, want a Pod", i, object)
+		}
+	}
+	if !bytes.HasPrefix(data, []byte(yamlStreamHeaderPrefix)) || !bytes.Contains(data, []byte("\n"+yamlStreamStatusPrefix)) {
+		t.Errorf("yaml-stream is missing the header or status comment\n%s", data)
+	}
+}
@@ -0,0 +1,142 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubConfigKey is the key Docker Hub credentials are stored under in
+// the Docker config
+const dockerHubConfigKey = "https://index.docker.io/v1/"
+
+// Credentials authenticate to a registry. Empty credentials are anonymous.
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken is a refresh token exchanged for access tokens, set
+	// instead of a password by some registries' docker login
+	IdentityToken string
+}
+
+// CredentialFunc returns the credentials for a registry host
+type CredentialFunc func(registry string) (Credentials, error)
+
+// dockerConfig is the part of the Docker config file holding credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// DockerConfigPath returns the path of the Docker config file, in
+// $DOCKER_CONFIG or ~/.docker
+func DockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the Docker config: %w", err)
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// DockerConfigCredentials reads the credentials for a registry that docker
+// login stored, from the Docker config file or the credential helper it
+// names. Registries without credentials are accessed anonymously.
+func DockerConfigCredentials(registry string) (Credentials, error) {
+	path, err := DockerConfigPath()
+	if err != nil {
+		return Credentials{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read the Docker config: %w", err)
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse the Docker config %s: %w", path, err)
+	}
+
+	key := registry
+	if registry == "docker.io" {
+		key = dockerHubConfigKey
+	}
+	if helper := config.CredHelpers[registry]; helper != "" {
+		return helperCredentials(helper, key)
+	}
+
+	for server, auth := range config.Auths {
+		if server != key && configHost(server) != registry {
+			continue
+		}
+		creds := Credentials{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return Credentials{}, fmt.Errorf("invalid auth for %s in the Docker config: %w", server, err)
+			}
+			creds.Username, creds.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return creds, nil
+	}
+
+	if config.CredsStore != "" {
+		return helperCredentials(config.CredsStore, key)
+	}
+	return Credentials{}, nil
+}
+
+// configHost returns the host of a Docker config key, which may be a bare
+// host or a URL such as https://registry.example.com/v1/
+func configHost(server string) string {
+	if _, rest, ok := strings.Cut(server, "://"); ok {
+		server = rest
+	}
+	host, _, _ := strings.Cut(server, "/")
+	return host
+}
+
+// helperCredentials runs docker-credential-<helper> get for a registry
+func helperCredentials(helper, server string) (Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers report registries they hold nothing for on stdout
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return Credentials{}, nil
+		}
+		return Credentials{}, fmt.Errorf("credential helper docker-credential-%s failed: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Credentials{}, fmt.Errorf("invalid output from credential helper docker-credential-%s: %w", helper, err)
+	}
+	// Helpers return identity tokens with this username
+	if out.Username == "<token>" {
+		return Credentials{IdentityToken: out.Secret}, nil
+	}
+	return Credentials{Username: out.Username, Password: out.Secret}, nil
+}
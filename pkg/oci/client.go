@@ -0,0 +1,475 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Media types of OCI manifests and the empty config of artifacts
+const (
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeEmptyJSON     = "application/vnd.oci.empty.v1+json"
+)
+
+// EmptyJSON is the content of the empty config blob of artifacts
+var EmptyJSON = []byte("{}")
+
+// maxManifestSize bounds the manifests read from a registry
+const maxManifestSize = 4 << 20
+
+// Descriptor points at a blob or manifest by digest
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Digest returns the sha256 digest of data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Error is an error response from a registry
+type Error struct {
+	StatusCode int
+	Errors     []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("registry returned status code %d", e.StatusCode)
+	}
+	messages := make([]string, len(e.Errors))
+	for i, detail := range e.Errors {
+		messages[i] = detail.Code + ": " + detail.Message
+	}
+	return fmt.Sprintf("registry returned status code %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
+
+// IsNotFound reports whether err is a response for a missing manifest, blob
+// or repository
+func IsNotFound(err error) bool {
+	var regErr *Error
+	return errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound
+}
+
+// Client is a minimal client for the OCI distribution API, enough to push
+// and pull single-layer artifacts. Registries asking for basic auth or
+// bearer tokens are authenticated with the credentials from Credentials.
+type Client struct {
+	HTTPClient  *http.Client
+	Credentials CredentialFunc
+	// PlainHTTP talks to registries over http rather than https. It is
+	// always used for localhost, as docker does.
+	PlainHTTP bool
+
+	mu sync.Mutex
+	// auth caches the Authorization header that worked for a registry and
+	// scope
+	auth map[string]string
+}
+
+// NewClient creates a new client that reads credentials from the Docker
+// config
+func NewClient() *Client {
+	return &Client{
+		HTTPClient:  &http.Client{},
+		Credentials: DockerConfigCredentials,
+		auth:        map[string]string{},
+	}
+}
+
+// PushBlob uploads size bytes read from body as a blob with the given
+// digest, unless the repository already has it
+func (c *Client) PushBlob(ctx context.Context, ref Reference, digest string, body io.ReadSeeker, size int64) error {
+	scope := pushScope(ref)
+	resp, err := c.do(ctx, ref, scope, http.MethodHead, "/blobs/"+digest, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if !IsNotFound(err) {
+		return fmt.Errorf("failed to check for blob %s: %w", digest, err)
+	}
+
+	resp, err = c.do(ctx, ref, scope, http.MethodPost, "/blobs/uploads/", nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start blob upload: %w", err)
+	}
+	resp.Body.Close()
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("registry did not return a blob upload location")
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = c.doURL(ctx, ref.Registry, scope, http.MethodPut, location, header, body, size)
+	if err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", digest, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PushManifest uploads a manifest under the reference's tag and returns
+// its digest
+func (c *Client) PushManifest(ctx context.Context, ref Reference, manifest *Manifest) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	digest := Digest(data)
+	target := ref.Tag
+	if target == "" {
+		target = digest
+	}
+
+	header := http.Header{"Content-Type": {manifest.MediaType}}
+	resp, err := c.do(ctx, ref, pushScope(ref), http.MethodPut, "/manifests/"+target, header, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to push manifest: %w", err)
+	}
+	resp.Body.Close()
+	return digest, nil
+}
+
+// FetchManifest downloads the manifest a reference points at and returns
+// it with its digest, which is checked against the reference's digest
+func (c *Client) FetchManifest(ctx context.Context, ref Reference) (*Manifest, string, error) {
+	header := http.Header{"Accept": {MediaTypeImageManifest}}
+	resp, err := c.do(ctx, ref, pullScope(ref), http.MethodGet, "/manifests/"+ref.manifestReference(), header, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, "", fmt.Errorf("manifest of %s is larger than %d bytes", ref, maxManifestSize)
+	}
+	digest := Digest(data)
+	if ref.Digest != "" && digest != ref.Digest {
+		return nil, "", fmt.Errorf("manifest of %s has digest %s, want %s", ref, digest, ref.Digest)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to parse manifest of %s: %w", ref, err)
+	}
+	if manifest.MediaType != "" && manifest.MediaType != MediaTypeImageManifest {
+		return nil, "", fmt.Errorf("%s is a %s, not an OCI image manifest", ref, manifest.MediaType)
+	}
+	return &manifest, digest, nil
+}
+
+// FetchBlob downloads a blob. The returned reader fails at the end of the
+// blob if its content does not match the descriptor's digest and size. The
+// caller closes it.
+func (c *Client) FetchBlob(ctx context.Context, ref Reference, desc Descriptor) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, ref, pullScope(ref), http.MethodGet, "/blobs/"+desc.Digest, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blob %s: %w", desc.Digest, err)
+	}
+	return &verifyingReader{body: resp.Body, desc: desc, hash: sha256.New()}, nil
+}
+
+// verifyingReader checks the digest and size of a blob as it is read
+type verifyingReader struct {
+	body io.ReadCloser
+	desc Descriptor
+	hash hash.Hash
+	read int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+	if r.read > r.desc.Size {
+		return n, fmt.Errorf("blob %s is larger than its %d bytes", r.desc.Digest, r.desc.Size)
+	}
+	if err == io.EOF {
+		if digest := "sha256:" + hex.EncodeToString(r.hash.Sum(nil)); digest != r.desc.Digest || r.read != r.desc.Size {
+			return n, fmt.Errorf("blob %s does not match its digest", r.desc.Digest)
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.body.Close()
+}
+
+// pushScope and pullScope are the token scopes for a repository
+func pushScope(ref Reference) string {
+	return "repository:" + ref.Repository + ":pull,push"
+}
+
+func pullScope(ref Reference) string {
+	return "repository:" + ref.Repository + ":pull"
+}
+
+// apiHost returns the host serving the registry API, which for Docker Hub
+// differs from the registry name
+func apiHost(registry string) string {
+	if registry == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
+
+// do sends a request for a path under the reference's repository
+func (c *Client) do(ctx context.Context, ref Reference, scope, method, path string, header http.Header, body *bytes.Reader) (*http.Response, error) {
+	scheme := "https"
+	if c.plainHTTP(ref.Registry) {
+		scheme = "http"
+	}
+	u := &url.URL{Scheme: scheme, Host: apiHost(ref.Registry), Path: "/v2/" + ref.Repository + path}
+	if body == nil {
+		return c.doURL(ctx, ref.Registry, scope, method, u, header, nil, 0)
+	}
+	return c.doURL(ctx, ref.Registry, scope, method, u, header, body, body.Size())
+}
+
+// plainHTTP reports whether to talk to a registry over http
+func (c *Client) plainHTTP(registry string) bool {
+	host := registry
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+	return c.PlainHTTP || host == "localhost" || host == "127.0.0.1" || host == "[::1]"
+}
+
+// doURL sends a request, authenticating and retrying once if the registry
+// asks for credentials, and returns an *Error for non-2xx responses
+func (c *Client) doURL(ctx context.Context, registry, scope, method string, u *url.URL, header http.Header, body io.ReadSeeker, size int64) (*http.Response, error) {
+	cacheKey := registry + " " + scope
+	c.mu.Lock()
+	auth := c.auth[cacheKey]
+	c.mu.Unlock()
+
+	resp, err := c.send(ctx, method, u, header, body, size, auth)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if auth, err = c.authorize(ctx, registry, scope, challenge); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.auth == nil {
+			c.auth = map[string]string{}
+		}
+		c.auth[cacheKey] = auth
+		c.mu.Unlock()
+
+		if body != nil {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to send request to registry: %w", err)
+			}
+		}
+		if resp, err = c.send(ctx, method, u, header, body, size, auth); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		regErr := &Error{StatusCode: resp.StatusCode}
+		// HEAD responses have no body to explain the error
+		if data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil && len(data) > 0 {
+			json.Unmarshal(data, regErr)
+		}
+		return nil, fmt.Errorf("%s %s: %w", method, u.Redacted(), regErr)
+	}
+	return resp, nil
+}
+
+// send sends one request
+func (c *Client) send(ctx context.Context, method string, u *url.URL, header http.Header, body io.ReadSeeker, size int64, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to registry: %w", err)
+	}
+	return resp, nil
+}
+
+// authorize answers a WWW-Authenticate challenge with an Authorization
+// header: the credentials for Basic, or a token fetched with them for Bearer
+func (c *Client) authorize(ctx context.Context, registry, scope, challenge string) (string, error) {
+	var creds Credentials
+	if c.Credentials != nil {
+		var err error
+		if creds, err = c.Credentials(registry); err != nil {
+			return "", err
+		}
+	}
+
+	authScheme, params := parseChallenge(challenge)
+	switch authScheme {
+	case "basic":
+		if creds.Username == "" {
+			return "", fmt.Errorf("registry %s requires credentials, run docker login %s", registry, registry)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, registry, scope, params, creds)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("registry %s requires unsupported authentication %q", registry, challenge)
+	}
+}
+
+// fetchToken gets a bearer token from the registry's token service, with
+// an OAuth2 refresh token request for identity tokens and a GET otherwise
+func (c *Client) fetchToken(ctx context.Context, registry, scope string, params map[string]string, creds Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("registry %s returned an invalid token realm %q", registry, params["realm"])
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+
+	var req *http.Request
+	if creds.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {params["service"]},
+			"scope":         {scope},
+			"client_id":     {"kubectl-meshsync-snapshot"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err == nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token for registry %s: %w", registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized && creds == (Credentials{}) {
+			return "", fmt.Errorf("registry %s requires credentials, run docker login %s", registry, registry)
+		}
+		return "", fmt.Errorf("failed to get a token for registry %s: token service returned status code %d", registry, resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse token for registry %s: %w", registry, err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", fmt.Errorf("token service of registry %s returned no token", registry)
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry" into its
+// lowercased scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	authScheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		var key string
+		key, rest, _ = strings.Cut(rest, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.ReplaceAll(rest[1:min(end, len(rest))], `\"`, `"`)
+			rest = rest[min(end+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		params[key] = strings.TrimSpace(value)
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return strings.ToLower(authScheme), params
+}
@@ -0,0 +1,83 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge  string
+		wantScheme string
+		wantParams map[string]string
+	}{
+		{
+			challenge:  `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`,
+			wantScheme: "bearer",
+			wantParams: map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:a/b:pull"},
+		},
+		{
+			challenge:  `Basic realm="Registry Realm"`,
+			wantScheme: "basic",
+			wantParams: map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			challenge:  `Bearer realm=https://auth.example.com/token, service=registry`,
+			wantScheme: "bearer",
+			wantParams: map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.challenge, func(t *testing.T) {
+			scheme, params := parseChallenge(tt.challenge)
+			if scheme != tt.wantScheme || !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("parseChallenge() = %s, %v, want %s, %v", scheme, params, tt.wantScheme, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	// No config means anonymous access
+	if creds, err := DockerConfigCredentials("ghcr.io"); err != nil || creds != (Credentials{}) {
+		t.Errorf("DockerConfigCredentials() without a config = %+v, %v, want none", creds, err)
+	}
+
+	config := `{
+		"auths": {
+			"ghcr.io": {"auth": "dXNlcjpzZWNyZXQ="},
+			"https://registry.example.com/v1/": {"identitytoken": "refresh"},
+			"https://index.docker.io/v1/": {"username": "hub", "password": "hub-secret"}
+		}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		registry string
+		want     Credentials
+	}{
+		{registry: "ghcr.io", want: Credentials{Username: "user", Password: "secret"}},
+		{registry: "registry.example.com", want: Credentials{IdentityToken: "refresh"}},
+		{registry: "docker.io", want: Credentials{Username: "hub", Password: "hub-secret"}},
+		{registry: "localhost:5000", want: Credentials{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			got, err := DockerConfigCredentials(tt.registry)
+			if err != nil {
+				t.Fatalf("DockerConfigCredentials() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DockerConfigCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
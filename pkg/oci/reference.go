@@ -0,0 +1,87 @@
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Scheme is the URL scheme of registry references, oci://registry/repo:tag
+const Scheme = "oci"
+
+// DefaultTag is the tag used when a reference has neither tag nor digest
+const DefaultTag = "latest"
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference names a manifest in a registry repository by tag or digest
+type Reference struct {
+	// Registry is the host, and port if any, of the registry
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses a reference such as oci://ghcr.io/org/snapshots:prod
+// or localhost:5000/snapshots@sha256:..., with or without the oci:// prefix.
+// The registry host must be given, since there is no default registry.
+func ParseReference(s string) (Reference, error) {
+	name := strings.TrimPrefix(s, Scheme+"://")
+	registry, repository, ok := strings.Cut(name, "/")
+	if !ok || registry == "" || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		return Reference{}, fmt.Errorf("invalid OCI reference %q, want oci://registry/repository:tag", s)
+	}
+
+	ref := Reference{Registry: registry}
+	if repository, ref.Digest, ok = strings.Cut(repository, "@"); ok {
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid digest %q in OCI reference %q", ref.Digest, s)
+		}
+	}
+	// A colon after the last slash starts the tag
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, ref.Tag = repository[:i], repository[i+1:]
+		if !tagPattern.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid tag %q in OCI reference %q", ref.Tag, s)
+		}
+	}
+	if !repositoryPattern.MatchString(repository) {
+		return Reference{}, fmt.Errorf("invalid repository %q in OCI reference %q, repositories are lowercase", repository, s)
+	}
+	ref.Repository = repository
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// IsReference reports whether s is an oci:// reference
+func IsReference(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+// String returns the reference as oci://registry/repository:tag@digest
+func (r Reference) String() string {
+	s := Scheme + "://" + r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// manifestReference returns what the manifest is fetched by: the digest
+// if known, else the tag
+func (r Reference) manifestReference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
@@ -0,0 +1,55 @@
+package oci
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		input   string
+		want    Reference
+		wantErr string
+	}{
+		{input: "oci://ghcr.io/org/snapshots:prod", want: Reference{Registry: "ghcr.io", Repository: "org/snapshots", Tag: "prod"}},
+		{input: "localhost:5000/snapshots", want: Reference{Registry: "localhost:5000", Repository: "snapshots", Tag: "latest"}},
+		{input: "oci://localhost/snapshots@" + digest, want: Reference{Registry: "localhost", Repository: "snapshots", Digest: digest}},
+		{input: "oci://registry.example.com:443/a/b:v1@" + digest, want: Reference{Registry: "registry.example.com:443", Repository: "a/b", Tag: "v1", Digest: digest}},
+		{input: "oci://snapshots:prod", wantErr: "want oci://registry/repository:tag"},
+		{input: "oci://library/snapshots", wantErr: "want oci://registry/repository:tag"},
+		{input: "oci://ghcr.io/Org/snapshots", wantErr: "repositories are lowercase"},
+		{input: "oci://ghcr.io/snapshots:bad/tag", wantErr: "invalid repository"},
+		{input: "oci://ghcr.io/snapshots:-tag", wantErr: "invalid tag"},
+		{input: "oci://ghcr.io/snapshots@sha256:abc", wantErr: "invalid digest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseReference(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseReference() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseReference() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	ref := Reference{Registry: "ghcr.io", Repository: "org/snapshots", Tag: "prod"}
+	if got := ref.String(); got != "oci://ghcr.io/org/snapshots:prod" {
+		t.Errorf("String() = %s", got)
+	}
+	parsed, err := ParseReference(ref.String())
+	if err != nil || parsed != ref {
+		t.Errorf("ParseReference(String()) = %+v, %v, want %+v", parsed, err, ref)
+	}
+}
@@ -0,0 +1,183 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultRegion is the region used when none is configured
+const DefaultRegion = "us-east-1"
+
+// Server-side encryption modes for Config.SSE
+const (
+	SSES3  = "AES256"
+	SSEKMS = "aws:kms"
+)
+
+// Config contains the settings for an S3-compatible object store.
+// Credentials are not part of it: they are found by the default AWS
+// credential chain, from the environment, the shared config and
+// credentials files of AWS_PROFILE, a web identity token such as the one
+// of an IRSA service account, or a container or instance role.
+type Config struct {
+	// Endpoint is the base URL of the service, such as http://minio:9000.
+	// Empty means the endpoint from AWS_ENDPOINT_URL_S3, AWS_ENDPOINT_URL
+	// or the shared config, or AWS S3 in Region.
+	Endpoint string
+	// Region overrides the region from AWS_REGION or the shared config;
+	// DefaultRegion is used when neither sets one
+	Region string
+	// PathStyle addresses buckets as Endpoint/bucket rather than as
+	// bucket.Endpoint, as MinIO and most other S3-compatible stores need
+	PathStyle bool
+
+	// SSE requests server-side encryption with SSES3 or SSEKMS, using
+	// SSEKMSKeyID for SSEKMS if set and the bucket's default key if not
+	SSE         string
+	SSEKMSKeyID string
+}
+
+// IsNotFound reports whether err is a response for a missing object or bucket
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsPreconditionFailed reports whether err is the response to a conditional
+// request whose condition did not hold, such as a put with IfNoneMatch for
+// an object that exists
+func IsPreconditionFailed(err error) bool {
+	return hasStatus(err, http.StatusPreconditionFailed)
+}
+
+// hasStatus reports whether err is a response with the given status code
+func hasStatus(err error, code int) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == code
+}
+
+// Client is a client for S3-compatible object stores. Requests are retried
+// on throttling and transient errors, and large objects are uploaded in
+// parts.
+type Client struct {
+	Config
+	s3       *s3.Client
+	uploader *manager.Uploader
+}
+
+// NewClient creates a new client, loading the AWS configuration and
+// credential chain
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Endpoint != "" {
+		endpoint, err := url.Parse(cfg.Endpoint)
+		if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return nil, fmt.Errorf("invalid object store endpoint %q, want a URL such as https://s3.amazonaws.com", cfg.Endpoint)
+		}
+	}
+	switch cfg.SSE {
+	case "", SSES3, SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q, want %s or %s", cfg.SSE, SSES3, SSEKMS)
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region != "" {
+		awsCfg.Region = cfg.Region
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = DefaultRegion
+	}
+	cfg.Region = awsCfg.Region
+	// Not every S3-compatible store accepts the checksums the SDK adds by
+	// default, so they are only sent where the API requires them
+	awsCfg.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+	awsCfg.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
+	return &Client{
+		Config:   cfg,
+		s3:       client,
+		uploader: manager.NewUploader(client),
+	}, nil
+}
+
+// PutOptions contains options for PutObject
+type PutOptions struct {
+	// IfNoneMatch makes the put fail with a precondition failed error if
+	// the object already exists
+	IfNoneMatch bool
+	ContentType string
+}
+
+// PutObject uploads body to bucket/key, in parts when it is larger than
+// manager.DefaultUploadPartSize. A multipart upload that fails is aborted.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body io.ReadSeeker, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.IfNoneMatch {
+		// Multipart uploads carry the condition to the request completing them
+		input.IfNoneMatch = aws.String("*")
+	}
+	if c.SSE != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(c.SSE)
+		if c.SSE == SSEKMS && c.SSEKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(c.SSEKMSKeyID)
+		}
+	}
+
+	if _, err := c.uploader.Upload(ctx, input); err != nil {
+		return fmt.Errorf("PUT s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// GetObject downloads bucket/key. The caller closes the returned body.
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	output, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GET s3://%s/%s: %w", bucket, key, err)
+	}
+	return output.Body, nil
+}
+
+// HeadObject reports whether bucket/key exists
+func (c *Client) HeadObject(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := c.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("HEAD s3://%s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
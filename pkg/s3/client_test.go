@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_PROFILE", "")
+
+	client, err := NewClient(context.Background(), Config{Endpoint: "http://minio:9000", PathStyle: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if client.Region != DefaultRegion {
+		t.Errorf("Region = %q, want %q without one configured", client.Region, DefaultRegion)
+	}
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	if client, _ := NewClient(context.Background(), Config{}); client == nil || client.Region != "eu-west-1" {
+		t.Errorf("NewClient() did not read the region from AWS_REGION")
+	}
+	if client, _ := NewClient(context.Background(), Config{Region: "ap-south-1"}); client == nil || client.Region != "ap-south-1" {
+		t.Errorf("NewClient() did not prefer the configured region")
+	}
+}
+
+func TestNewClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "Bad endpoint", config: Config{Endpoint: "minio:9000"}, wantErr: "invalid object store endpoint"},
+		{name: "Bad encryption", config: Config{SSE: "des"}, wantErr: "unknown server-side encryption"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(context.Background(), tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewClient() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
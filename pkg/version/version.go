@@ -0,0 +1,5 @@
+package version
+
+// Version is the plugin version, set at build time with
+// -ldflags "-X github.com/Prajwal-kp-18/kubectl-meshsync-snapshot/pkg/version.Version=v0.1.0"
+var Version = "dev"